@@ -0,0 +1,741 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	wgg "github.com/CoreUnit-NET/wgg/internal"
+)
+
+// Mesh is the configured mesh of a command run.
+type Mesh struct {
+	Inventory  *wgg.Inventory
+	Subnet     *net.IPNet
+	Subnet6    *net.IPNet
+	NodeList   []wgg.WggNode
+	ClientList []wgg.WggClient
+	Policy     *wgg.AllocationPolicy
+	Ledger     *wgg.Ledger
+	Interfaces wgg.InterfaceSettings
+	Keepalives wgg.KeepaliveSettings
+	Exits      wgg.ExitSettings
+	Routes     wgg.RouteSettings
+	Hubs       wgg.HubSettings
+}
+
+// LoadMesh loads the inventory, validates the configuration, loads the
+// subnets, nodes and clients, assigns their addresses from the ledger and
+// layers the interface options, keepalive intervals, exit nodes, routes and
+// hub groups.
+func LoadMesh() (*Mesh, error) {
+	inventory, err := wgg.InitInventory("")
+	if err != nil {
+		return nil, err
+	}
+
+	err = wgg.ValidateConfig(inventory)
+	if err != nil {
+		return nil, err
+	}
+
+	subnet, err := wgg.InitSubnet(inventory)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeList, err := wgg.InitNodeList(inventory)
+	if err != nil {
+		return nil, err
+	}
+
+	clientList, err := wgg.InitClientList(inventory)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := wgg.InitAllocationPolicy(inventory, subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	ledger, err := wgg.InitLedger(inventory)
+	if err != nil {
+		return nil, err
+	}
+
+	err = wgg.AssignAddresses(ledger, policy, nodeList, clientList)
+	if err != nil {
+		return nil, err
+	}
+
+	subnet6, err := wgg.InitSubnet6(inventory, subnet, ledger)
+	if err != nil {
+		return nil, err
+	}
+
+	interfaces, err := wgg.InitInterfaceSettings(inventory)
+	if err != nil {
+		return nil, err
+	}
+
+	keepalives, err := wgg.InitKeepaliveSettings(inventory, nodeList, clientList)
+	if err != nil {
+		return nil, err
+	}
+
+	exits, err := wgg.InitExitSettings(inventory, nodeList, clientList)
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := wgg.InitRouteSettings(inventory, subnet, subnet6, nodeList)
+	if err != nil {
+		return nil, err
+	}
+
+	hubs, err := wgg.InitHubSettings(inventory, subnet, subnet6, nodeList, clientList)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Mesh{
+		Inventory:  inventory,
+		Subnet:     subnet,
+		Subnet6:    subnet6,
+		NodeList:   nodeList,
+		ClientList: clientList,
+		Policy:     policy,
+		Ledger:     ledger,
+		Interfaces: interfaces,
+		Keepalives: keepalives,
+		Exits:      exits,
+		Routes:     routes,
+		Hubs:       hubs,
+	}, nil
+}
+
+// NoteUnallocatedSubnet6 tells the user of a command that does not save the
+// ledger that the shown IPv6 prefix is not allocated yet.
+func NoteUnallocatedSubnet6(mesh *Mesh) {
+	if !mesh.Ledger.Subnet6Generated {
+		return
+	}
+
+	fmt.Fprintln(
+		os.Stderr,
+		"Note: the IPv6 prefix "+mesh.Subnet6.String()+" is not allocated yet, "+
+			"every run picks another one until '"+ShortName+" generate' records it in "+
+			mesh.Ledger.Path,
+	)
+}
+
+// Keys is the opened key storage of a command run.
+type Keys struct {
+	OutDir  string
+	KeyDir  string
+	Options wgg.GenOptions
+	Store   wgg.KeyStore
+}
+
+// meshGenOptions returns the GenOptions of the env vars together with the
+// settings of the mesh.
+func meshGenOptions(mesh *Mesh) (wgg.GenOptions, error) {
+	options, err := wgg.InitGenOptions()
+	if err != nil {
+		return options, err
+	}
+	options.Subnet6 = mesh.Subnet6
+	options.Interfaces = mesh.Interfaces
+	options.Keepalives = mesh.Keepalives
+	options.Exits = mesh.Exits
+	options.Routes = mesh.Routes
+	options.Hubs = mesh.Hubs
+
+	return options, nil
+}
+
+// OpenKeys initializes the out dir and the KeyStore, moves the keys of newly
+// named clients and checks the local key pairs.
+func OpenKeys(mesh *Mesh) (*Keys, error) {
+	outDir, keyDir, err := wgg.InitOutDir(mesh.Inventory)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := meshGenOptions(mesh)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := wgg.InitKeyStore(keyDir)
+	if err != nil {
+		return nil, err
+	}
+
+	_, derived := store.(*wgg.DerivedKeyStore)
+	if derived && options.NodeSideKeys {
+		return nil, errors.New("a master seed can not be combined with WGG_NODE_SIDE_KEYS")
+	}
+	keyStore := wgg.NewKeyring(store)
+
+	migrated, err := wgg.MigrateClientKeys(keyStore, mesh.ClientList, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, client := range migrated {
+		fmt.Fprintln(
+			os.Stderr,
+			"Moved the keys of "+wgg.NumberedClientID(client.ID)+
+				" to the newly named client "+client.Name,
+		)
+	}
+
+	err = wgg.CheckWireGuardKeyPairs(
+		keyStore,
+		LocalTargets(mesh, options),
+		ConfirmKeyRepair,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Keys{
+		OutDir:  outDir,
+		KeyDir:  keyDir,
+		Options: options,
+		Store:   keyStore,
+	}, nil
+}
+
+// OpenReadOnlyKeys opens the existing KeyStore without changing anything:
+// no key is created, moved or repaired, missing keys are errors.
+func OpenReadOnlyKeys(mesh *Mesh) (*Keys, error) {
+	outDir, err := wgg.OutDirPath(mesh.Inventory)
+	if err != nil {
+		return nil, err
+	}
+	keyDir := outDir + "/keys"
+
+	options, err := meshGenOptions(mesh)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := wgg.InitReadOnlyKeyStore(keyDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Keys{
+		OutDir:  outDir,
+		KeyDir:  keyDir,
+		Options: options,
+		Store:   store,
+	}, nil
+}
+
+// LocalTargets returns the targets whose private keys are held locally. With
+// node-side keys only the clients have local private keys.
+func LocalTargets(mesh *Mesh, options wgg.GenOptions) []wgg.WggTarget {
+	if options.NodeSideKeys {
+		return wgg.Targets(nil, mesh.ClientList)
+	}
+
+	return wgg.Targets(mesh.NodeList, mesh.ClientList)
+}
+
+// GenerateConfigs fetches missing node-side keys, rewrites all configs in
+// the out dir and saves the ledger. The nodes in rotated get fresh node-side
+// keys.
+func GenerateConfigs(mesh *Mesh, keys *Keys, rotated []wgg.WggTarget) error {
+	if keys.Options.NodeSideKeys {
+		err := wgg.SyncNodeKeys(
+			keys.Store,
+			mesh.NodeList,
+			rotated,
+			keys.Options.NodeKeyPath,
+			time.Now(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	targets := wgg.Targets(mesh.NodeList, mesh.ClientList)
+	err := wgg.RecordLedgerKeys(mesh.Ledger, keys.Store, targets)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Output dir: " + keys.OutDir)
+	err = wgg.CleanUpOutDir(keys.OutDir)
+	if err != nil {
+		return err
+	}
+
+	err = wgg.GenerateNodeConfigs(
+		mesh.Subnet,
+		keys.OutDir,
+		keys.Store,
+		mesh.NodeList,
+		mesh.ClientList,
+		keys.Options,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = wgg.GenerateClientConfigs(
+		mesh.Subnet,
+		keys.OutDir,
+		keys.Store,
+		mesh.NodeList,
+		mesh.ClientList,
+		keys.Options,
+	)
+	if err != nil {
+		return err
+	}
+
+	// records the keys generated with the configs
+	err = wgg.RecordLedgerKeys(mesh.Ledger, keys.Store, targets)
+	if err != nil {
+		return err
+	}
+
+	err = wgg.SaveLedger(mesh.Ledger)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Everything is ready in " + keys.OutDir)
+
+	return nil
+}
+
+// GenerateCommand implements "generate".
+func GenerateCommand(args []string) error {
+	flags := NewFlagSet("generate", "")
+	resolveCheck := flags.Bool(
+		"resolve-check",
+		false,
+		"warn about node hostnames that do not resolve",
+	)
+	_, err := ParseFlags(flags, args, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	PrintVersion()
+
+	mesh, err := LoadMesh()
+	if err != nil {
+		return err
+	}
+
+	wgg.PrintNodes(mesh.Subnet, mesh.Subnet6, mesh.NodeList)
+
+	if *resolveCheck {
+		warnings := wgg.CheckNodeResolution(
+			context.Background(),
+			net.DefaultResolver,
+			mesh.NodeList,
+		)
+		for _, warning := range warnings {
+			fmt.Println("Warning: " + warning)
+		}
+	}
+
+	wgg.PrintClients(mesh.Subnet, mesh.Subnet6, mesh.ClientList)
+
+	keys, err := OpenKeys(mesh)
+	if err != nil {
+		return err
+	}
+
+	return GenerateConfigs(mesh, keys, nil)
+}
+
+// ListCommand implements "list <nodes|clients>".
+func ListCommand(args []string) error {
+	flags := NewFlagSet("list", "<nodes|clients>")
+	positional, err := ParseFlags(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	mesh, err := LoadMesh()
+	if err != nil {
+		return err
+	}
+	NoteUnallocatedSubnet6(mesh)
+
+	switch positional[0] {
+	case "nodes":
+		wgg.PrintNodes(mesh.Subnet, mesh.Subnet6, mesh.NodeList)
+	case "clients":
+		wgg.PrintClients(mesh.Subnet, mesh.Subnet6, mesh.ClientList)
+	default:
+		return UsageError{"unknown list '" + positional[0] + "', expected nodes or clients"}
+	}
+
+	return nil
+}
+
+// ShowCommand implements "show <target-id>".
+func ShowCommand(args []string) error {
+	flags := NewFlagSet("show", "<target-id>")
+	positional, err := ParseFlags(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	mesh, err := LoadMesh()
+	if err != nil {
+		return err
+	}
+
+	targets, err := wgg.SelectTargets(positional[0], mesh.NodeList, mesh.ClientList)
+	if err != nil {
+		return UsageError{err.Error()}
+	} else if len(targets) != 1 {
+		return UsageError{"show needs a single target ID, not '" + positional[0] + "'"}
+	}
+	NoteUnallocatedSubnet6(mesh)
+
+	keys, err := OpenReadOnlyKeys(mesh)
+	if err != nil {
+		return err
+	}
+
+	var conf string
+	switch target := targets[0].(type) {
+	case wgg.WggNode:
+		conf, err = wgg.RenderNodeConfig(
+			mesh.Subnet,
+			keys.Store,
+			target,
+			mesh.NodeList,
+			mesh.ClientList,
+			keys.Options,
+		)
+	case wgg.WggClient:
+		conf, err = wgg.RenderClientConfig(
+			mesh.Subnet,
+			keys.Store,
+			target,
+			mesh.NodeList,
+			keys.Options,
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(conf)
+
+	return nil
+}
+
+// errNoInventory is returned by the commands that edit the inventory.
+var errNoInventory = UsageError{
+	"this command edits the inventory file, set -inventory or WGG_INVENTORY " +
+		"(without inventory, change WGG_CLIENT_COUNT instead)",
+}
+
+// AddClientCommand implements "add-client".
+func AddClientCommand(args []string) error {
+	entry := wgg.InventoryClient{}
+	tags := ""
+
+	flags := NewFlagSet("add-client", "")
+	flags.StringVar(&entry.Name, "name", "", "unique name of the client")
+	flags.StringVar(&entry.Owner, "owner", "", "owner of the client")
+	flags.StringVar(&entry.Description, "description", "", "description of the client")
+	flags.StringVar(&tags, "tags", "", "comma separated tags of the client")
+	flags.StringVar(&entry.EndpointPreference, "prefer", "", "endpoint of dual-stack nodes: v4, v6 or v6-first")
+	flags.StringVar(&entry.Address, "address", "", "static address of the client")
+	_, err := ParseFlags(flags, args, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	if len(tags) > 0 {
+		entry.Tags = wgg.SplitList(tags)
+	}
+
+	mesh, err := LoadMesh()
+	if err != nil {
+		return err
+	} else if mesh.Inventory == nil {
+		return errNoInventory
+	}
+
+	wgg.AddInventoryClient(mesh.Inventory, entry)
+
+	// validates the new entry against the existing ones
+	clientList, err := wgg.InitClientList(mesh.Inventory)
+	if err != nil {
+		return UsageError{err.Error()}
+	}
+
+	policy, err := wgg.InitAllocationPolicy(mesh.Inventory, mesh.Subnet)
+	if err != nil {
+		return err
+	}
+
+	err = wgg.AssignAddresses(mesh.Ledger, policy, mesh.NodeList, clientList)
+	if err != nil {
+		return err
+	}
+
+	err = wgg.SaveInventory(mesh.Inventory)
+	if err != nil {
+		return err
+	}
+
+	// records the printed address, so it does not change until generate
+	_, _, err = wgg.InitOutDir(mesh.Inventory)
+	if err != nil {
+		return err
+	}
+	err = wgg.SaveLedger(mesh.Ledger)
+	if err != nil {
+		return err
+	}
+
+	client := clientList[len(clientList)-1]
+	fmt.Println(
+		"Added client " + client.TargetID() +
+			" with address " + client.WireGuardSubnetIP(mesh.Subnet).String() +
+			" to " + mesh.Inventory.Path,
+	)
+	fmt.Println("Run '" + ShortName + " generate' and deploy the node configs and the new client config.")
+
+	return nil
+}
+
+// RemoveClientCommand implements "remove-client <target-id>".
+func RemoveClientCommand(args []string) error {
+	flags := NewFlagSet("remove-client", "<target-id>")
+	positional, err := ParseFlags(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	mesh, err := LoadMesh()
+	if err != nil {
+		return err
+	} else if mesh.Inventory == nil {
+		return errNoInventory
+	}
+
+	var client *wgg.WggClient
+	for i := range mesh.ClientList {
+		if mesh.ClientList[i].TargetID() == positional[0] {
+			client = &mesh.ClientList[i]
+		}
+	}
+	if client == nil {
+		return UsageError{"unknown client '" + positional[0] + "'"}
+	}
+
+	err = wgg.RemoveInventoryClient(mesh.Inventory, client.ID)
+	if err != nil {
+		return err
+	}
+
+	keys, err := OpenKeys(mesh)
+	if err != nil {
+		return err
+	}
+
+	err = keys.Store.ArchiveKeys(client.TargetID(), time.Now())
+	if err != nil {
+		return fmt.Errorf("error archiving keys of client '%s': %w", client.TargetID(), err)
+	}
+
+	err = wgg.SaveInventory(mesh.Inventory)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Removed client " + client.TargetID() + " from " + mesh.Inventory.Path)
+	fmt.Println("Run '" + ShortName + " generate' and deploy the node configs.")
+
+	return nil
+}
+
+// KeysCommand implements "keys".
+func KeysCommand(args []string) error {
+	flags := NewFlagSet("keys", "")
+	_, err := ParseFlags(flags, args, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	mesh, err := LoadMesh()
+	if err != nil {
+		return err
+	}
+
+	keys, err := OpenReadOnlyKeys(mesh)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range wgg.Targets(mesh.NodeList, mesh.ClientList) {
+		publicKey, err := keys.Store.LoadPublicKey(target.TargetID())
+		if errors.Is(err, wgg.ErrKeyNotFound) {
+			return fmt.Errorf("%s has no public key yet, run generate first: %w", target.TargetID(), err)
+		} else if err != nil {
+			return fmt.Errorf("public key of target '%s': %w", target.TargetID(), err)
+		}
+
+		fmt.Println(target.TargetID() + " " + publicKey)
+	}
+
+	return nil
+}
+
+// RotateCommand implements "rotate <all|nodes|clients|target-id>", it
+// re-keys the selected targets before the configs are regenerated.
+func RotateCommand(args []string) error {
+	flags := NewFlagSet("rotate", "<all|nodes|clients|target-id>")
+	positional, err := ParseFlags(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	PrintVersion()
+
+	mesh, err := LoadMesh()
+	if err != nil {
+		return err
+	}
+
+	rotated, err := wgg.SelectTargets(positional[0], mesh.NodeList, mesh.ClientList)
+	if err != nil {
+		return UsageError{err.Error()}
+	}
+
+	keys, err := OpenKeys(mesh)
+	if err != nil {
+		return err
+	}
+
+	localRotated := []wgg.WggTarget{}
+	for _, target := range rotated {
+		if !target.IsNode() || !keys.Options.NodeSideKeys {
+			localRotated = append(localRotated, target)
+		}
+	}
+
+	err = wgg.RotateWireGuardKeyPairs(keys.Store, localRotated, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = GenerateConfigs(mesh, keys, rotated)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Deploy the new configs to:")
+	for _, target := range wgg.AffectedTargets(rotated, mesh.NodeList, mesh.ClientList) {
+		fmt.Println("- " + target.TargetID())
+	}
+
+	return nil
+}
+
+// ImportCommand implements "import <wg-quick.conf>...", it adopts the keys
+// of an existing mesh.
+func ImportCommand(args []string) error {
+	flags := NewFlagSet("import", "<wg-quick.conf>...")
+	positional, err := ParseFlags(flags, args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	PrintVersion()
+
+	mesh, err := LoadMesh()
+	if err != nil {
+		return err
+	}
+
+	keys, err := OpenKeys(mesh)
+	if err != nil {
+		return err
+	}
+
+	report, err := wgg.ImportWgQuickConfs(
+		positional,
+		mesh.Subnet,
+		mesh.NodeList,
+		mesh.ClientList,
+		keys.Store,
+	)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Imported:")
+	for _, imported := range report.Imported {
+		fmt.Println("- " + imported)
+	}
+
+	if len(report.Problems) > 0 {
+		fmt.Println("Problems:")
+		for _, problem := range report.Problems {
+			fmt.Println("- " + problem)
+		}
+
+		return ErrProblems
+	}
+
+	return nil
+}
+
+// ValidateCommand implements "validate", it only loads and validates the
+// configuration, so it fits CI pipelines.
+func ValidateCommand(args []string) error {
+	flags := NewFlagSet("validate", "")
+	_, err := ParseFlags(flags, args, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	mesh, err := LoadMesh()
+	if err != nil {
+		return err
+	}
+	NoteUnallocatedSubnet6(mesh)
+
+	fmt.Printf(
+		"Configuration is valid: %d nodes and %d clients in %s\n",
+		len(mesh.NodeList),
+		len(mesh.ClientList),
+		mesh.Subnet,
+	)
+
+	return nil
+}
+
+// VersionCommand implements "version".
+func VersionCommand(args []string) error {
+	flags := NewFlagSet("version", "")
+	_, err := ParseFlags(flags, args, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(DisplayName + " version v" + Version + ", build " + Commit)
+
+	return nil
+}
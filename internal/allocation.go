@@ -0,0 +1,395 @@
+package wgg
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/CoreUnit-NET/wgg/lib/netutils"
+)
+
+// AllocationMode selects the address a new target is offered first.
+type AllocationMode string
+
+const (
+	// AllocationSequential derives the address from the target ID, nodes
+	// count up from the start of their prefix and clients down from its end.
+	AllocationSequential AllocationMode = "sequential"
+	// AllocationSparse derives the address from a hash of the target ID,
+	// so it does not depend on the order of the targets.
+	AllocationSparse AllocationMode = "sparse"
+)
+
+// ParseAllocationMode parses "", "sequential" or "sparse". The empty string
+// is AllocationSequential.
+func ParseAllocationMode(value string) (AllocationMode, error) {
+	switch AllocationMode(value) {
+	case "", AllocationSequential:
+		return AllocationSequential, nil
+	case AllocationSparse:
+		return AllocationSparse, nil
+	}
+
+	return AllocationSequential, errors.New(
+		"invalid allocation '" + value + "', expected sequential or sparse",
+	)
+}
+
+// AllocationPolicy decides which address a target without a ledger entry
+// gets.
+type AllocationPolicy struct {
+	Subnet *net.IPNet
+
+	// NodePrefix and ClientPrefix are the parts of Subnet new node and
+	// client addresses are taken from. If only one of both is set, the other
+	// role gets the rest of Subnet, if none is set, both share Subnet.
+	NodePrefix   *net.IPNet
+	ClientPrefix *net.IPNet
+
+	// Reserved ranges are never handed out.
+	Reserved []netutils.IPRange
+
+	Mode AllocationMode
+
+	// Static maps target IDs to their static addresses, StaticVariables to
+	// the variable that sets it.
+	Static          map[string]net.IP
+	StaticVariables map[string]string
+}
+
+// policySetting returns the inventory value and its variable name if it is
+// set, otherwise the value of the env var.
+func policySetting(inventoryValue string, inventoryVariable string, env string) (string, string) {
+	if len(inventoryValue) > 0 {
+		return inventoryValue, inventoryVariable
+	}
+
+	return os.Getenv(env), env
+}
+
+// InitAllocationPolicy returns the AllocationPolicy of the inventory, or of
+// the WGG_NODE_PREFIX, WGG_CLIENT_PREFIX, WGG_RESERVED, WGG_ALLOCATION and
+// WGG_STATIC_ADDRESSES env vars for everything the inventory does not set.
+//
+// WGG_RESERVED is a comma separated list of "<first>-<last>" ranges, CIDR
+// subnets or single addresses, WGG_STATIC_ADDRESSES a comma separated list
+// of "<target-id>=<address>". Static addresses of the inventory entries take
+// precedence over WGG_STATIC_ADDRESSES.
+//
+// Every setting is validated against the subnet, all problems are returned
+// as *ValidationError.
+func InitAllocationPolicy(inventory *Inventory, subnet *net.IPNet) (*AllocationPolicy, error) {
+	validation := &ValidationError{}
+	policy := &AllocationPolicy{
+		Subnet:          subnet,
+		Static:          map[string]net.IP{},
+		StaticVariables: map[string]string{},
+	}
+	if inventory == nil {
+		inventory = &Inventory{}
+	}
+
+	parsePrefix := func(value string, variable string) *net.IPNet {
+		if len(value) <= 0 {
+			return nil
+		}
+
+		_, prefix, err := net.ParseCIDR(value)
+		if err != nil {
+			validation.problem(variable, "invalid prefix '%s': %s", value, err.Error())
+			return nil
+		} else if !netutils.ContainsSubnet(subnet, prefix) {
+			validation.problem(variable, "%s is not within the subnet %s", prefix, subnet)
+			return nil
+		}
+
+		return prefix
+	}
+
+	policy.NodePrefix = parsePrefix(policySetting(inventory.NodePrefix, "inventory node_prefix", "WGG_NODE_PREFIX"))
+	value, variable := policySetting(inventory.ClientPrefix, "inventory client_prefix", "WGG_CLIENT_PREFIX")
+	policy.ClientPrefix = parsePrefix(value, variable)
+	if policy.NodePrefix != nil && policy.ClientPrefix != nil &&
+		netutils.SubnetsOverlap(policy.NodePrefix, policy.ClientPrefix) {
+		validation.problem(variable, "%s overlaps the node prefix %s", policy.ClientPrefix, policy.NodePrefix)
+	}
+
+	reservedValues := inventory.Reserved
+	reservedVariable := "inventory reserved[%d]"
+	if len(reservedValues) <= 0 {
+		reservedValues = SplitList(os.Getenv("WGG_RESERVED"))
+		reservedVariable = "WGG_RESERVED"
+	}
+	for i, value := range reservedValues {
+		variable := reservedVariable
+		if strings.Contains(variable, "%d") {
+			variable = fmt.Sprintf(variable, i)
+		}
+
+		reserved, err := netutils.ParseIPRange(value)
+		if err != nil {
+			validation.problem(variable, "%s", err.Error())
+		} else if !subnet.Contains(reserved.First) || !subnet.Contains(reserved.Last) {
+			validation.problem(variable, "%s is not within the subnet %s", reserved, subnet)
+		} else {
+			policy.Reserved = append(policy.Reserved, reserved)
+		}
+	}
+
+	value, variable = policySetting(inventory.Allocation, "inventory allocation", "WGG_ALLOCATION")
+	mode, err := ParseAllocationMode(value)
+	if err != nil {
+		validation.problem(variable, "%s", err.Error())
+	}
+	policy.Mode = mode
+
+	addStatic := func(targetID string, value string, variable string) {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			validation.problem(variable, "invalid static address '%s' of %s", value, targetID)
+			return
+		} else if !subnet.Contains(ip) ||
+			ip.Equal(subnet.IP) || ip.Equal(netutils.BroadcastAddress(subnet)) {
+			validation.problem(variable, "static address %s of %s is not a host address of %s", ip, targetID, subnet)
+			return
+		} else if reserved, ok := policy.reservedRange(ip); ok {
+			validation.problem(variable, "static address %s of %s is in the reserved range %s", ip, targetID, reserved)
+			return
+		}
+
+		for otherTargetID, otherIP := range policy.Static {
+			if otherIP.Equal(ip) {
+				validation.problem(variable, "static address %s of %s is also the static address of %s", ip, targetID, otherTargetID)
+				return
+			}
+		}
+
+		if ipv4 := ip.To4(); ipv4 != nil {
+			ip = ipv4
+		}
+		policy.Static[targetID] = ip
+		policy.StaticVariables[targetID] = variable
+	}
+
+	for i, entry := range inventory.Nodes {
+		if len(entry.Address) > 0 && !entry.Removed {
+			addStatic(WggNode{ID: i}.TargetID(), entry.Address, fmt.Sprintf("inventory nodes[%d].address", i))
+		}
+	}
+	for i, entry := range inventory.Clients {
+		if len(entry.Address) > 0 && !entry.Removed {
+			client := WggClient{ID: i, Name: entry.Name}
+			addStatic(client.TargetID(), entry.Address, fmt.Sprintf("inventory clients[%d].address", i))
+		}
+	}
+	for _, static := range SplitList(os.Getenv("WGG_STATIC_ADDRESSES")) {
+		targetID, value, ok := strings.Cut(static, "=")
+		targetID = strings.TrimSpace(targetID)
+		if !ok || len(targetID) <= 0 {
+			validation.problem("WGG_STATIC_ADDRESSES", "invalid entry '%s', expected <target-id>=<address>", static)
+		} else if _, ok := policy.Static[targetID]; !ok {
+			addStatic(targetID, strings.TrimSpace(value), "WGG_STATIC_ADDRESSES")
+		}
+	}
+
+	if len(validation.Problems) > 0 {
+		return nil, validation
+	}
+
+	return policy, nil
+}
+
+// Prefix returns the prefix new addresses of the target are taken from.
+func (policy *AllocationPolicy) Prefix(target WggTarget) *net.IPNet {
+	if target.IsNode() && policy.NodePrefix != nil {
+		return policy.NodePrefix
+	} else if !target.IsNode() && policy.ClientPrefix != nil {
+		return policy.ClientPrefix
+	}
+
+	return policy.Subnet
+}
+
+// excluded returns the ranges that are never handed out to the target, the
+// reserved ranges and the prefix of the other role if the target has none.
+func (policy *AllocationPolicy) excluded(target WggTarget) []netutils.IPRange {
+	excluded := policy.Reserved
+
+	otherPrefix := policy.NodePrefix
+	if target.IsNode() {
+		otherPrefix = policy.ClientPrefix
+	}
+	if otherPrefix != nil && policy.Prefix(target) == policy.Subnet {
+		excluded = append(excluded[:len(excluded):len(excluded)], netutils.SubnetRange(otherPrefix))
+	}
+
+	return excluded
+}
+
+func (policy *AllocationPolicy) reservedRange(ip net.IP) (netutils.IPRange, bool) {
+	for _, reserved := range policy.Reserved {
+		if reserved.Contains(ip) {
+			return reserved, true
+		}
+	}
+
+	return netutils.IPRange{}, false
+}
+
+// hostRange returns the addresses of the target's prefix without the
+// network and broadcast address of the subnet, which are never assigned.
+func (policy *AllocationPolicy) hostRange(target WggTarget) (netutils.IPRange, bool) {
+	return netutils.SubnetRange(policy.Prefix(target)).Intersect(netutils.IPRange{
+		First: netutils.IncrementIP(policy.Subnet.IP, 1),
+		Last:  netutils.IncrementIP(netutils.BroadcastAddress(policy.Subnet), -1),
+	})
+}
+
+// Allowed returns an error if a new address of the target must not be ip.
+func (policy *AllocationPolicy) Allowed(target WggTarget, ip net.IP) error {
+	hosts, ok := policy.hostRange(target)
+	if !ok || !hosts.Contains(ip) {
+		return fmt.Errorf("%s is not a host address of %s", ip, policy.Prefix(target))
+	}
+
+	reserved, ok := policy.reservedRange(ip)
+	if ok {
+		return fmt.Errorf("%s is in the reserved range %s", ip, reserved)
+	}
+
+	for _, excluded := range policy.excluded(target) {
+		if excluded.Contains(ip) {
+			return fmt.Errorf("%s is in the range %s of the other role", ip, excluded)
+		}
+	}
+
+	return nil
+}
+
+// candidate returns the first address offered to a new target.
+func (policy *AllocationPolicy) candidate(target WggTarget, id int) net.IP {
+	prefix := policy.Prefix(target)
+
+	if policy.Mode == AllocationSparse {
+		ones, bits := prefix.Mask.Size()
+		hash := fnv.New64a()
+		hash.Write([]byte(target.TargetID()))
+		offset := hash.Sum64() % (uint64(1) << min(bits-ones, 62))
+
+		return netutils.IncrementIP(prefix.IP, int(offset))
+	} else if target.IsNode() {
+		return netutils.IncrementIP(prefix.IP, id+1)
+	}
+
+	return netutils.IncrementIP(netutils.BroadcastAddress(prefix), -(id + 1))
+}
+
+// Allocate returns the address for a new target with the given ID that is
+// not in used. It starts at the address the Mode offers and searches the
+// next free one, upwards for nodes and sparse allocation, downwards for
+// clients, wrapping around at the end of the prefix.
+func (policy *AllocationPolicy) Allocate(target WggTarget, id int, used map[string]bool) (net.IP, error) {
+	step := 1
+	if !target.IsNode() && policy.Mode != AllocationSparse {
+		step = -1
+	}
+
+	hosts, ok := policy.hostRange(target)
+	if !ok {
+		return nil, fmt.Errorf("%s has no host addresses", policy.Prefix(target))
+	}
+	restart := hosts.First
+	if step < 0 {
+		restart = hosts.Last
+	}
+
+	start := policy.candidate(target, id)
+	if start == nil || !hosts.Contains(start) {
+		start = restart
+	}
+
+	excluded := policy.excluded(target)
+	ip := start
+	wrapped := false
+	for {
+		if ip == nil || !hosts.Contains(ip) {
+			if wrapped {
+				break
+			}
+			wrapped = true
+			ip = restart
+			continue
+		} else if wrapped && step*netutils.CompareIP(ip, start) >= 0 {
+			break
+		}
+
+		isExcluded := false
+		for _, excludedRange := range excluded {
+			if excludedRange.Contains(ip) {
+				isExcluded = true
+				if step > 0 {
+					ip = netutils.IncrementIP(excludedRange.Last, 1)
+				} else {
+					ip = netutils.IncrementIP(excludedRange.First, -1)
+				}
+				break
+			}
+		}
+		if isExcluded {
+			continue
+		}
+
+		if used[ip.String()] {
+			ip = netutils.IncrementIP(ip, step)
+			continue
+		}
+
+		return ip, nil
+	}
+
+	return nil, fmt.Errorf("no free address left in %s for %s", policy.Prefix(target), target.TargetID())
+}
+
+// Capacity returns the number of addresses that can be handed out to the
+// nodes or the clients.
+func (policy *AllocationPolicy) Capacity(isNode bool) *big.Int {
+	var target WggTarget = WggClient{}
+	if isNode {
+		target = WggNode{}
+	}
+
+	hosts, ok := policy.hostRange(target)
+	if !ok {
+		return big.NewInt(0)
+	}
+
+	// reserved ranges may overlap each other or the other prefix, every
+	// address is subtracted once
+	overlaps := []netutils.IPRange{}
+	for _, excluded := range policy.excluded(target) {
+		overlap, ok := hosts.Intersect(excluded)
+		if ok {
+			overlaps = append(overlaps, overlap)
+		}
+	}
+
+	capacity := hosts.Size()
+	for _, overlap := range netutils.MergeIPRanges(overlaps) {
+		capacity.Sub(capacity, overlap.Size())
+	}
+
+	if capacity.Sign() < 0 {
+		return big.NewInt(0)
+	}
+
+	return capacity
+}
+
+// SharedPrefix returns true if nodes and clients share the whole subnet.
+func (policy *AllocationPolicy) SharedPrefix() bool {
+	return policy.NodePrefix == nil && policy.ClientPrefix == nil
+}
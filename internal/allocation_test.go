@@ -0,0 +1,212 @@
+package wgg
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/CoreUnit-NET/wgg/lib/netutils"
+)
+
+func TestInitAllocationPolicy(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	t.Setenv("WGG_STATIC_ADDRESSES", "c1=10.10.10.200, alice=10.10.10.201")
+
+	inventory, err := ParseInventory([]byte(
+		"node_prefix: 10.10.10.0/26\n"+
+			"client_prefix: 10.10.10.128/25\n"+
+			"reserved: [10.10.10.1-10.10.10.9, 10.10.10.250]\n"+
+			"allocation: sparse\n"+
+			"nodes:\n"+
+			"  - endpoint: 192.0.2.1:55333\n"+
+			"    address: 10.10.10.10\n"+
+			"clients:\n"+
+			"  - name: alice\n"+
+			"    address: 10.10.10.100\n"+
+			"  - {}\n",
+	), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := InitAllocationPolicy(inventory, subnet)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	if policy.Mode != AllocationSparse || len(policy.Reserved) != 2 ||
+		policy.NodePrefix.String() != "10.10.10.0/26" || policy.ClientPrefix.String() != "10.10.10.128/25" {
+		t.Errorf("unexpected policy %+v", policy)
+	}
+	if policy.Static["n0"].String() != "10.10.10.10" ||
+		policy.Static["alice"].String() != "10.10.10.100" ||
+		policy.Static["c1"].String() != "10.10.10.200" {
+		t.Errorf("expected the inventory static addresses to take precedence, but got %v", policy.Static)
+	}
+
+	inventory.Reserved = []string{"10.10.10.100", "10.10.11.0/24"}
+	inventory.Nodes[0].Address = "10.10.10.0"
+	inventory.Allocation = "dense"
+	_, err = InitAllocationPolicy(inventory, subnet)
+
+	var validation *ValidationError
+	if !errors.As(err, &validation) || len(validation.Problems) != 4 {
+		t.Errorf("expected 4 problems, but got %v", err)
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	_, nodePrefix, _ := net.ParseCIDR("10.10.10.64/26")
+
+	tests := []struct {
+		name     string
+		policy   *AllocationPolicy
+		target   WggTarget
+		used     []string
+		expected string
+	}{
+		{"sequential node", &AllocationPolicy{Subnet: subnet}, WggNode{ID: 2}, nil, "10.10.10.3"},
+		{"sequential client", &AllocationPolicy{Subnet: subnet}, WggClient{ID: 2}, nil, "10.10.10.252"},
+		{"next free node", &AllocationPolicy{Subnet: subnet}, WggNode{ID: 0}, []string{"10.10.10.1", "10.10.10.2"}, "10.10.10.3"},
+		{"next free client", &AllocationPolicy{Subnet: subnet}, WggClient{ID: 0}, []string{"10.10.10.254"}, "10.10.10.253"},
+		{
+			"reserved",
+			&AllocationPolicy{Subnet: subnet, Reserved: testRanges("10.10.10.1-10.10.10.9")},
+			WggNode{ID: 0},
+			nil,
+			"10.10.10.10",
+		},
+		{"node prefix", &AllocationPolicy{Subnet: subnet, NodePrefix: nodePrefix}, WggNode{ID: 0}, nil, "10.10.10.65"},
+		{
+			"rest of the subnet",
+			&AllocationPolicy{Subnet: subnet, NodePrefix: nodePrefix},
+			WggClient{ID: 0},
+			[]string{"10.10.10.254"},
+			"10.10.10.253",
+		},
+		{
+			"skip the other prefix",
+			&AllocationPolicy{Subnet: subnet, NodePrefix: nodePrefix},
+			WggClient{ID: 130},
+			nil,
+			"10.10.10.63",
+		},
+		{
+			"wrap around",
+			&AllocationPolicy{Subnet: subnet, NodePrefix: nodePrefix},
+			WggNode{ID: 62},
+			[]string{"10.10.10.127"},
+			"10.10.10.64",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			used := map[string]bool{}
+			for _, ip := range test.used {
+				used[ip] = true
+			}
+
+			ip, err := test.policy.Allocate(test.target, testTargetID(test.target), used)
+			if err != nil {
+				t.Fatalf("did not expect error, but got %v", err)
+			}
+			if ip.String() != test.expected {
+				t.Errorf("expected %s, but got %s", test.expected, ip)
+			}
+		})
+	}
+
+	// sparse addresses do not depend on the order and stay in the prefix
+	policy := &AllocationPolicy{Subnet: subnet, NodePrefix: nodePrefix, Mode: AllocationSparse}
+	first, _ := policy.Allocate(WggNode{ID: 5}, 5, map[string]bool{})
+	second, _ := policy.Allocate(WggNode{ID: 5}, 5, map[string]bool{})
+	if !first.Equal(second) || !nodePrefix.Contains(first) {
+		t.Errorf("expected a stable sparse address in %s, but got %s and %s", nodePrefix, first, second)
+	}
+
+	_, smallSubnet, _ := net.ParseCIDR("10.10.10.0/30")
+	policy = &AllocationPolicy{Subnet: smallSubnet}
+	_, err := policy.Allocate(WggNode{ID: 0}, 0, map[string]bool{"10.10.10.1": true, "10.10.10.2": true})
+	if err == nil {
+		t.Errorf("expected error for a full subnet, but got none")
+	}
+}
+
+func TestAssignStaticAddresses(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	ledger := &Ledger{Targets: map[string]*LedgerEntry{
+		"n0": {Address: "10.10.10.1", Endpoint: "192.0.2.1:55333"},
+		"c0": {Address: "10.10.10.254"},
+	}}
+	nodeList := []WggNode{{ID: 0, Host: "192.0.2.1", Port: 55333}}
+	clientList := []WggClient{NewWggClient(0)}
+
+	policy := &AllocationPolicy{
+		Subnet:          subnet,
+		Static:          map[string]net.IP{"c0": net.ParseIP("10.10.10.1").To4()},
+		StaticVariables: map[string]string{"c0": "WGG_STATIC_ADDRESSES"},
+	}
+	err := AssignAddresses(ledger, policy, nodeList, clientList)
+	if err == nil {
+		t.Errorf("expected error for a static address of another target, but got none")
+	}
+
+	policy.Static["c0"] = net.ParseIP("10.10.10.100").To4()
+	err = AssignAddresses(ledger, policy, nodeList, clientList)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	if clientList[0].Address.String() != "10.10.10.100" || ledger.Targets["c0"].Address != "10.10.10.100" {
+		t.Errorf("expected the static address to replace the ledger entry, but got %+v", ledger.Targets["c0"])
+	}
+
+	policy.Reserved = testRanges("10.10.10.1")
+	err = AssignAddresses(ledger, policy, nodeList, clientList)
+	if err == nil {
+		t.Errorf("expected error for a recorded address in a reserved range, but got none")
+	}
+}
+
+func testRanges(values ...string) []netutils.IPRange {
+	ranges := []netutils.IPRange{}
+	for _, value := range values {
+		ipRange, _ := netutils.ParseIPRange(value)
+		ranges = append(ranges, ipRange)
+	}
+
+	return ranges
+}
+
+func testTargetID(target WggTarget) int {
+	switch target := target.(type) {
+	case WggNode:
+		return target.ID
+	case WggClient:
+		return target.ID
+	}
+
+	return 0
+}
+
+func TestCapacity(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	inventory := &Inventory{
+		ClientPrefix: "10.10.10.128/25",
+		Reserved:     []string{"10.10.10.1-10.10.10.20", "10.10.10.10-10.10.10.30", "10.10.10.21", "10.10.10.120-10.10.10.140"},
+	}
+
+	policy, err := InitAllocationPolicy(inventory, subnet)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	// 254 hosts without 10.10.10.1-30, 10.10.10.120-127 and the client prefix
+	if capacity := policy.Capacity(true); capacity.Int64() != 254-30-8-127 {
+		t.Errorf("expected node capacity %d, but got %s", 254-30-8-127, capacity)
+	}
+	// 127 hosts of the client prefix without 10.10.10.128-140
+	if capacity := policy.Capacity(false); capacity.Int64() != 127-13 {
+		t.Errorf("expected client capacity %d, but got %s", 127-13, capacity)
+	}
+}
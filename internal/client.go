@@ -1,8 +1,11 @@
 package wgg
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"regexp"
+	"strconv"
 
 	"github.com/CoreUnit-NET/wgg/lib/netutils"
 )
@@ -11,6 +14,54 @@ type WggClient struct {
 	WggTarget
 
 	ID int
+
+	// Name replaces the numbered "c<id>" as TargetID if set. The client
+	// keeps the address of its ID, so naming a client does not renumber it.
+	Name        string
+	Owner       string
+	Description string
+	Tags        []string
+
+	// PreferredEndpoint selects the endpoint of dual-stack nodes in the
+	// config of this client.
+	PreferredEndpoint EndpointPreference
+
+	// Address is the WireGuard address assigned by the Ledger, if any.
+	Address net.IP
+
+	// Meta holds free-form metadata from the inventory.
+	Meta map[string]string
+}
+
+// clientNamePattern allows names that are safe as file names and do not
+// contain the "-" separator of PresharedKeyID.
+var clientNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.]*$`)
+
+// reservedTargetIDPattern matches the numbered target IDs of nodes and
+// unnamed clients and the plain IDs in the config file names of unnamed
+// clients.
+var reservedTargetIDPattern = regexp.MustCompile(`^[nc]?[0-9]+$`)
+
+// ValidateClientName returns an error if name can not be used as client
+// name.
+//
+// Names consist of lowercase letters, digits, "_" and "." and must not look
+// like a numbered target ID, the FileID of an unnamed client or a rotate
+// selector.
+func ValidateClientName(name string) error {
+	if !clientNamePattern.MatchString(name) {
+		return errors.New(
+			"invalid client name '" + name +
+				"': only lowercase letters, digits, '_' and '.' are allowed",
+		)
+	}
+
+	if reservedTargetIDPattern.MatchString(name) ||
+		name == "all" || name == "nodes" || name == "clients" {
+		return errors.New("invalid client name '" + name + "': the name is reserved")
+	}
+
+	return nil
 }
 
 // NewWggClient returns a new WggClient.
@@ -25,10 +76,30 @@ func NewWggClient(
 	}
 }
 
-// TargetID returns the ID of the target client as a string, prefixed with "C".
+// TargetID returns the name of the client, or its ID as a string prefixed
+// with "c" if it has no name.
 func (client WggClient) TargetID() string {
-	return fmt.Sprintf("c%d", client.ID)
+	if len(client.Name) > 0 {
+		return client.Name
+	}
 
+	return NumberedClientID(client.ID)
+}
+
+// NumberedClientID returns the TargetID of the unnamed client with the
+// given ID.
+func NumberedClientID(id int) string {
+	return fmt.Sprintf("c%d", id)
+}
+
+// FileID returns the part of the config file name that identifies the
+// client, its name or its ID.
+func (client WggClient) FileID() string {
+	if len(client.Name) > 0 {
+		return client.Name
+	}
+
+	return strconv.Itoa(client.ID)
 }
 
 func (client WggClient) IsNode() bool {
@@ -43,12 +114,29 @@ func (client WggClient) NodePubIp() *net.IP {
 	return nil
 }
 
+func (client WggClient) NodeHost() string {
+	return ""
+}
+
+func (client WggClient) NodeEndpoint(EndpointPreference) (string, error) {
+	return "", fmt.Errorf("client '%s' has no endpoint", client.TargetID())
+}
+
+// EndpointPreference returns the endpoint preference of the client.
+func (client WggClient) EndpointPreference() EndpointPreference {
+	return client.PreferredEndpoint
+}
+
 // WireGuardSubnetIP returns an IP address in the given subnet that is
 // appropriate for the current client to use as its WireGuard IP address.
 //
-// The returned IP address is the given subnet's broadcast address decremented
-// by the client's ID plus one.
+// The returned IP address is the client's assigned Address, or the given
+// subnet's broadcast address decremented by the client's ID plus one.
 func (client WggClient) WireGuardSubnetIP(subnet *net.IPNet) net.IP {
+	if client.Address != nil {
+		return client.Address
+	}
+
 	return netutils.IncrementIP(
 		netutils.BroadcastAddress(subnet),
 		-(client.ID + 1),
@@ -0,0 +1,158 @@
+package wgg
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestValidateClientName(t *testing.T) {
+	tests := []struct {
+		name        string
+		expectError bool
+	}{
+		{"alice", false},
+		{"alice.laptop", false},
+		{"bob_2", false},
+		{"", true},
+		{"Alice", true},
+		{"alice-laptop", true},
+		{".alice", true},
+		{"c3", true},
+		{"n0", true},
+		{"1", true},
+		{"2024", true},
+		{"2024.laptop", false},
+		{"all", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateClientName(test.name)
+			if test.expectError && err == nil {
+				t.Errorf("expected error for name %s, but got none", test.name)
+			} else if !test.expectError && err != nil {
+				t.Errorf("did not expect error for name %s, but got %v", test.name, err)
+			}
+		})
+	}
+}
+
+func TestNamedClients(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	ip := net.ParseIP("192.0.2.1")
+	nodeList := []WggNode{{ID: 0, PubIp: &ip, Port: 55333}}
+
+	inventory, err := ParseInventory([]byte(
+		"clients:\n"+
+			"  - name: alice\n"+
+			"    owner: Alice\n"+
+			"    tags: [laptop]\n"+
+			"  - {}\n",
+	), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientList, err := InitClientList(inventory)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	if clientList[0].TargetID() != "alice" || clientList[1].TargetID() != "c1" {
+		t.Errorf("expected target IDs alice and c1, but got %s and %s", clientList[0].TargetID(), clientList[1].TargetID())
+	}
+
+	// the name does not change the address
+	if !clientList[0].WireGuardSubnetIP(subnet).Equal(WggClient{ID: 0}.WireGuardSubnetIP(subnet)) {
+		t.Errorf("expected the named client to keep the address of c0")
+	}
+
+	outDir := t.TempDir()
+	keyDir := t.TempDir()
+	err = GenerateClientConfigs(subnet, outDir, NewFileKeyStore(keyDir, nil), nodeList, clientList, GenOptions{})
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	for _, path := range []string{outDir + "/client.alice.wg.conf", outDir + "/client.1.wg.conf", keyDir + "/alice.key"} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected %s to exist, but got %v", path, err)
+		}
+	}
+
+	_, err = InitClientList(&Inventory{Clients: []InventoryClient{{Name: "bob"}, {Name: "bob"}}})
+	if err == nil {
+		t.Errorf("expected error for duplicate client names, but got none")
+	}
+}
+
+func TestMigrateClientKeys(t *testing.T) {
+	keyStore := NewFileKeyStore(t.TempDir(), nil)
+
+	privateKey, _, err := keyStore.InitKeyPair("c0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientList := []WggClient{{ID: 0, Name: "alice"}, {ID: 1, Name: "bob"}}
+	migrated, err := MigrateClientKeys(keyStore, clientList, time.Now())
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	if len(migrated) != 1 || migrated[0].Name != "alice" {
+		t.Errorf("expected only alice to be migrated, but got %v", migrated)
+	}
+
+	migratedPrivateKey, _, err := keyStore.LoadKeyPair("alice")
+	if err != nil || migratedPrivateKey != privateKey {
+		t.Errorf("expected alice to have the key of c0, but got %v", err)
+	}
+
+	_, _, err = keyStore.LoadKeyPair("c0")
+	if err == nil {
+		t.Errorf("expected the keys of c0 to be archived")
+	}
+}
+
+func TestMigrateClientKeysDerived(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, MasterSeedMinLen)
+	keyDir := t.TempDir()
+	store, err := NewDerivedKeyStore(seed, NewFileKeyStore(keyDir, nil), keyDir+"/keysources.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyStore := NewKeyring(store)
+
+	privateKey, _, err := keyStore.InitKeyPair("c0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientList := []WggClient{{ID: 0, Name: "alice"}, {ID: 1, Name: "bob"}}
+	migrated, err := MigrateClientKeys(keyStore, clientList, time.Now())
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	if len(migrated) != 1 || migrated[0].Name != "alice" {
+		t.Errorf("expected only alice to be migrated, but got %v", migrated)
+	}
+
+	migratedPrivateKey, _, err := keyStore.InitKeyPair("alice")
+	if err != nil || migratedPrivateKey != privateKey {
+		t.Errorf("expected alice to have the derived key of c0, but got %v", err)
+	}
+
+	derivedPrivateKey, _ := DeriveWireGuardPrivateKey(seed, "bob")
+	bobPrivateKey, _, err := keyStore.InitKeyPair("bob")
+	if err != nil || bobPrivateKey != derivedPrivateKey {
+		t.Errorf("expected bob to keep the key derived from its name, but got %v", err)
+	}
+
+	migrated, err = MigrateClientKeys(keyStore, clientList, time.Now())
+	if err != nil || len(migrated) != 0 {
+		t.Errorf("expected no migration on the second run, but got %v, err: %v", migrated, err)
+	}
+}
@@ -3,74 +3,188 @@ package wgg
 import (
 	"fmt"
 	"net"
+	"slices"
 )
 
+// GenWgClientConfPart renders the config section of target as it appears in
+// the config of forTarget.
+//
+// If both are the same target, the [Interface] section is returned,
+// otherwise a [Peer] section. The Endpoint of a node peer follows the
+// EndpointPreference of forTarget, its PersistentKeepalive the keepalive
+// interval of forTarget. The exit node of a client is its full-tunnel peer
+// and an exit node forwards and masquerades the traffic of the overlay. The
+// AllowedIPs of a node peer include the routes behind the node, which
+// forwards the traffic to them. The hub of the hub group of a client also
+// gets the prefixes of the group and forwards the traffic of its members.
 func GenWgClientConfPart(
 	target WggTarget,
-	keyDir string,
+	keyStore KeyStore,
 	subnet *net.IPNet,
-	forTargetID string,
+	forTarget WggTarget,
+	options GenOptions,
 ) (string, error) {
 	ones, _ := subnet.Mask.Size()
+	forTargetID := forTarget.TargetID()
+
+	address := target.WireGuardSubnetIP(subnet)
+	interfaceAddresses := fmt.Sprintf("%s/%d", address, ones)
+	allowedIPs := hostPrefix(address)
+	if options.Subnet6 != nil {
+		address6 := OverlayIP6(subnet, options.Subnet6, address)
+		ones6, _ := options.Subnet6.Mask.Size()
+		interfaceAddresses += fmt.Sprintf(", %s/%d", address6, ones6)
+		allowedIPs += ", " + hostPrefix(address6)
+	}
 
 	if target.TargetID() == forTargetID {
-		privateKey, _, err := InitWireGuardKeyPair(
-			keyDir+"/"+target.TargetID()+".key",
-			keyDir+"/"+target.TargetID()+".pub",
-		)
+		targetOptions := options.Interfaces.Of(target)
+		exitNode := options.Exits.IsExitNode(target)
+		// the hub rules isolate the hub groups, so they come before the
+		// generic ACCEPT rules of an exit or route node
+		postUp, postDown := options.Hubs.HubCommands(target, subnet, options.Subnet6)
+		if exitNode || len(options.Routes.Of(target)) > 0 {
+			forwardPostUp, forwardPostDown := ForwardingCommands(subnet, options.Subnet6, exitNode)
+			postUp = slices.Concat(postUp, forwardPostUp)
+			postDown = slices.Concat(postDown, forwardPostDown)
+		} else if len(postUp) > 0 {
+			postUp = slices.Concat(EnableForwardingCommands(options.Subnet6), postUp)
+		}
+		targetOptions.PostUp = slices.Concat(targetOptions.PostUp, postUp)
+		targetOptions.PostDown = slices.Concat(targetOptions.PostDown, postDown)
+		interfaceOptions := targetOptions.String()
+
+		if target.IsNode() && options.NodeSideKeys {
+			// the private key stays on the node and is loaded on startup
+			return fmt.Sprintf(
+				"[Interface]\n"+
+					"Address = %s\n"+
+					"ListenPort = %d\n"+
+					"PostUp = wg set %%i private-key %s\n"+
+					"%s",
+				interfaceAddresses,
+				target.NodePort(),
+				ShellQuote(options.NodeKeyPath),
+				interfaceOptions,
+			), nil
+		}
+
+		privateKey, _, err := keyStore.InitKeyPair(target.TargetID())
 		if err != nil {
-			return "", err
+			return "", fmt.Errorf("key pair of target '%s': %w", target.TargetID(), err)
 		}
 
 		if target.IsNode() {
 			return fmt.Sprintf(
 				"[Interface]\n"+
-					"Address = %s/%d\n"+
+					"Address = %s\n"+
 					"PrivateKey = %s\n"+
-					"ListenPort = %d\n",
-				target.WireGuardSubnetIP(subnet),
-				ones,
+					"ListenPort = %d\n"+
+					"%s",
+				interfaceAddresses,
 				privateKey,
 				target.NodePort(),
+				interfaceOptions,
 			), nil
 		} else {
 			return fmt.Sprintf(
 				"[Interface]\n"+
 					"PrivateKey = %s\n"+
-					"Address = %s/%d\n",
+					"Address = %s\n"+
+					"%s",
 				privateKey,
-				target.WireGuardSubnetIP(subnet),
-				ones,
+				interfaceAddresses,
+				interfaceOptions,
 			), nil
 		}
 	} else {
-		_, publicKey, err := InitWireGuardKeyPair(
-			keyDir+"/"+target.TargetID()+".key",
-			keyDir+"/"+target.TargetID()+".pub",
-		)
+		var publicKey string
+		var err error
+		if target.IsNode() && options.NodeSideKeys {
+			publicKey, err = keyStore.LoadPublicKey(target.TargetID())
+		} else {
+			_, publicKey, err = keyStore.InitKeyPair(target.TargetID())
+		}
 		if err != nil {
-			return "", err
+			return "", fmt.Errorf("key pair of target '%s': %w", target.TargetID(), err)
+		}
+
+		presharedKeyLine := ""
+		if options.PresharedKeys {
+			presharedKey, err := keyStore.InitPresharedKey(
+				PresharedKeyID(target.TargetID(), forTargetID),
+			)
+			if err != nil {
+				return "", fmt.Errorf(
+					"preshared key of targets '%s' and '%s': %w",
+					target.TargetID(),
+					forTargetID,
+					err,
+				)
+			}
+
+			presharedKeyLine = "PresharedKey = " + presharedKey + "\n"
 		}
 
 		if target.IsNode() {
+			if exit, ok := options.Exits.ExitOf(forTarget); ok && exit == target.TargetID() {
+				allowedIPs = FullTunnelAllowedIPs
+			} else {
+				for _, route := range options.Routes.Of(target) {
+					allowedIPs += ", " + route.String()
+				}
+
+				if group := options.Hubs.GroupOf(forTarget); group != nil && group.Hub == target.TargetID() {
+					for _, prefix := range group.Prefixes {
+						allowedIPs += ", " + prefix.String()
+					}
+				}
+			}
+
+			endpoint, err := target.NodeEndpoint(forTarget.EndpointPreference())
+			if err != nil {
+				return "", fmt.Errorf("endpoint for target '%s': %w", forTargetID, err)
+			}
+
+			// forTarget keeps its NAT mapping to the node open
+			keepaliveLine := ""
+			if keepalive := options.Keepalives.Of(forTarget); keepalive > 0 {
+				keepaliveLine = fmt.Sprintf("PersistentKeepalive = %d\n", keepalive)
+			}
+
 			return fmt.Sprintf(
 				"[Peer]\n"+
 					"PublicKey = %s\n"+
-					"AllowedIPs = %s/32\n"+
-					"Endpoint = %s:%d\n",
+					"%s"+
+					"AllowedIPs = %s\n"+
+					"Endpoint = %s\n"+
+					"%s",
 				publicKey,
-				target.WireGuardSubnetIP(subnet),
-				target.NodePubIp(),
-				target.NodePort(),
+				presharedKeyLine,
+				allowedIPs,
+				endpoint,
+				keepaliveLine,
 			), nil
 		} else {
 			return fmt.Sprintf(
 				"[Peer]\n"+
 					"PublicKey = %s\n"+
-					"AllowedIPs = %s/32\n",
+					"%s"+
+					"AllowedIPs = %s\n",
 				publicKey,
-				target.WireGuardSubnetIP(subnet),
+				presharedKeyLine,
+				allowedIPs,
 			), nil
 		}
 	}
 }
+
+// hostPrefix returns ip as a single host prefix, "/32" for IPv4 and "/128"
+// for IPv6.
+func hostPrefix(ip net.IP) string {
+	if ip.To4() != nil {
+		return ip.String() + "/32"
+	}
+
+	return ip.String() + "/128"
+}
@@ -0,0 +1,118 @@
+package wgg
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDualStackOverlay(t *testing.T) {
+	keyStore := NewFileKeyStore(t.TempDir(), nil)
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	_, subnet6, _ := net.ParseCIDR("fd00:10::/64")
+	node := WggNode{ID: 0, Host: "192.0.2.1", Port: 55333}
+	client := NewWggClient(0)
+	options := GenOptions{Subnet6: subnet6}
+
+	conf, err := GenWgClientConfPart(node, keyStore, subnet, node, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	} else if !strings.Contains(conf, "Address = 10.10.10.1/24, fd00:10::1/64\n") {
+		t.Errorf("expected both interface addresses, but got:\n%s", conf)
+	}
+
+	conf, err = GenWgClientConfPart(client, keyStore, subnet, node, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	} else if !strings.Contains(conf, "AllowedIPs = 10.10.10.254/32, fd00:10::fe/128\n") {
+		t.Errorf("expected both allowed IPs, but got:\n%s", conf)
+	}
+}
+
+func TestInitSubnet6(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.10.0.0/16")
+	ledger := &Ledger{Targets: map[string]*LedgerEntry{}, Path: "ledger.json"}
+
+	t.Setenv("WGG_SUBNET6", "")
+	subnet6, err := InitSubnet6(nil, subnet, ledger)
+	if err != nil || subnet6 != nil {
+		t.Errorf("expected no IPv6 prefix, but got %v, %v", subnet6, err)
+	}
+
+	t.Setenv("WGG_SUBNET6", Subnet6Auto)
+	subnet6, err = InitSubnet6(nil, subnet, ledger)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	ones, _ := subnet6.Mask.Size()
+	if subnet6.IP[0] != 0xfd || ones != 64 || ledger.Subnet6 != subnet6.String() {
+		t.Errorf("expected a recorded unique local /64, but got %v in %+v", subnet6, ledger)
+	}
+	if !ledger.Subnet6Generated {
+		t.Errorf("expected the generated prefix to be marked as not saved")
+	}
+
+	again, err := InitSubnet6(nil, subnet, ledger)
+	if err != nil || again.String() != subnet6.String() {
+		t.Errorf("expected the recorded prefix %v, but got %v, %v", subnet6, again, err)
+	}
+
+	ledger.Path = filepath.Join(t.TempDir(), "ledger.json")
+	err = SaveLedger(ledger)
+	if err != nil || ledger.Subnet6Generated {
+		t.Errorf("expected the saved prefix to be allocated, but got %v, %v", ledger.Subnet6Generated, err)
+	}
+
+	t.Setenv("WGG_SUBNET6", "fd00:10::/64")
+	_, err = InitSubnet6(nil, subnet, ledger)
+	var validation *ValidationError
+	if !errors.As(err, &validation) {
+		t.Errorf("expected a ValidationError for a changed prefix, but got %v", err)
+	}
+
+	for _, value := range []string{"10.20.0.0/16", "fd00:10::/120", "fd00:10::"} {
+		_, err = ParseSubnet6(value, subnet)
+		if err == nil {
+			t.Errorf("expected error for '%s', but got none", value)
+		}
+	}
+}
+
+func TestGenWgClientConfPartPresharedKeys(t *testing.T) {
+	keyStore := NewFileKeyStore(t.TempDir(), nil)
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	node := WggNode{ID: 0, Host: "192.0.2.1", Port: 55333}
+	client := NewWggClient(0)
+
+	presharedKeyLine := func(conf string) string {
+		for _, line := range strings.Split(conf, "\n") {
+			if strings.HasPrefix(line, "PresharedKey = ") {
+				return line
+			}
+		}
+		return ""
+	}
+
+	options := GenOptions{PresharedKeys: true}
+	nodePeer, err := GenWgClientConfPart(node, keyStore, subnet, client, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	clientPeer, err := GenWgClientConfPart(client, keyStore, subnet, node, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	if presharedKeyLine(nodePeer) == "" || presharedKeyLine(nodePeer) != presharedKeyLine(clientPeer) {
+		t.Errorf("expected the same PresharedKey on both sides, but got:\n%s\n%s", nodePeer, clientPeer)
+	}
+
+	nodePeer, err = GenWgClientConfPart(node, keyStore, subnet, client, GenOptions{})
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	} else if strings.Contains(nodePeer, "PresharedKey") {
+		t.Errorf("expected no PresharedKey without the option, but got:\n%s", nodePeer)
+	}
+}
@@ -1,161 +1,444 @@
 package wgg
 
 import (
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"net"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/CoreUnit-NET/wgg/lib/netutils"
 )
 
 func PrintNodes(
 	subnet *net.IPNet,
+	subnet6 *net.IPNet,
 	nodeList []WggNode,
 ) {
 	fmt.Println("Nodes:")
 	for _, node := range nodeList {
+		endpoints := []string{}
+		for _, host := range []string{node.Host, node.Host6} {
+			if len(host) > 0 {
+				endpoints = append(endpoints, net.JoinHostPort(host, strconv.Itoa(node.Port)))
+			}
+		}
+		if len(endpoints) == 0 {
+			endpoint, _ := node.NodeEndpoint(EndpointDefault)
+			endpoints = append(endpoints, endpoint)
+		}
+
 		fmt.Println(
 			"- #" + strconv.Itoa(node.ID) +
-				"| " + (*node.PubIp).String() + ":" +
-				strconv.Itoa(node.Port) +
-				" > " + node.WireGuardSubnetIP(subnet).String(),
+				"| " + strings.Join(endpoints, ", ") +
+				" > " + overlayAddresses(subnet, subnet6, node),
 		)
 	}
 }
 
 func PrintClients(
 	subnet *net.IPNet,
+	subnet6 *net.IPNet,
 	clientList []WggClient,
 ) {
 	fmt.Println("Clients:")
 	for _, client := range clientList {
+		details := ""
+		if len(client.Name) > 0 {
+			details += " " + client.Name
+		}
+		if len(client.Owner) > 0 {
+			details += " (" + client.Owner + ")"
+		}
+		if len(client.Tags) > 0 {
+			details += " [" + strings.Join(client.Tags, ", ") + "]"
+		}
+		if len(client.Description) > 0 {
+			details += ": " + client.Description
+		}
+
 		fmt.Println(
-			"- #" + strconv.Itoa(client.ID) +
-				" > " + client.WireGuardSubnetIP(subnet).String(),
+			"- #" + strconv.Itoa(client.ID) + details +
+				" > " + overlayAddresses(subnet, subnet6, client),
 		)
 	}
 }
 
-func GenerateNodeConfigs(
+// overlayAddresses returns the overlay address of the target, followed by
+// its IPv6 overlay address if subnet6 is set.
+func overlayAddresses(subnet *net.IPNet, subnet6 *net.IPNet, target WggTarget) string {
+	address := target.WireGuardSubnetIP(subnet)
+	if subnet6 == nil {
+		return address.String()
+	}
+
+	return address.String() + ", " + OverlayIP6(subnet, subnet6, address).String()
+}
+
+// RenderNodeConfig renders the config of node, with every other node and
+// every client as peer.
+func RenderNodeConfig(
 	subnet *net.IPNet,
-	outDir string,
-	keyDir string,
+	keyStore KeyStore,
+	node WggNode,
 	nodeList []WggNode,
 	clientList []WggClient,
-) error {
+	options GenOptions,
+) (string, error) {
 	var selfConf string
-	var newConf string
-	var otherConfs []string
-	var err error
-	for _, node := range nodeList {
-		otherConfs = []string{}
+	otherConfs := []string{}
 
-		for _, node2 := range nodeList {
-			if node.ID == node2.ID {
-				selfConf, err = GenWgClientConfPart(
-					node2,
-					keyDir,
-					subnet,
-					node.TargetID(),
-				)
+	for _, node2 := range nodeList {
+		newConf, err := GenWgClientConfPart(
+			node2,
+			keyStore,
+			subnet,
+			node,
+			options,
+		)
 
-				if err != nil {
-					return err
-				}
-			} else {
-				newConf, err = GenWgClientConfPart(
-					node2,
-					keyDir,
-					subnet,
-					node.TargetID(),
-				)
+		if err != nil {
+			return "", err
+		}
 
-				if err != nil {
-					return err
-				}
+		if node.ID == node2.ID {
+			selfConf = newConf
+		} else {
+			otherConfs = append(otherConfs, newConf)
+		}
+	}
 
-				otherConfs = append(otherConfs, newConf)
-			}
+	for _, client := range clientList {
+		newConf, err := GenWgClientConfPart(
+			client,
+			keyStore,
+			subnet,
+			node,
+			options,
+		)
+
+		if err != nil {
+			return "", err
 		}
 
-		for _, client := range clientList {
-			newConf, err = GenWgClientConfPart(
-				client,
-				keyDir,
-				subnet,
-				node.TargetID(),
-			)
+		otherConfs = append(otherConfs, newConf)
+	}
 
-			if err != nil {
-				return err
-			}
+	return selfConf + "\n" + strings.Join(otherConfs, "\n"), nil
+}
 
-			otherConfs = append(otherConfs, newConf)
+// RenderClientConfig renders the config of client, with every node as
+// peer.
+func RenderClientConfig(
+	subnet *net.IPNet,
+	keyStore KeyStore,
+	client WggClient,
+	nodeList []WggNode,
+	options GenOptions,
+) (string, error) {
+	selfConf, err := GenWgClientConfPart(
+		client,
+		keyStore,
+		subnet,
+		client,
+		options,
+	)
+
+	if err != nil {
+		return "", err
+	}
+
+	otherConfs := []string{}
+
+	for _, node := range nodeList {
+		newConf, err := GenWgClientConfPart(
+			node,
+			keyStore,
+			subnet,
+			client,
+			options,
+		)
+
+		if err != nil {
+			return "", err
+		}
+
+		otherConfs = append(otherConfs, newConf)
+	}
+
+	return selfConf + "\n" + strings.Join(otherConfs, "\n"), nil
+}
+
+// GenerateNodeConfigs writes the "node.<id>.wg.conf" file of every node.
+//
+// The configs are rendered concurrently by up to options.Workers workers.
+func GenerateNodeConfigs(
+	subnet *net.IPNet,
+	outDir string,
+	keyStore KeyStore,
+	nodeList []WggNode,
+	clientList []WggClient,
+	options GenOptions,
+) error {
+	return ParallelEach(len(nodeList), options.Workers, func(i int) error {
+		node := nodeList[i]
+
+		conf, err := RenderNodeConfig(subnet, keyStore, node, nodeList, clientList, options)
+		if err != nil {
+			return err
 		}
 
 		outFile := outDir + "/node." + strconv.Itoa(node.ID) + ".wg.conf"
 
-		err := os.WriteFile(outFile, []byte(selfConf+"\n"+strings.Join(otherConfs, "\n")), 0640)
+		err = os.WriteFile(outFile, []byte(conf), 0640)
 		if err != nil {
 			return errors.New("Error writing to '" + outFile + "': " + err.Error())
 		}
-	}
 
-	return nil
+		return nil
+	})
 }
 
+// GenerateClientConfigs writes the "client.<name>.wg.conf" file of every
+// named client and the "client.<id>.wg.conf" file of every unnamed one.
+//
+// The configs are rendered concurrently by up to options.Workers workers.
 func GenerateClientConfigs(
 	subnet *net.IPNet,
 	outDir string,
-	keyDir string,
+	keyStore KeyStore,
 	nodeList []WggNode,
 	clientList []WggClient,
+	options GenOptions,
 ) error {
-	var selfConf string
-	var newConf string
-	var otherConfs []string
+	return ParallelEach(len(clientList), options.Workers, func(i int) error {
+		client := clientList[i]
+
+		conf, err := RenderClientConfig(subnet, keyStore, client, nodeList, options)
+		if err != nil {
+			return err
+		}
+
+		outFile := outDir + "/client." + client.FileID() + ".wg.conf"
+		err = os.WriteFile(outFile, []byte(conf), 0640)
+		if err != nil {
+			return errors.New("Error writing to '" + outFile + "': " + err.Error())
+		}
+
+		return nil
+	})
+}
+
+// ParallelEach calls fn for every index in [0, count) on up to workers
+// goroutines and returns the first error. After an error no new indexes are
+// started. A workers value lower than 1 runs everything sequentially.
+func ParallelEach(count int, workers int, fn func(i int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	workers = min(workers, count)
+
+	indexes := make(chan int)
+	errs := make(chan error, workers)
+	wg := sync.WaitGroup{}
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				err := fn(i)
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
 	var err error
+	for i := 0; i < count && err == nil; i++ {
+		select {
+		case indexes <- i:
+		case err = <-errs:
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+
+	return err
+}
+
+// Targets returns all nodes and clients as one WggTarget list, nodes first.
+func Targets(
+	nodeList []WggNode,
+	clientList []WggClient,
+) []WggTarget {
+	targets := make([]WggTarget, 0, len(nodeList)+len(clientList))
+	for _, node := range nodeList {
+		targets = append(targets, node)
+	}
 	for _, client := range clientList {
-		selfConf, err = GenWgClientConfPart(
-			client,
-			keyDir,
+		targets = append(targets, client)
+	}
+
+	return targets
+}
+
+// InitSubnet returns the WireGuard subnet from the inventory, or from the
+// WGG_SUBNET env var if the inventory is nil or does not set it.
+func InitSubnet(inventory *Inventory) (*net.IPNet, error) {
+	source := "WGG_SUBNET env var"
+	subnetString := os.Getenv("WGG_SUBNET")
+	if inventory != nil && len(inventory.Subnet) > 0 {
+		source = "inventory subnet"
+		subnetString = inventory.Subnet
+	}
+
+	if len(subnetString) <= 0 {
+		return nil, errors.New("the WGG_SUBNET env var is not set or empty")
+	}
+
+	_, subnet, err := net.ParseCIDR(subnetString)
+	if err != nil {
+		return nil, errors.New(
+			"error while parsing " + source + " as CIDR: value '" +
+				subnetString + "': " +
+				err.Error(),
+		)
+	}
+
+	return subnet, nil
+}
+
+// Subnet6Auto is the WGG_SUBNET6 value for a generated unique local prefix.
+const Subnet6Auto = "auto"
+
+// ParseSubnet6 parses the IPv6 overlay prefix of a dual-stack overlay. Every
+// address of the IPv4 subnet is mapped to the address with the same host
+// offset in the prefix, so the prefix must have at least as many host bits.
+func ParseSubnet6(value string, subnet *net.IPNet) (*net.IPNet, error) {
+	_, subnet6, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, errors.New("invalid IPv6 prefix '" + value + "': " + err.Error())
+	} else if subnet6.IP.To4() != nil {
+		return nil, errors.New("'" + value + "' is not an IPv6 prefix")
+	} else if subnet.IP.To4() == nil {
+		return nil, errors.New("a dual-stack overlay needs an IPv4 subnet, not " + subnet.String())
+	}
+
+	ones, bits := subnet.Mask.Size()
+	ones6, bits6 := subnet6.Mask.Size()
+	if bits6-ones6 < bits-ones {
+		return nil, fmt.Errorf(
+			"the IPv6 prefix %s is smaller than the subnet %s, it needs at least %d host bits",
+			subnet6,
 			subnet,
-			client.TargetID(),
+			bits-ones,
 		)
+	}
+
+	return subnet6, nil
+}
 
+// InitSubnet6 returns the IPv6 overlay prefix from the inventory, or from
+// the WGG_SUBNET6 env var if the inventory does not set it, or nil if
+// neither is set.
+//
+// With "auto" the prefix recorded in the ledger is used, or a new RFC 4193
+// unique local /64 is generated and recorded, see Ledger.Subnet6Generated
+// until the ledger is saved. A *ValidationError is returned
+// if the prefix differs from the recorded one, as every IPv6 address would
+// change.
+func InitSubnet6(inventory *Inventory, subnet *net.IPNet, ledger *Ledger) (*net.IPNet, error) {
+	variable := "WGG_SUBNET6"
+	value := os.Getenv("WGG_SUBNET6")
+	if inventory != nil && len(inventory.Subnet6) > 0 {
+		variable = "inventory subnet6"
+		value = inventory.Subnet6
+	}
+
+	if len(value) <= 0 {
+		return nil, nil
+	} else if value == Subnet6Auto && len(ledger.Subnet6) > 0 {
+		value = ledger.Subnet6
+	} else if value == Subnet6Auto {
+		ulaPrefix, err := netutils.NewULAPrefix(rand.Reader)
 		if err != nil {
-			return err
+			return nil, errors.New("error generating an IPv6 prefix: " + err.Error())
 		}
+		value = ulaPrefix.String()
+		ledger.Subnet6Generated = true
+	}
 
-		otherConfs = []string{}
+	subnet6, err := ParseSubnet6(value, subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ledger.Subnet6) > 0 && ledger.Subnet6 != subnet6.String() {
+		validation := &ValidationError{}
+		validation.problem(
+			variable,
+			"the IPv6 prefix %s differs from %s in %s, changing it would renumber every target, "+
+				"delete subnet6 from the ledger to change it on purpose",
+			subnet6,
+			ledger.Subnet6,
+			ledger.Path,
+		)
 
-		for _, node := range nodeList {
-			newConf, err = GenWgClientConfPart(
-				node,
-				keyDir,
-				subnet,
-				client.TargetID(),
+		return nil, validation
+	}
+	ledger.Subnet6 = subnet6.String()
+
+	return subnet6, nil
+}
+
+// OverlayIP6 returns the address of the IPv6 overlay prefix subnet6 that
+// corresponds to ip of the subnet.
+func OverlayIP6(subnet *net.IPNet, subnet6 *net.IPNet, ip net.IP) net.IP {
+	return netutils.AddOffset(subnet6, netutils.HostOffset(subnet, ip))
+}
+
+// InitNodeList returns the nodes of the inventory, or of the WGG_NODE<n> env
+// vars if the inventory is nil or declares no nodes.
+func InitNodeList(inventory *Inventory) ([]WggNode, error) {
+	if inventory != nil && len(inventory.Nodes) > 0 {
+		nodeList := []WggNode{}
+
+		for i, entry := range inventory.Nodes {
+			// removed nodes keep their ID, so the following nodes keep their
+			// IDs and keys
+			if entry.Removed {
+				continue
+			}
+
+			node, err := NewWggNode(
+				i,
+				entry.Endpoint,
 			)
 
 			if err != nil {
-				return err
+				return nil, fmt.Errorf("error while creating inventory node #%d: %w", i, err)
 			}
+			node.Meta = entry.Meta
 
-			otherConfs = append(otherConfs, newConf)
+			nodeList = append(nodeList, node)
 		}
 
-		outFile := outDir + "/client." + strconv.Itoa(client.ID) + ".wg.conf"
-		err := os.WriteFile(outFile, []byte(selfConf+"\n"+strings.Join(otherConfs, "\n")), 0640)
-		if err != nil {
-			return errors.New("Error writing to '" + outFile + "': " + err.Error())
-		}
+		return nodeList, nil
 	}
 
-	return nil
-}
-
-func InitNodeList() ([]WggNode, error) {
 	nodeRawDataList := []string{}
 
 	var i int = 0
@@ -186,21 +469,81 @@ func InitNodeList() ([]WggNode, error) {
 	return nodeList, nil
 }
 
-func InitClientList() ([]WggClient, error) {
-	clientCountString := os.Getenv("WGG_CLIENT_COUNT")
-	if len(clientCountString) <= 0 {
-		return nil, errors.New("the WGG_CLIENT_COUNT env var is not set or empty")
+// InitClientList returns the clients of the inventory, or WGG_CLIENT_COUNT
+// clients if the inventory is nil or declares neither clients nor
+// client_count.
+//
+// Clients without an endpoint_preference in the inventory use the one of the
+// WGG_ENDPOINT_PREFERENCE env var.
+func InitClientList(inventory *Inventory) ([]WggClient, error) {
+	defaultPreference, err := ParseEndpointPreference(os.Getenv("WGG_ENDPOINT_PREFERENCE"))
+	if err != nil {
+		return nil, errors.New("error while parsing WGG_ENDPOINT_PREFERENCE: " + err.Error())
 	}
 
-	clientCount, err := strconv.Atoi(clientCountString)
-	if err != nil {
-		return nil, errors.New(
-			"error while parsing WGG_CLIENT_COUNT as int: value '" +
-				clientCountString + "': " +
-				err.Error(),
-		)
-	} else if clientCount < 0 {
-		return nil, errors.New("the WGG_CLIENT_COUNT env var must be greater than 0")
+	if inventory != nil && len(inventory.Clients) > 0 {
+		clientList := []WggClient{}
+		names := map[string]bool{}
+
+		for i, entry := range inventory.Clients {
+			// removed clients keep their ID, so the following clients keep
+			// their addresses
+			if entry.Removed {
+				continue
+			}
+
+			if len(entry.Name) > 0 {
+				err := ValidateClientName(entry.Name)
+				if err != nil {
+					return nil, fmt.Errorf("inventory client #%d: %w", i, err)
+				} else if names[entry.Name] {
+					return nil, fmt.Errorf("inventory client #%d: duplicate client name '%s'", i, entry.Name)
+				}
+				names[entry.Name] = true
+			}
+
+			client := NewWggClient(
+				i,
+			)
+			client.Name = entry.Name
+			client.Owner = entry.Owner
+			client.Description = entry.Description
+			client.Tags = entry.Tags
+			client.Meta = entry.Meta
+
+			client.PreferredEndpoint = defaultPreference
+			if len(entry.EndpointPreference) > 0 {
+				client.PreferredEndpoint, err = ParseEndpointPreference(entry.EndpointPreference)
+				if err != nil {
+					return nil, fmt.Errorf("inventory client #%d: %w", i, err)
+				}
+			}
+
+			clientList = append(clientList, client)
+		}
+
+		return clientList, nil
+	}
+
+	var clientCount int
+	if inventory != nil && inventory.ClientCount != nil {
+		clientCount = *inventory.ClientCount
+	} else {
+		clientCountString := os.Getenv("WGG_CLIENT_COUNT")
+		if len(clientCountString) <= 0 {
+			return nil, errors.New("the WGG_CLIENT_COUNT env var is not set or empty")
+		}
+
+		clientCount, err = strconv.Atoi(clientCountString)
+		if err != nil {
+			return nil, errors.New(
+				"error while parsing WGG_CLIENT_COUNT as int: value '" +
+					clientCountString + "': " +
+					err.Error(),
+			)
+		} else if clientCount < 0 {
+			return nil, errors.New("the WGG_CLIENT_COUNT env var must be greater than 0")
+		}
 	}
 
 	clientList := []WggClient{}
@@ -212,6 +555,7 @@ func InitClientList() ([]WggClient, error) {
 			client := NewWggClient(
 				i,
 			)
+			client.PreferredEndpoint = defaultPreference
 
 			clientList = append(clientList, client)
 		}
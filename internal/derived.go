@@ -0,0 +1,365 @@
+package wgg
+
+import (
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CoreUnit-NET/wgg/lib/stringfs"
+)
+
+// MasterSeedMinLen is the minimum length of a master seed in bytes.
+const MasterSeedMinLen = 32
+
+// Key sources recorded in the KeySourceManifest.
+const (
+	KeySourceDerived  = "derived"
+	KeySourceOverride = "override"
+)
+
+// KeySourceManifest records for every target whether its keys are derived
+// from the master seed or overridden by keys in the backing KeyStore.
+type KeySourceManifest struct {
+	Targets map[string]string `json:"targets"`
+}
+
+// DeriveWireGuardPrivateKey derives the base64 encoded private key of the
+// target from the master seed via HKDF-SHA256 and clamps it.
+func DeriveWireGuardPrivateKey(seed []byte, targetID string) (string, error) {
+	rawKey, err := hkdf.Key(sha256.New, seed, []byte("wgg"), "wgg private key v1 "+targetID, WireGuardKeyLen)
+	if err != nil {
+		return "", fmt.Errorf("error deriving private key: %w", err)
+	}
+
+	privateKey := [WireGuardKeyLen]byte(rawKey)
+	ClampPrivateKey(&privateKey)
+
+	return base64.StdEncoding.EncodeToString(privateKey[:]), nil
+}
+
+// DeriveWireGuardPresharedKey derives the base64 encoded preshared key of the
+// target pair from the master seed via HKDF-SHA256.
+func DeriveWireGuardPresharedKey(seed []byte, pairID string) (string, error) {
+	rawKey, err := hkdf.Key(sha256.New, seed, []byte("wgg"), "wgg preshared key v1 "+pairID, WireGuardKeyLen)
+	if err != nil {
+		return "", fmt.Errorf("error deriving preshared key: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(rawKey), nil
+}
+
+// DerivedKeyStore derives the keys of every target from a master seed, so
+// the whole key set is reproducible from the seed alone.
+//
+// A target whose key pair exists in the backing KeyStore, e.g. because it
+// was rotated or imported, uses that key pair instead. Which targets use
+// derived and which use overridden keys is recorded in the manifest file.
+type DerivedKeyStore struct {
+	seed         []byte
+	backing      KeyStore
+	manifestPath string
+
+	mu       sync.Mutex
+	manifest KeySourceManifest
+
+	// readOnly keeps newly recorded key sources in memory only.
+	readOnly bool
+}
+
+// NewDerivedKeyStore returns a new DerivedKeyStore and loads the manifest at
+// manifestPath if it exists.
+func NewDerivedKeyStore(
+	seed []byte,
+	backing KeyStore,
+	manifestPath string,
+) (*DerivedKeyStore, error) {
+	if len(seed) < MasterSeedMinLen {
+		return nil, fmt.Errorf("master seed must be at least %d bytes, got %d", MasterSeedMinLen, len(seed))
+	}
+
+	store := &DerivedKeyStore{
+		seed:         seed,
+		backing:      backing,
+		manifestPath: manifestPath,
+		manifest: KeySourceManifest{
+			Targets: map[string]string{},
+		},
+	}
+
+	rawManifest, err := os.ReadFile(manifestPath)
+	if os.IsNotExist(err) {
+		return store, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading key source manifest: %w", err)
+	}
+
+	err = json.Unmarshal(rawManifest, &store.manifest)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing key source manifest '%s': %w", manifestPath, err)
+	} else if store.manifest.Targets == nil {
+		store.manifest.Targets = map[string]string{}
+	}
+
+	return store, nil
+}
+
+// KeySource returns the recorded key source of the target, or "" if the
+// target was not seen yet.
+func (store *DerivedKeyStore) KeySource(targetID string) string {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.manifest.Targets[targetID]
+}
+
+// setKeySource records the key source of the target and saves the manifest
+// if it changed.
+func (store *DerivedKeyStore) setKeySource(targetID string, source string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.manifest.Targets[targetID] == source {
+		return nil
+	}
+	store.manifest.Targets[targetID] = source
+	if store.readOnly {
+		return nil
+	}
+
+	rawManifest, err := json.MarshalIndent(store.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding key source manifest: %w", err)
+	}
+
+	err = stringfs.SafeWriteFileBytes(store.manifestPath, rawManifest, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing key source manifest: %w", err)
+	}
+
+	return nil
+}
+
+// isOverridden returns true if the target uses keys of the backing store.
+// Targets with a key pair in the backing store are recorded as overridden.
+func (store *DerivedKeyStore) isOverridden(targetID string) (bool, error) {
+	if store.KeySource(targetID) == KeySourceOverride {
+		return true, nil
+	}
+
+	_, _, err := store.backing.LoadKeyPair(targetID)
+	if errors.Is(err, ErrKeyNotFound) {
+		return false, store.setKeySource(targetID, KeySourceDerived)
+	} else if err != nil && !errors.Is(err, ErrKeyMismatch) && !errors.Is(err, ErrPublicKeyMissing) {
+		return false, err
+	}
+
+	return true, store.setKeySource(targetID, KeySourceOverride)
+}
+
+func (store *DerivedKeyStore) deriveKeyPair(targetID string) (string, string, error) {
+	privateKey, err := DeriveWireGuardPrivateKey(store.seed, targetID)
+	if err != nil {
+		return "", "", err
+	}
+
+	publicKey, err := DeriveWireGuardPublicKey(privateKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	return privateKey, publicKey, nil
+}
+
+// LoadKeyPair returns the overriding key pair of the target, or derives it.
+func (store *DerivedKeyStore) LoadKeyPair(targetID string) (string, string, error) {
+	overridden, err := store.isOverridden(targetID)
+	if err != nil {
+		return "", "", err
+	} else if overridden {
+		return store.backing.LoadKeyPair(targetID)
+	}
+
+	return store.deriveKeyPair(targetID)
+}
+
+// SaveKeyPair saves the key pair in the backing store, so it overrides the
+// derived key pair from now on.
+func (store *DerivedKeyStore) SaveKeyPair(
+	targetID string,
+	privateKey string,
+	publicKey string,
+) error {
+	err := store.backing.SaveKeyPair(targetID, privateKey, publicKey)
+	if err != nil {
+		return err
+	}
+
+	return store.setKeySource(targetID, KeySourceOverride)
+}
+
+// LoadPublicKey returns the overriding public key of the target, or derives
+// it.
+func (store *DerivedKeyStore) LoadPublicKey(targetID string) (string, error) {
+	overridden, err := store.isOverridden(targetID)
+	if err != nil {
+		return "", err
+	} else if overridden {
+		return store.backing.LoadPublicKey(targetID)
+	}
+
+	_, publicKey, err := store.deriveKeyPair(targetID)
+
+	return publicKey, err
+}
+
+// SavePublicKey saves the public key in the backing store, so it overrides
+// the derived key pair from now on.
+func (store *DerivedKeyStore) SavePublicKey(targetID string, publicKey string) error {
+	err := store.backing.SavePublicKey(targetID, publicKey)
+	if err != nil {
+		return err
+	}
+
+	return store.setKeySource(targetID, KeySourceOverride)
+}
+
+// InitKeyPair returns the overriding key pair of the target, or derives it.
+// Overridden targets without keys get a fresh random key pair.
+func (store *DerivedKeyStore) InitKeyPair(targetID string) (string, string, error) {
+	if store.KeySource(targetID) == KeySourceOverride {
+		return store.backing.InitKeyPair(targetID)
+	}
+
+	return store.LoadKeyPair(targetID)
+}
+
+// isPairOverridden returns true if one of the two targets of the pair is
+// overridden, then their preshared key is kept in the backing store.
+func (store *DerivedKeyStore) isPairOverridden(pairID string) (bool, error) {
+	targetID, otherTargetID, _ := strings.Cut(pairID, "-")
+
+	for _, id := range []string{targetID, otherTargetID} {
+		overridden, err := store.isOverridden(id)
+		if err != nil || overridden {
+			return overridden, err
+		}
+	}
+
+	return false, nil
+}
+
+// LoadPresharedKey returns the preshared key of the backing store if one of
+// the two targets is overridden, or derives it.
+func (store *DerivedKeyStore) LoadPresharedKey(pairID string) (string, error) {
+	overridden, err := store.isPairOverridden(pairID)
+	if err != nil {
+		return "", err
+	} else if overridden {
+		return store.backing.LoadPresharedKey(pairID)
+	}
+
+	return DeriveWireGuardPresharedKey(store.seed, pairID)
+}
+
+// InitPresharedKey derives the preshared key, unless one of the two targets
+// is overridden. Then the preshared key is random and kept in the backing
+// store, so rotating a target also replaces its preshared keys.
+func (store *DerivedKeyStore) InitPresharedKey(pairID string) (string, error) {
+	overridden, err := store.isPairOverridden(pairID)
+	if err != nil {
+		return "", err
+	} else if overridden {
+		return store.backing.InitPresharedKey(pairID)
+	}
+
+	return DeriveWireGuardPresharedKey(store.seed, pairID)
+}
+
+// SavePresharedKey saves the preshared key in the backing store. It is only
+// used if one of the two targets is overridden, see InitPresharedKey.
+func (store *DerivedKeyStore) SavePresharedKey(pairID string, presharedKey string) error {
+	return store.backing.SavePresharedKey(pairID, presharedKey)
+}
+
+// ArchiveKeys archives the keys of the target in the backing store and
+// marks it as overridden, so the next InitKeyPair creates a random key pair
+// independent of the seed.
+func (store *DerivedKeyStore) ArchiveKeys(targetID string, now time.Time) error {
+	err := store.backing.ArchiveKeys(targetID, now)
+	if err != nil {
+		return err
+	}
+
+	return store.setKeySource(targetID, KeySourceOverride)
+}
+
+// GenerateMasterSeed returns a new random base64 encoded master seed.
+func GenerateMasterSeed() (string, error) {
+	seed := make([]byte, MasterSeedMinLen)
+
+	_, err := rand.Read(seed)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate master seed: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(seed), nil
+}
+
+// InitMasterSeed returns the master seed from WGG_MASTER_SEED or the file
+// at WGG_MASTER_SEED_FILE, or nil if neither is set.
+//
+// The seed is base64 encoded. A missing WGG_MASTER_SEED_FILE is created
+// with a new random seed, unless readOnly is set.
+func InitMasterSeed(readOnly bool) ([]byte, error) {
+	encodedSeed := os.Getenv("WGG_MASTER_SEED")
+	seedFile := os.Getenv("WGG_MASTER_SEED_FILE")
+
+	if len(encodedSeed) <= 0 && len(seedFile) > 0 {
+		err := stringfs.ParsePath(&seedFile)
+		if err != nil {
+			return nil, err
+		}
+
+		rawSeed, err := os.ReadFile(seedFile)
+		if os.IsNotExist(err) && readOnly {
+			return nil, errors.New("the master seed at '" + seedFile + "' does not exist yet, run generate first")
+		} else if os.IsNotExist(err) {
+			encodedSeed, err = GenerateMasterSeed()
+			if err != nil {
+				return nil, err
+			}
+
+			err = os.WriteFile(seedFile, []byte(encodedSeed+"\n"), 0600)
+			if err != nil {
+				return nil, errors.New("Error writing master seed to '" + seedFile + "': " + err.Error())
+			}
+
+			fmt.Println("Created a new master seed at " + seedFile + ", back it up!")
+		} else if err != nil {
+			return nil, errors.New("Error reading master seed from '" + seedFile + "': " + err.Error())
+		} else {
+			encodedSeed = string(rawSeed)
+		}
+	}
+
+	if len(encodedSeed) <= 0 {
+		return nil, nil
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedSeed))
+	if err != nil {
+		return nil, fmt.Errorf("master seed is not valid base64: %w", err)
+	} else if len(seed) < MasterSeedMinLen {
+		return nil, fmt.Errorf("master seed must be at least %d bytes, got %d", MasterSeedMinLen, len(seed))
+	}
+
+	return seed, nil
+}
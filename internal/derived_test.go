@@ -0,0 +1,83 @@
+package wgg
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDerivedKeyStore(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, MasterSeedMinLen)
+	keyDir := t.TempDir()
+
+	store, err := NewDerivedKeyStore(seed, NewFileKeyStore(keyDir, nil), keyDir+"/keysources.json")
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	privateKey, publicKey, err := store.InitKeyPair("n0")
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	expected, _ := DeriveWireGuardPrivateKey(seed, "n0")
+	if privateKey != expected {
+		t.Errorf("expected derived private key %s, but got %s", expected, privateKey)
+	}
+	if err := ValidateWireGuardKeyPair(privateKey, publicKey); err != nil {
+		t.Errorf("expected a valid key pair, but got %v", err)
+	}
+	if _, err := os.Stat(keyDir + "/n0.key"); !os.IsNotExist(err) {
+		t.Errorf("expected derived keys not to be written to the keyDir")
+	}
+
+	otherPrivateKey, _, _ := store.InitKeyPair("n1")
+	if otherPrivateKey == privateKey {
+		t.Errorf("expected different targets to derive different keys")
+	}
+
+	presharedKey, _ := store.InitPresharedKey(PresharedKeyID("n0", "n1"))
+	otherPresharedKey, _ := store.InitPresharedKey(PresharedKeyID("n1", "n0"))
+	if presharedKey != otherPresharedKey {
+		t.Errorf("expected both sides to derive the same preshared key")
+	}
+
+	err = RotateWireGuardKeyPairs(store, []WggTarget{WggNode{ID: 0}}, time.Now())
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	rotatedPrivateKey, _, err := store.InitKeyPair("n0")
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	} else if rotatedPrivateKey == privateKey {
+		t.Errorf("expected the rotated target to get an independent key")
+	}
+
+	rawManifest, err := os.ReadFile(keyDir + "/keysources.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := KeySourceManifest{}
+	json.Unmarshal(rawManifest, &manifest)
+	if manifest.Targets["n0"] != KeySourceOverride || manifest.Targets["n1"] != KeySourceDerived {
+		t.Errorf("unexpected key sources: %v", manifest.Targets)
+	}
+
+	// a second run with the same seed reproduces the derived keys
+	store, err = NewDerivedKeyStore(seed, NewFileKeyStore(keyDir, nil), keyDir+"/keysources.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloadedPrivateKey, _, _ := store.InitKeyPair("n1")
+	if reloadedPrivateKey != otherPrivateKey {
+		t.Errorf("expected the derived key to be reproducible")
+	}
+	reloadedPrivateKey, _, _ = store.InitKeyPair("n0")
+	if reloadedPrivateKey != rotatedPrivateKey {
+		t.Errorf("expected the override to be kept")
+	}
+}
@@ -0,0 +1,189 @@
+package wgg
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+)
+
+// FullTunnelAllowedIPs are the AllowedIPs of the exit peer of a client, they
+// route all of its traffic through the exit node.
+const FullTunnelAllowedIPs = "0.0.0.0/0, ::/0"
+
+// ExitSettings holds the exit nodes and the exit node chosen by each client.
+type ExitSettings struct {
+	// Nodes marks the exit nodes by target ID.
+	Nodes map[string]bool
+
+	// Exits maps client target IDs to the target ID of their exit node.
+	Exits map[string]string
+}
+
+// IsExitNode returns true if the target is an exit node.
+func (settings ExitSettings) IsExitNode(target WggTarget) bool {
+	return target.IsNode() && settings.Nodes[target.TargetID()]
+}
+
+// ExitOf returns the target ID of the exit node of the target and false if
+// it has none.
+func (settings ExitSettings) ExitOf(target WggTarget) (string, bool) {
+	exit, ok := settings.Exits[target.TargetID()]
+
+	return exit, ok
+}
+
+// ForwardingCommands returns the PostUp and PostDown commands that let a
+// node forward the traffic of the overlay, and masquerade it behind the
+// address of the node if masquerade is set. subnet6 may be nil.
+func ForwardingCommands(subnet *net.IPNet, subnet6 *net.IPNet, masquerade bool) ([]string, []string) {
+	postUp := EnableForwardingCommands(subnet6)
+	postDown := []string{}
+
+	addRules := func(iptables string, prefix *net.IPNet) {
+		rules := []string{
+			"FORWARD -i %i -j ACCEPT",
+			"FORWARD -o %i -j ACCEPT",
+		}
+		if masquerade {
+			rules = append(rules, "POSTROUTING -t nat -s "+prefix.String()+" ! -o %i -j MASQUERADE")
+		}
+		for _, rule := range rules {
+			postUp = append(postUp, iptables+" -A "+rule)
+			postDown = append(postDown, iptables+" -D "+rule)
+		}
+	}
+
+	addRules("iptables", subnet)
+	if subnet6 != nil {
+		addRules("ip6tables", subnet6)
+	}
+
+	return postUp, postDown
+}
+
+// EnableForwardingCommands returns the PostUp commands that enable IPv4
+// forwarding, and IPv6 forwarding if subnet6 is set.
+func EnableForwardingCommands(subnet6 *net.IPNet) []string {
+	commands := []string{"sysctl -w net.ipv4.ip_forward=1"}
+	if subnet6 != nil {
+		commands = append(commands, "sysctl -w net.ipv6.conf.all.forwarding=1")
+	}
+
+	return commands
+}
+
+// InitExitSettings reads the exit nodes and the exit selection of the
+// clients from the inventory and the env vars, nodeList and clientList hold
+// the existing nodes and clients.
+//
+// WGG_EXIT_NODES is a comma separated list of the node IDs of the exit
+// nodes, as exit of the inventory nodes. WGG_CLIENT_EXITS is a comma
+// separated list of "<client-id>=<node-id>|<node-id>|...", as exits of the
+// inventory clients. A client uses the first exit of its list that exists,
+// the others are its fallback. A client selects its exits either in the
+// inventory or in WGG_CLIENT_EXITS, as only one full-tunnel peer per config
+// is possible. All problems are returned as *ValidationError.
+func InitExitSettings(
+	inventory *Inventory,
+	nodeList []WggNode,
+	clientList []WggClient,
+) (ExitSettings, error) {
+	validation := &ValidationError{}
+	settings := ExitSettings{
+		Nodes: map[string]bool{},
+		Exits: map[string]string{},
+	}
+	if inventory == nil {
+		inventory = &Inventory{}
+	}
+
+	nodes := map[string]bool{}
+	for _, node := range nodeList {
+		nodes[node.TargetID()] = true
+	}
+	clients := map[string]bool{}
+	for _, client := range clientList {
+		clients[client.TargetID()] = true
+	}
+
+	for _, nodeID := range SplitList(os.Getenv("WGG_EXIT_NODES")) {
+		if !nodes[nodeID] {
+			validation.problem("WGG_EXIT_NODES", "%s is not a node", nodeID)
+		}
+		settings.Nodes[nodeID] = true
+	}
+	for i, entry := range inventory.Nodes {
+		if entry.Exit && !entry.Removed {
+			settings.Nodes[WggNode{ID: i}.TargetID()] = true
+		}
+	}
+
+	clientIDs := []string{}
+	selections := map[string][]string{}
+	variables := map[string]string{}
+	for i, entry := range inventory.Clients {
+		if len(entry.Exits) > 0 && !entry.Removed {
+			client := WggClient{ID: i, Name: entry.Name}
+			clientIDs = append(clientIDs, client.TargetID())
+			selections[client.TargetID()] = entry.Exits
+			variables[client.TargetID()] = fmt.Sprintf("inventory clients[%d].exits", i)
+		}
+	}
+	for _, selection := range SplitList(os.Getenv("WGG_CLIENT_EXITS")) {
+		clientID, value, ok := strings.Cut(selection, "=")
+		clientID = strings.TrimSpace(clientID)
+		exits := splitAlternatives(value)
+
+		if !ok || len(clientID) <= 0 || len(exits) <= 0 {
+			validation.problem("WGG_CLIENT_EXITS", "invalid entry '%s', expected <client-id>=<node-id>|<node-id>|...", selection)
+		} else if !clients[clientID] {
+			validation.problem("WGG_CLIENT_EXITS", "%s is not a client", clientID)
+		} else if variable, ok := variables[clientID]; ok && variable == "WGG_CLIENT_EXITS" {
+			validation.problem(
+				"WGG_CLIENT_EXITS",
+				"duplicate entry for %s: '%s' and '%s'",
+				clientID,
+				strings.Join(selections[clientID], "|"),
+				strings.Join(exits, "|"),
+			)
+		} else if ok {
+			validation.problem(
+				"WGG_CLIENT_EXITS",
+				"%s selects its exits in %s and in WGG_CLIENT_EXITS, only one full-tunnel peer per config is possible",
+				clientID,
+				variable,
+			)
+		} else {
+			clientIDs = append(clientIDs, clientID)
+			selections[clientID] = exits
+			variables[clientID] = "WGG_CLIENT_EXITS"
+		}
+	}
+
+	for _, clientID := range clientIDs {
+		exits, variable := selections[clientID], variables[clientID]
+
+		for i, exit := range exits {
+			if slices.Contains(exits[:i], exit) {
+				validation.problem(variable, "%s lists the exit %s twice", clientID, exit)
+			} else if nodes[exit] && !settings.Nodes[exit] {
+				validation.problem(variable, "the exit %s of %s is not an exit node", exit, clientID)
+			} else if _, chosen := settings.Exits[clientID]; !chosen && nodes[exit] {
+				settings.Exits[clientID] = exit
+			}
+		}
+
+		existing := slices.ContainsFunc(exits, func(exit string) bool { return nodes[exit] })
+		if !existing {
+			validation.problem(variable, "none of the exits %s of %s is an existing node", strings.Join(exits, ", "), clientID)
+		}
+	}
+
+	if len(validation.Problems) > 0 {
+		return settings, validation
+	}
+
+	return settings, nil
+}
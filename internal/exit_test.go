@@ -0,0 +1,119 @@
+package wgg
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInitExitSettings(t *testing.T) {
+	t.Setenv("WGG_EXIT_NODES", "n2")
+	t.Setenv("WGG_CLIENT_EXITS", "c1=n1|n2")
+
+	inventory := &Inventory{
+		Nodes: []InventoryNode{
+			{Endpoint: "192.0.2.1:55333", Exit: true},
+			{Removed: true},
+			{Endpoint: "192.0.2.3:55333"},
+		},
+		Clients: []InventoryClient{
+			{Name: "laptop", Exits: []string{"n0", "n2"}},
+			{},
+		},
+	}
+	nodeList := []WggNode{{ID: 0}, {ID: 2}}
+	clientList := []WggClient{{ID: 0, Name: "laptop"}, {ID: 1}}
+
+	settings, err := InitExitSettings(inventory, nodeList, clientList)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	expected := map[string]string{"laptop": "n0", "c1": "n2"}
+	if !reflect.DeepEqual(settings.Exits, expected) {
+		t.Errorf("expected exits %v, but got %v", expected, settings.Exits)
+	}
+	if !settings.IsExitNode(WggNode{ID: 2}) || settings.IsExitNode(WggClient{ID: 0}) {
+		t.Errorf("expected n2 to be the only exit node besides n0, but got %v", settings.Nodes)
+	}
+
+	cases := []struct {
+		clientExits string
+		exits       []string
+		expected    string
+	}{
+		{"", []string{"n2", "n0"}, "the exit n2 of laptop is not an exit node"},
+		{"", []string{"n0", "n0"}, "laptop lists the exit n0 twice"},
+		{"", []string{"n1", "n5"}, "none of the exits n1, n5 of laptop is an existing node"},
+		{"laptop=n0", []string{"n0"}, "only one full-tunnel peer per config is possible"},
+		{"c1", nil, "invalid entry 'c1'"},
+		{"c5=n0", nil, "c5 is not a client"},
+		{"c1=n0,c1=n2|n0", nil, "duplicate entry for c1: 'n0' and 'n2|n0'"},
+		{"c0=n0", nil, "c0 is not a client"},
+	}
+	for _, c := range cases {
+		t.Setenv("WGG_EXIT_NODES", "")
+		t.Setenv("WGG_CLIENT_EXITS", c.clientExits)
+		inventory.Clients[0].Exits = c.exits
+
+		_, err := InitExitSettings(inventory, nodeList, clientList)
+		var validation *ValidationError
+		if !errors.As(err, &validation) || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("expected a ValidationError with %q, but got %v", c.expected, err)
+		}
+	}
+}
+
+func TestGenWgClientConfPartExitNode(t *testing.T) {
+	keyStore := NewFileKeyStore(t.TempDir(), nil)
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	_, subnet6, _ := net.ParseCIDR("fd00:10::/64")
+	exitNode := WggNode{ID: 0, Host: "192.0.2.1", Port: 55333}
+	otherNode := WggNode{ID: 1, Host: "192.0.2.2", Port: 55333}
+	client := NewWggClient(0)
+	options := GenOptions{
+		Subnet6: subnet6,
+		Exits: ExitSettings{
+			Nodes: map[string]bool{"n0": true},
+			Exits: map[string]string{"c0": "n0"},
+		},
+	}
+
+	conf, err := GenWgClientConfPart(exitNode, keyStore, subnet, client, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	} else if !strings.Contains(conf, "AllowedIPs = "+FullTunnelAllowedIPs+"\n") {
+		t.Errorf("expected the exit peer to be the full-tunnel peer, but got:\n%s", conf)
+	}
+
+	conf, err = GenWgClientConfPart(otherNode, keyStore, subnet, client, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	} else if strings.Contains(conf, FullTunnelAllowedIPs) {
+		t.Errorf("expected only the exit peer to be the full-tunnel peer, but got:\n%s", conf)
+	}
+
+	conf, err = GenWgClientConfPart(exitNode, keyStore, subnet, exitNode, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	for _, line := range []string{
+		"PostUp = sysctl -w net.ipv4.ip_forward=1\n",
+		"PostUp = iptables -A POSTROUTING -t nat -s 10.10.10.0/24 ! -o %i -j MASQUERADE\n",
+		"PostUp = ip6tables -A POSTROUTING -t nat -s fd00:10::/64 ! -o %i -j MASQUERADE\n",
+		"PostDown = iptables -D FORWARD -i %i -j ACCEPT\n",
+	} {
+		if !strings.Contains(conf, line) {
+			t.Errorf("expected the exit node config to contain %q, but got:\n%s", line, conf)
+		}
+	}
+
+	conf, err = GenWgClientConfPart(otherNode, keyStore, subnet, otherNode, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	} else if strings.Contains(conf, "PostUp") {
+		t.Errorf("expected no forwarding on other nodes, but got:\n%s", conf)
+	}
+}
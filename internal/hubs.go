@@ -0,0 +1,280 @@
+package wgg
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/CoreUnit-NET/wgg/lib/netutils"
+)
+
+// HubGroup is a group of clients that reach each other through a hub node.
+type HubGroup struct {
+	Name string
+
+	// Hub is the target ID of the hub node of the group.
+	Hub string
+
+	// Prefixes are the parts of the overlay the members route through the
+	// hub, by default the whole overlay.
+	Prefixes []*net.IPNet
+
+	Members []WggClient
+}
+
+// HubSettings holds the hub groups and the group of each member.
+type HubSettings struct {
+	Groups []*HubGroup
+
+	// Clients maps the client target IDs to their group.
+	Clients map[string]*HubGroup
+}
+
+// GroupOf returns the hub group of the target, nil if it has none.
+func (settings HubSettings) GroupOf(target WggTarget) *HubGroup {
+	if target.IsNode() {
+		return nil
+	}
+
+	return settings.Clients[target.TargetID()]
+}
+
+// HubCommands returns the PostUp and PostDown commands that let the target
+// forward the traffic between the members of its groups, nil if it is no
+// hub. A member only reaches the other members of its group whose addresses
+// lie in the prefixes of the group, all other traffic between peers of the
+// hub is dropped, so the groups are isolated from each other and from the
+// clients in no group. The commands must come before the ACCEPT rules of
+// ForwardingCommands. Forwarding itself is not enabled by them, see
+// EnableForwardingCommands. subnet6 may be nil.
+//
+// Each group gets its own chain "%i-hub<n>" that accepts the destinations
+// of the group, the traffic of every member jumps to it, so the number of
+// rules grows linearly with the members.
+func (settings HubSettings) HubCommands(target WggTarget, subnet *net.IPNet, subnet6 *net.IPNet) ([]string, []string) {
+	var postUp, postDown, removeChains []string
+	addRule := func(iptables string, rule string) {
+		postUp = append(postUp, iptables+" -A "+rule)
+		postDown = append(postDown, iptables+" -D "+rule)
+	}
+
+	hub := false
+	for i, group := range settings.Groups {
+		if !target.IsNode() || group.Hub != target.TargetID() {
+			continue
+		}
+		hub = true
+
+		addresses := []net.IP{}
+		for _, member := range group.Members {
+			address := member.WireGuardSubnetIP(subnet)
+			addresses = append(addresses, address)
+			if subnet6 != nil {
+				addresses = append(addresses, OverlayIP6(subnet, subnet6, address))
+			}
+		}
+
+		chain := fmt.Sprintf("%%i-hub%d", i)
+		for _, iptables := range []string{"iptables", "ip6tables"} {
+			family := slices.DeleteFunc(slices.Clone(addresses), func(address net.IP) bool {
+				return (address.To4() == nil) != (iptables == "ip6tables")
+			})
+			if len(family) <= 0 {
+				continue
+			}
+
+			postUp = append(postUp, iptables+" -N "+chain)
+			for _, destination := range family {
+				inPrefixes := slices.ContainsFunc(group.Prefixes, func(prefix *net.IPNet) bool {
+					return prefix.Contains(destination)
+				})
+				if inPrefixes {
+					postUp = append(postUp, iptables+" -A "+chain+" -d "+hostPrefix(destination)+" -j ACCEPT")
+				}
+			}
+			for _, source := range family {
+				addRule(iptables, "FORWARD -i %i -o %i -s "+hostPrefix(source)+" -j "+chain)
+			}
+			removeChains = append(removeChains, iptables+" -F "+chain, iptables+" -X "+chain)
+		}
+	}
+
+	if hub {
+		addRule("iptables", "FORWARD -i %i -o %i -j DROP")
+		if subnet6 != nil {
+			addRule("ip6tables", "FORWARD -i %i -o %i -j DROP")
+		}
+	}
+
+	// the chains can only be removed once no rule jumps to them
+	return postUp, slices.Concat(postDown, removeChains)
+}
+
+// InitHubSettings reads the hub groups and their members from the inventory
+// and the env vars. subnet6 may be nil, nodeList and clientList hold the
+// existing nodes and clients.
+//
+// WGG_HUB_GROUPS is a comma separated list of "<group>=<node-id>|<node-id>|...",
+// as hub_groups of the inventory, which take precedence over it. A group
+// uses the first existing node of its list as hub, the others are its
+// fallback. WGG_HUB_GROUP_PREFIXES is a comma separated list of
+// "<group>=<prefix>|<prefix>|...", the prefixes must be part of the overlay.
+// WGG_CLIENT_HUB_GROUPS is a comma separated list of "<client-id>=<group>",
+// as hub_group of the inventory clients. A client is member of at most one
+// group, so the groups are isolated from each other. All problems are
+// returned as *ValidationError.
+func InitHubSettings(
+	inventory *Inventory,
+	subnet *net.IPNet,
+	subnet6 *net.IPNet,
+	nodeList []WggNode,
+	clientList []WggClient,
+) (HubSettings, error) {
+	validation := &ValidationError{}
+	settings := HubSettings{
+		Clients: map[string]*HubGroup{},
+	}
+	if inventory == nil {
+		inventory = &Inventory{}
+	}
+
+	nodes := map[string]bool{}
+	for _, node := range nodeList {
+		nodes[node.TargetID()] = true
+	}
+
+	groups := map[string]*HubGroup{}
+	addGroup := func(name string, hubs []string, prefixes []string, variable string) {
+		if len(name) <= 0 {
+			validation.problem(variable, "the hub group has no name")
+			return
+		} else if _, ok := groups[name]; ok {
+			validation.problem(variable, "duplicate hub group '%s'", name)
+			return
+		}
+
+		group := &HubGroup{Name: name}
+		for i, hub := range hubs {
+			if slices.Contains(hubs[:i], hub) {
+				validation.problem(variable, "hub group '%s' lists the hub %s twice", name, hub)
+			} else if len(group.Hub) <= 0 && nodes[hub] {
+				group.Hub = hub
+			}
+		}
+		if len(group.Hub) <= 0 {
+			validation.problem(variable, "none of the hubs %s of hub group '%s' is an existing node", strings.Join(hubs, ", "), name)
+		}
+
+		for _, value := range prefixes {
+			ip, prefix, err := net.ParseCIDR(value)
+			if err != nil {
+				validation.problem(variable, "invalid prefix '%s' of hub group '%s': %s", value, name, err.Error())
+			} else if !ip.Equal(prefix.IP) {
+				validation.problem(variable, "%s of hub group '%s' is not a network prefix, expected %s", value, name, prefix)
+			} else if !netutils.ContainsSubnet(subnet, prefix) &&
+				(subnet6 == nil || !netutils.ContainsSubnet(subnet6, prefix)) {
+				validation.problem(variable, "%s of hub group '%s' is not part of the overlay", prefix, name)
+			} else {
+				group.Prefixes = append(group.Prefixes, prefix)
+			}
+		}
+		if len(prefixes) <= 0 {
+			group.Prefixes = []*net.IPNet{subnet}
+			if subnet6 != nil {
+				group.Prefixes = append(group.Prefixes, subnet6)
+			}
+		}
+
+		groups[name] = group
+		settings.Groups = append(settings.Groups, group)
+	}
+
+	inventoryGroups := map[string]bool{}
+	for i, entry := range inventory.HubGroups {
+		inventoryGroups[entry.Name] = true
+		addGroup(entry.Name, entry.Hubs, entry.Prefixes, fmt.Sprintf("inventory hub_groups[%d]", i))
+	}
+
+	envPrefixNames := []string{}
+	envPrefixes := map[string][]string{}
+	for _, entry := range SplitList(os.Getenv("WGG_HUB_GROUP_PREFIXES")) {
+		name, value, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || len(name) <= 0 {
+			validation.problem("WGG_HUB_GROUP_PREFIXES", "invalid entry '%s', expected <group>=<prefix>|<prefix>|...", entry)
+			continue
+		}
+		envPrefixNames = append(envPrefixNames, name)
+		envPrefixes[name] = splitAlternatives(value)
+	}
+
+	for _, entry := range SplitList(os.Getenv("WGG_HUB_GROUPS")) {
+		name, value, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		hubs := splitAlternatives(value)
+		if !ok || len(name) <= 0 || len(hubs) <= 0 {
+			validation.problem("WGG_HUB_GROUPS", "invalid entry '%s', expected <group>=<node-id>|<node-id>|...", entry)
+		} else if !inventoryGroups[name] {
+			addGroup(name, hubs, envPrefixes[name], "WGG_HUB_GROUPS")
+		}
+	}
+	for _, name := range envPrefixNames {
+		if _, ok := groups[name]; !ok {
+			validation.problem("WGG_HUB_GROUP_PREFIXES", "unknown hub group '%s'", name)
+		}
+	}
+
+	clients := map[string]WggClient{}
+	for _, client := range clientList {
+		clients[client.TargetID()] = client
+	}
+
+	addMember := func(clientID string, name string, variable string) {
+		client, ok := clients[clientID]
+		group, groupOk := groups[name]
+		if !ok {
+			validation.problem(variable, "%s is not a client", clientID)
+		} else if !groupOk {
+			validation.problem(variable, "unknown hub group '%s' of %s", name, clientID)
+		} else if _, member := settings.Clients[clientID]; !member {
+			group.Members = append(group.Members, client)
+			settings.Clients[clientID] = group
+		}
+	}
+
+	for i, entry := range inventory.Clients {
+		if len(entry.HubGroup) > 0 && !entry.Removed {
+			client := WggClient{ID: i, Name: entry.Name}
+			addMember(client.TargetID(), entry.HubGroup, fmt.Sprintf("inventory clients[%d].hub_group", i))
+		}
+	}
+	for _, entry := range SplitList(os.Getenv("WGG_CLIENT_HUB_GROUPS")) {
+		clientID, name, ok := strings.Cut(entry, "=")
+		clientID, name = strings.TrimSpace(clientID), strings.TrimSpace(name)
+		if !ok || len(clientID) <= 0 || len(name) <= 0 {
+			validation.problem("WGG_CLIENT_HUB_GROUPS", "invalid entry '%s', expected <client-id>=<group>", entry)
+		} else {
+			addMember(clientID, name, "WGG_CLIENT_HUB_GROUPS")
+		}
+	}
+
+	if len(validation.Problems) > 0 {
+		return settings, validation
+	}
+
+	return settings, nil
+}
+
+// splitAlternatives splits a "|" separated list and drops empty entries.
+func splitAlternatives(value string) []string {
+	list := []string{}
+	for _, entry := range strings.Split(value, "|") {
+		if entry = strings.TrimSpace(entry); len(entry) > 0 {
+			list = append(list, entry)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,230 @@
+package wgg
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestInitHubSettings(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	nodeList := []WggNode{{ID: 0}, {ID: 1}}
+	clientList := []WggClient{{ID: 0, Name: "laptop"}, {ID: 1}, {ID: 2}}
+
+	t.Setenv("WGG_HUB_GROUPS", "lab=n5|n1")
+	t.Setenv("WGG_HUB_GROUP_PREFIXES", "lab=10.10.10.128/25")
+	t.Setenv("WGG_CLIENT_HUB_GROUPS", "c1=lab,laptop=lab")
+	inventory := &Inventory{
+		HubGroups: []InventoryHubGroup{{Name: "ops", Hubs: []string{"n0"}}},
+		Clients: []InventoryClient{
+			{Name: "laptop", HubGroup: "ops"},
+			{},
+			{},
+		},
+	}
+
+	settings, err := InitHubSettings(inventory, subnet, nil, nodeList, clientList)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	ops, lab := settings.GroupOf(clientList[0]), settings.GroupOf(clientList[1])
+	if ops == nil || ops.Name != "ops" || ops.Hub != "n0" || ops.Prefixes[0].String() != "10.10.10.0/24" {
+		t.Errorf("expected laptop in ops with hub n0 and the overlay prefix, but got %+v", ops)
+	}
+	if lab == nil || lab.Name != "lab" || lab.Hub != "n1" || lab.Prefixes[0].String() != "10.10.10.128/25" {
+		t.Errorf("expected c1 in lab with the fallback hub n1 and its prefix, but got %+v", lab)
+	}
+	if group := settings.GroupOf(clientList[2]); group != nil {
+		t.Errorf("expected c2 in no hub group, but got %+v", group)
+	}
+
+	cases := []struct {
+		groups   string
+		members  string
+		expected string
+	}{
+		{"lab=n5", "", "none of the hubs n5 of hub group 'lab' is an existing node"},
+		{"lab=n1,lab=n0", "", "duplicate hub group 'lab'"},
+		{"lab=n1|n1", "", "hub group 'lab' lists the hub n1 twice"},
+		{"lab=n1", "c9=lab", "c9 is not a client"},
+		{"lab=n1", "c1=dev", "unknown hub group 'dev' of c1"},
+		{"lab", "", "invalid entry 'lab'"},
+	}
+	for _, c := range cases {
+		t.Setenv("WGG_HUB_GROUPS", c.groups)
+		t.Setenv("WGG_HUB_GROUP_PREFIXES", "")
+		t.Setenv("WGG_CLIENT_HUB_GROUPS", c.members)
+
+		_, err := InitHubSettings(inventory, subnet, nil, nodeList, clientList)
+		var validation *ValidationError
+		if !errors.As(err, &validation) || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("expected a ValidationError with %q, but got %v", c.expected, err)
+		}
+	}
+
+	t.Setenv("WGG_HUB_GROUPS", "lab=n1")
+	for _, prefixes := range []string{"lab=10.20.0.0/24", "lab=10.10.10.1/24", "lab=fd00::/64", "dev=10.10.10.0/25"} {
+		t.Setenv("WGG_HUB_GROUP_PREFIXES", prefixes)
+
+		_, err := InitHubSettings(inventory, subnet, nil, nodeList, clientList)
+		if err == nil {
+			t.Errorf("expected error for the prefixes '%s', but got none", prefixes)
+		}
+	}
+}
+
+func TestGenWgClientConfPartHubs(t *testing.T) {
+	keyStore := NewFileKeyStore(t.TempDir(), nil)
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	_, subnet6, _ := net.ParseCIDR("fd00:10::/64")
+	hub := WggNode{ID: 0, Host: "192.0.2.1", Port: 55333}
+	otherNode := WggNode{ID: 1, Host: "192.0.2.2", Port: 55333}
+	member := NewWggClient(0)
+	outsider := NewWggClient(1)
+	otherMember := NewWggClient(2)
+	group := &HubGroup{Name: "ops", Hub: "n0", Prefixes: []*net.IPNet{subnet, subnet6}, Members: []WggClient{member, otherMember}}
+	options := GenOptions{
+		Subnet6: subnet6,
+		Hubs:    HubSettings{Groups: []*HubGroup{group}, Clients: map[string]*HubGroup{"c0": group, "c2": group}},
+	}
+
+	conf, err := GenWgClientConfPart(hub, keyStore, subnet, member, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	} else if !strings.Contains(conf, "AllowedIPs = 10.10.10.1/32, fd00:10::1/128, 10.10.10.0/24, fd00:10::/64\n") {
+		t.Errorf("expected the overlay routed through the hub, but got:\n%s", conf)
+	}
+
+	for _, peer := range []struct {
+		target    WggTarget
+		forTarget WggTarget
+	}{{otherNode, member}, {hub, outsider}} {
+		conf, err = GenWgClientConfPart(peer.target, keyStore, subnet, peer.forTarget, options)
+		if err != nil {
+			t.Fatalf("did not expect error, but got %v", err)
+		} else if strings.Contains(conf, "10.10.10.0/24") {
+			t.Errorf("expected no overlay route to %s for %s, but got:\n%s", peer.target.TargetID(), peer.forTarget.TargetID(), conf)
+		}
+	}
+
+	conf, err = GenWgClientConfPart(hub, keyStore, subnet, hub, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	for _, line := range []string{
+		"PostUp = sysctl -w net.ipv4.ip_forward=1\n",
+		"PostUp = iptables -N %i-hub0\n",
+		"PostUp = iptables -A %i-hub0 -d 10.10.10.252/32 -j ACCEPT\n",
+		"PostUp = iptables -A FORWARD -i %i -o %i -s 10.10.10.254/32 -j %i-hub0\n",
+		"PostUp = ip6tables -A %i-hub0 -d fd00:10::fc/128 -j ACCEPT\n",
+		"PostUp = ip6tables -A FORWARD -i %i -o %i -s fd00:10::fe/128 -j %i-hub0\n",
+		"PostUp = iptables -A FORWARD -i %i -o %i -j DROP\n",
+		"PostUp = ip6tables -A FORWARD -i %i -o %i -j DROP\n",
+		"PostDown = iptables -D FORWARD -i %i -o %i -s 10.10.10.254/32 -j %i-hub0\n",
+		"PostDown = iptables -D FORWARD -i %i -o %i -j DROP\n",
+		"PostDown = iptables -F %i-hub0\n",
+		"PostDown = ip6tables -X %i-hub0\n",
+	} {
+		if !strings.Contains(conf, line) {
+			t.Errorf("expected the hub config to contain %q, but got:\n%s", line, conf)
+		}
+	}
+	if strings.Contains(conf, "FORWARD -i %i -j ACCEPT") {
+		t.Errorf("expected the hub to forward only the traffic of its members, but got:\n%s", conf)
+	}
+}
+
+// forwardVerdict returns the target of the first IPv4 FORWARD rule of the
+// PostUp commands that matches the traffic from source to destination
+// between two peers of the interface, following the jumps to other chains.
+func forwardVerdict(postUp []string, chain string, source string, destination string) string {
+	for _, command := range postUp {
+		fields := strings.Fields(command)
+		if len(fields) < 3 || fields[0] != "iptables" || fields[1] != "-A" || fields[2] != chain {
+			continue
+		}
+
+		matches, target := true, ""
+		for i := 3; i+1 < len(fields); i++ {
+			switch fields[i] {
+			case "-s":
+				matches = matches && fields[i+1] == source+"/32"
+			case "-d":
+				matches = matches && fields[i+1] == destination+"/32"
+			case "-j":
+				target = fields[i+1]
+			}
+		}
+		if !matches {
+			continue
+		}
+
+		if target == "ACCEPT" || target == "DROP" {
+			return target
+		} else if verdict := forwardVerdict(postUp, target, source, destination); len(verdict) > 0 {
+			return verdict
+		}
+	}
+
+	return ""
+}
+
+func TestHubCommandsIsolation(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	hub := WggNode{ID: 0, Host: "192.0.2.1", Port: 55333}
+	ops := &HubGroup{Name: "ops", Hub: "n0", Prefixes: []*net.IPNet{subnet}, Members: []WggClient{NewWggClient(0), NewWggClient(1)}}
+	dev := &HubGroup{Name: "dev", Hub: "n0", Prefixes: []*net.IPNet{subnet}, Members: []WggClient{NewWggClient(2), NewWggClient(3)}}
+	hubs := HubSettings{
+		Groups:  []*HubGroup{ops, dev},
+		Clients: map[string]*HubGroup{"c0": ops, "c1": ops, "c2": dev, "c3": dev},
+	}
+
+	// the hub is an exit node too, so its generic ACCEPT rules follow
+	hubPostUp, _ := hubs.HubCommands(hub, subnet, nil)
+	forwardPostUp, _ := ForwardingCommands(subnet, nil, true)
+	postUp := slices.Concat(hubPostUp, forwardPostUp)
+
+	cases := []struct {
+		source      string
+		destination string
+		expected    string
+	}{
+		{"10.10.10.254", "10.10.10.253", "ACCEPT"},
+		{"10.10.10.252", "10.10.10.251", "ACCEPT"},
+		{"10.10.10.254", "10.10.10.252", "DROP"},
+		{"10.10.10.251", "10.10.10.253", "DROP"},
+		{"10.10.10.250", "10.10.10.254", "DROP"},
+		{"10.10.10.253", "10.10.10.250", "DROP"},
+	}
+	for _, c := range cases {
+		verdict := forwardVerdict(postUp, "FORWARD", c.source, c.destination)
+		if verdict != c.expected {
+			t.Errorf("expected %s from %s to %s, but got %q", c.expected, c.source, c.destination, verdict)
+		}
+	}
+}
+
+func TestHubCommandsLargeGroup(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.10.0.0/16")
+	_, subnet6, _ := net.ParseCIDR("fd00:10::/64")
+	hub := WggNode{ID: 0, Host: "192.0.2.1", Port: 55333}
+	group := &HubGroup{Name: "ops", Hub: "n0", Prefixes: []*net.IPNet{subnet, subnet6}}
+	for id := range 5000 {
+		group.Members = append(group.Members, NewWggClient(id))
+	}
+	hubs := HubSettings{Groups: []*HubGroup{group}}
+
+	postUp, postDown := hubs.HubCommands(hub, subnet, subnet6)
+
+	// per family a chain, one ACCEPT and one jump per member and the DROP
+	expected := 2 * (1 + 2*len(group.Members) + 1)
+	if len(postUp) != expected {
+		t.Errorf("expected %d PostUp commands, but got %d", expected, len(postUp))
+	}
+	if len(postDown) != 2*(len(group.Members)+3) {
+		t.Errorf("expected %d PostDown commands, but got %d", 2*(len(group.Members)+3), len(postDown))
+	}
+}
@@ -0,0 +1,301 @@
+package wgg
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ImportReport lists what ImportWgQuickConfs imported and every problem it
+// found along the way.
+type ImportReport struct {
+	Imported []string
+	Problems []string
+}
+
+func (report *ImportReport) problem(format string, args ...any) {
+	report.Problems = append(report.Problems, fmt.Sprintf(format, args...))
+}
+
+// TargetByIP returns the target whose WireGuardSubnetIP is ip, or nil.
+func TargetByIP(
+	ip net.IP,
+	subnet *net.IPNet,
+	nodeList []WggNode,
+	clientList []WggClient,
+) WggTarget {
+	for _, target := range Targets(nodeList, clientList) {
+		if target.WireGuardSubnetIP(subnet).Equal(ip) {
+			return target
+		}
+	}
+
+	return nil
+}
+
+// ImportWgQuickConfs imports the keys of existing wg-quick configs into the
+// keyStore, so adopting wgg keeps the current identities.
+//
+// The [Interface] Address of each config is mapped to the node or client
+// with the same WireGuardSubnetIP, and its private key is saved for that
+// target. The public keys of the [Peer] sections are cross-checked against
+// the imported keys. The PresharedKey of a [Peer] section is saved for the
+// pair, once the key pairs of both sides are imported and all configs of
+// the pair agree on it. Addresses that do not fit the wgg scheme, keys that
+// conflict with existing ones or with each other and targets that stay
+// without a key are reported as problems, they do not stop the import.
+func ImportWgQuickConfs(
+	paths []string,
+	subnet *net.IPNet,
+	nodeList []WggNode,
+	clientList []WggClient,
+	keyStore KeyStore,
+) (ImportReport, error) {
+	report := ImportReport{}
+
+	// public keys seen in [Peer] sections, by target ID
+	peerPublicKeys := map[string]string{}
+	imported := map[string]string{}
+
+	// preshared keys by PresharedKeyID, empty if a peer has none
+	presharedKeys := map[string]string{}
+	presharedKeyPaths := map[string]string{}
+	conflicts := map[string]bool{}
+
+	for _, path := range paths {
+		rawData, err := os.ReadFile(path)
+		if err != nil {
+			return report, errors.New("Error reading '" + path + "': " + err.Error())
+		}
+
+		conf, err := ParseWgQuickConf(string(rawData))
+		if err != nil {
+			return report, fmt.Errorf("error parsing '%s': %w", path, err)
+		}
+
+		target := importTarget(path, conf.Interface.Addresses, subnet, nodeList, clientList, &report)
+		if target != nil {
+			publicKey, ok := importKeyPair(path, target, conf, keyStore, &report)
+			if ok {
+				imported[target.TargetID()] = publicKey
+			}
+		}
+
+		for _, peer := range conf.Peers {
+			// routed networks in AllowedIPs do not identify a peer
+			hostAddresses := []string{}
+			for _, allowedIP := range peer.AllowedIPs {
+				_, network, err := net.ParseCIDR(allowedIP)
+				if err == nil {
+					ones, bits := network.Mask.Size()
+					if ones != bits {
+						continue
+					}
+				}
+				hostAddresses = append(hostAddresses, allowedIP)
+			}
+
+			peerTarget := importTarget(path, hostAddresses, subnet, nodeList, clientList, &report)
+			if peerTarget == nil {
+				continue
+			}
+
+			knownPublicKey, ok := peerPublicKeys[peerTarget.TargetID()]
+			if ok && knownPublicKey != peer.PublicKey {
+				report.problem(
+					"%s: peer %s has public key %s, but another config uses %s",
+					path,
+					peerTarget.TargetID(),
+					peer.PublicKey,
+					knownPublicKey,
+				)
+			}
+			peerPublicKeys[peerTarget.TargetID()] = peer.PublicKey
+
+			if target == nil {
+				continue
+			}
+
+			pairID := PresharedKeyID(target.TargetID(), peerTarget.TargetID())
+			presharedKey := strings.TrimSpace(peer.PresharedKey)
+			knownPresharedKey, ok := presharedKeys[pairID]
+			if ok && knownPresharedKey != presharedKey {
+				report.problem(
+					"%s: the preshared key of %s differs from the one in %s",
+					path,
+					pairID,
+					presharedKeyPaths[pairID],
+				)
+				conflicts[pairID] = true
+			}
+			presharedKeys[pairID] = presharedKey
+			presharedKeyPaths[pairID] = path
+		}
+	}
+
+	targetIDs := slices.Sorted(maps.Keys(peerPublicKeys))
+	for _, targetID := range targetIDs {
+		peerPublicKey := peerPublicKeys[targetID]
+		publicKey, ok := imported[targetID]
+		if !ok {
+			report.problem(
+				"%s: no config with its private key was imported, it will be re-keyed",
+				targetID,
+			)
+		} else if publicKey != peerPublicKey {
+			report.problem(
+				"%s: its peers use public key %s, but its private key derives %s",
+				targetID,
+				peerPublicKey,
+				publicKey,
+			)
+		}
+	}
+
+	pairIDs := slices.Sorted(maps.Keys(presharedKeys))
+	for _, pairID := range pairIDs {
+		presharedKey, path := presharedKeys[pairID], presharedKeyPaths[pairID]
+		if len(presharedKey) == 0 || conflicts[pairID] {
+			continue
+		}
+
+		targetID, otherTargetID, _ := strings.Cut(pairID, "-")
+		_, ok := imported[targetID]
+		_, otherOk := imported[otherTargetID]
+		if !ok || !otherOk {
+			report.problem(
+				"%s: the preshared key of %s is not imported, as the key pairs of both sides are not",
+				path,
+				pairID,
+			)
+			continue
+		}
+
+		_, err := ParseWireGuardKey(presharedKey)
+		if err != nil {
+			report.problem("%s: invalid preshared key of %s: %s", path, pairID, err.Error())
+			continue
+		}
+
+		err = keyStore.SavePresharedKey(pairID, presharedKey)
+		if err != nil {
+			report.problem("%s: error saving preshared key of %s: %s", path, pairID, err.Error())
+			continue
+		}
+
+		report.Imported = append(report.Imported, "preshared key "+pairID+" from "+path)
+	}
+
+	return report, nil
+}
+
+// importTarget maps the addresses of an interface or peer to a wgg target.
+// Addresses outside of the subnet are ignored, addresses inside of it must
+// belong to exactly one target.
+func importTarget(
+	path string,
+	addresses []string,
+	subnet *net.IPNet,
+	nodeList []WggNode,
+	clientList []WggClient,
+	report *ImportReport,
+) WggTarget {
+	var target WggTarget
+
+	for _, address := range addresses {
+		ip, _, err := net.ParseCIDR(address)
+		if err != nil {
+			ip = net.ParseIP(address)
+		}
+		if ip == nil {
+			report.problem("%s: invalid address '%s'", path, address)
+			continue
+		}
+
+		if !subnet.Contains(ip) {
+			continue
+		}
+
+		addressTarget := TargetByIP(ip, subnet, nodeList, clientList)
+		if addressTarget == nil {
+			report.problem(
+				"%s: address %s does not fit the WireGuardSubnetIP scheme of %s",
+				path,
+				address,
+				subnet,
+			)
+		} else if target != nil && target.TargetID() != addressTarget.TargetID() {
+			report.problem(
+				"%s: addresses map to both %s and %s",
+				path,
+				target.TargetID(),
+				addressTarget.TargetID(),
+			)
+		} else {
+			target = addressTarget
+		}
+	}
+
+	return target
+}
+
+// importKeyPair saves the private key of conf for target, unless the
+// target already has a different key pair.
+func importKeyPair(
+	path string,
+	target WggTarget,
+	conf WgQuickConf,
+	keyStore KeyStore,
+	report *ImportReport,
+) (string, bool) {
+	privateKey := strings.TrimSpace(conf.Interface.PrivateKey)
+	if len(privateKey) == 0 {
+		report.problem("%s: %s has no PrivateKey", path, target.TargetID())
+		return "", false
+	}
+
+	publicKey, err := DeriveWireGuardPublicKey(privateKey)
+	if err != nil {
+		report.problem("%s: %s: %s", path, target.TargetID(), err.Error())
+		return "", false
+	}
+
+	if target.IsNode() && conf.Interface.ListenPort != target.NodePort() {
+		report.problem(
+			"%s: %s listens on port %d, but is configured with port %d",
+			path,
+			target.TargetID(),
+			conf.Interface.ListenPort,
+			target.NodePort(),
+		)
+	}
+
+	existingPrivateKey, _, err := keyStore.LoadKeyPair(target.TargetID())
+	if err == nil && existingPrivateKey == privateKey {
+		return publicKey, true
+	} else if err == nil {
+		report.problem(
+			"%s: %s already has a different key pair, rotate or remove it first",
+			path,
+			target.TargetID(),
+		)
+		return "", false
+	} else if !errors.Is(err, ErrKeyNotFound) {
+		report.problem("%s: %s: %s", path, target.TargetID(), err.Error())
+		return "", false
+	}
+
+	err = keyStore.SaveKeyPair(target.TargetID(), privateKey, publicKey)
+	if err != nil {
+		report.problem("%s: error saving key pair of %s: %s", path, target.TargetID(), err.Error())
+		return "", false
+	}
+
+	report.Imported = append(report.Imported, target.TargetID()+" from "+path)
+
+	return publicKey, true
+}
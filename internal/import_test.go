@@ -0,0 +1,148 @@
+package wgg
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseWgQuickConf(t *testing.T) {
+	conf, err := ParseWgQuickConf(
+		"[Interface] # wg0\n" +
+			"address = 10.10.10.1/24, fd00::1/64\n" +
+			"PrivateKey = dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo=\n" +
+			"ListenPort = 55333\n" +
+			"\n" +
+			"[Peer]\n" +
+			"PublicKey = hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo=\n" +
+			"AllowedIPs = 10.10.10.2/32\n" +
+			"AllowedIPs = 192.168.0.0/24\n" +
+			"Endpoint = 192.0.2.2:55333\n",
+	)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	if len(conf.Interface.Addresses) != 2 || conf.Interface.ListenPort != 55333 {
+		t.Errorf("unexpected interface: %+v", conf.Interface)
+	}
+	if len(conf.Peers) != 1 || len(conf.Peers[0].AllowedIPs) != 2 || conf.Peers[0].Endpoint != "192.0.2.2:55333" {
+		t.Errorf("unexpected peers: %+v", conf.Peers)
+	}
+
+	_, err = ParseWgQuickConf("[Peer]\nPublicKey = x\n")
+	if err == nil {
+		t.Errorf("expected error for a config without [Interface], but got none")
+	}
+}
+
+func TestImportWgQuickConfs(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	ip := net.ParseIP("192.0.2.1")
+	nodeList := []WggNode{{ID: 0, PubIp: &ip, Port: 55333}}
+	clientList := []WggClient{{ID: 0}, {ID: 1}}
+
+	// a mesh generated by wgg is the simplest hand-built mesh
+	outDir := t.TempDir()
+	sourceStore := NewFileKeyStore(t.TempDir(), nil)
+	err := GenerateNodeConfigs(subnet, outDir, sourceStore, nodeList, clientList, GenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = GenerateClientConfigs(subnet, outDir, sourceStore, nodeList, clientList, GenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(
+		outDir+"/stray.conf",
+		[]byte("[Interface]\nAddress = 10.10.10.100/24\nPrivateKey = dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo=\n"),
+		0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths, _ := filepath.Glob(outDir + "/*.conf")
+	keyStore := NewFileKeyStore(t.TempDir(), nil)
+
+	report, err := ImportWgQuickConfs(paths, subnet, nodeList, clientList, keyStore)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	if len(report.Imported) != 3 {
+		t.Errorf("expected 3 imported targets, but got %v", report.Imported)
+	}
+	if len(report.Problems) != 1 || !strings.Contains(report.Problems[0], "10.10.10.100/24") {
+		t.Errorf("expected one problem for the stray address, but got %v", report.Problems)
+	}
+
+	for _, target := range Targets(nodeList, clientList) {
+		expected, _, _ := sourceStore.LoadKeyPair(target.TargetID())
+		privateKey, _, err := keyStore.LoadKeyPair(target.TargetID())
+		if err != nil || privateKey != expected {
+			t.Errorf("expected imported key of %s to match, err: %v", target.TargetID(), err)
+		}
+	}
+}
+
+func TestImportWgQuickConfsPresharedKeys(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	ip := net.ParseIP("192.0.2.1")
+	nodeList := []WggNode{{ID: 0, PubIp: &ip, Port: 55333}}
+	clientList := []WggClient{{ID: 0}, {ID: 1}}
+	options := GenOptions{PresharedKeys: true}
+
+	outDir := t.TempDir()
+	sourceStore := NewFileKeyStore(t.TempDir(), nil)
+	err := GenerateNodeConfigs(subnet, outDir, sourceStore, nodeList, clientList, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = GenerateClientConfigs(subnet, outDir, sourceStore, nodeList, clientList, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the config of c1 disagrees with n0 about their preshared key
+	c1Path := outDir + "/client." + WggClient{ID: 1}.FileID() + ".wg.conf"
+	rawData, err := os.ReadFile(c1Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPresharedKey, err := GenerateWireGuardPresharedKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	presharedKey, _ := sourceStore.InitPresharedKey(PresharedKeyID("n0", "c1"))
+	err = os.WriteFile(c1Path, []byte(strings.ReplaceAll(string(rawData), presharedKey, otherPresharedKey)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths, _ := filepath.Glob(outDir + "/*.conf")
+	keyStore := NewFileKeyStore(t.TempDir(), nil)
+
+	report, err := ImportWgQuickConfs(paths, subnet, nodeList, clientList, keyStore)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	if len(report.Problems) != 1 || !strings.Contains(report.Problems[0], "preshared key of c1-n0 differs") {
+		t.Errorf("expected one conflict for the preshared key of c1-n0, but got %v", report.Problems)
+	}
+
+	expected, _ := sourceStore.InitPresharedKey(PresharedKeyID("n0", "c0"))
+	imported, err := os.ReadFile(keyStore.Dir + "/" + PresharedKeyID("n0", "c0") + ".psk")
+	if err != nil || string(imported) != expected {
+		t.Errorf("expected the imported preshared key %s, but got %s, err: %v", expected, imported, err)
+	}
+
+	_, err = os.Stat(keyStore.Dir + "/" + PresharedKeyID("n0", "c1") + ".psk")
+	if !os.IsNotExist(err) {
+		t.Errorf("expected no preshared key for the conflicting pair, but got err: %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package wgg
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/CoreUnit-NET/wgg/lib/netutils"
+)
+
+// InterfaceOptions are the optional wg-quick settings of an [Interface]
+// section. Zero values are not rendered.
+type InterfaceOptions struct {
+	// DNS lists DNS server addresses and search domains.
+	DNS []string `json:"dns,omitempty" yaml:"dns,omitempty" toml:"dns,omitempty"`
+	MTU int      `json:"mtu,omitempty" yaml:"mtu,omitempty" toml:"mtu,omitempty"`
+
+	// Table is "off", "auto" or a routing table number.
+	Table string `json:"table,omitempty" yaml:"table,omitempty" toml:"table,omitempty"`
+	// FwMark is "off" or a 32 bit mark, decimal or hexadecimal with "0x".
+	FwMark string `json:"fwmark,omitempty" yaml:"fwmark,omitempty" toml:"fwmark,omitempty"`
+
+	// PostUp and PostDown list commands, each one is rendered as its own
+	// line.
+	PostUp   []string `json:"post_up,omitempty" yaml:"post_up,omitempty" toml:"post_up,omitempty"`
+	PostDown []string `json:"post_down,omitempty" yaml:"post_down,omitempty" toml:"post_down,omitempty"`
+}
+
+// MinMTU and MaxMTU limit the MTU of an interface.
+const (
+	MinMTU = 576
+	MaxMTU = 65535
+)
+
+// MergeInterfaceOptions returns base with every field that is set in
+// override replaced.
+func MergeInterfaceOptions(base InterfaceOptions, override InterfaceOptions) InterfaceOptions {
+	if len(override.DNS) > 0 {
+		base.DNS = override.DNS
+	}
+	if override.MTU != 0 {
+		base.MTU = override.MTU
+	}
+	if len(override.Table) > 0 {
+		base.Table = override.Table
+	}
+	if len(override.FwMark) > 0 {
+		base.FwMark = override.FwMark
+	}
+	if len(override.PostUp) > 0 {
+		base.PostUp = override.PostUp
+	}
+	if len(override.PostDown) > 0 {
+		base.PostDown = override.PostDown
+	}
+
+	return base
+}
+
+// String renders the set options as lines of an [Interface] section.
+func (options InterfaceOptions) String() string {
+	lines := ""
+	if len(options.DNS) > 0 {
+		lines += "DNS = " + strings.Join(options.DNS, ", ") + "\n"
+	}
+	if options.MTU != 0 {
+		lines += "MTU = " + strconv.Itoa(options.MTU) + "\n"
+	}
+	if len(options.Table) > 0 {
+		lines += "Table = " + options.Table + "\n"
+	}
+	if len(options.FwMark) > 0 {
+		lines += "FwMark = " + options.FwMark + "\n"
+	}
+	for _, command := range options.PostUp {
+		lines += "PostUp = " + command + "\n"
+	}
+	for _, command := range options.PostDown {
+		lines += "PostDown = " + command + "\n"
+	}
+
+	return lines
+}
+
+// validate adds a problem for every invalid option. variable returns the
+// variable name of a field, e.g. "WGG_MTU" for "mtu".
+func (options InterfaceOptions) validate(variable func(field string) string, validation *ValidationError) {
+	for _, dns := range options.DNS {
+		if net.ParseIP(dns) == nil && !netutils.IsHostname(dns) {
+			validation.problem(variable("dns"), "invalid DNS server or search domain '%s'", dns)
+		}
+	}
+
+	if options.MTU != 0 && (options.MTU < MinMTU || options.MTU > MaxMTU) {
+		validation.problem(variable("mtu"), "MTU %d is out of range %d-%d", options.MTU, MinMTU, MaxMTU)
+	}
+
+	if options.Table != "" && options.Table != "off" && options.Table != "auto" {
+		_, err := strconv.ParseUint(options.Table, 10, 32)
+		if err != nil {
+			validation.problem(variable("table"), "invalid table '%s', expected off, auto or a table number", options.Table)
+		}
+	}
+
+	if options.FwMark != "" && options.FwMark != "off" {
+		_, err := strconv.ParseUint(options.FwMark, 0, 32)
+		if err != nil {
+			validation.problem(variable("fwmark"), "invalid fwmark '%s', expected off or a 32 bit number", options.FwMark)
+		}
+	}
+
+	validateCommands(options.PostUp, variable("post_up"), validation)
+	validateCommands(options.PostDown, variable("post_down"), validation)
+}
+
+// validateCommands adds a problem for every command that is empty or spans
+// several lines.
+func validateCommands(commands []string, variable string, validation *ValidationError) {
+	for _, command := range commands {
+		if len(strings.TrimSpace(command)) <= 0 || strings.ContainsAny(command, "\r\n") {
+			validation.problem(variable, "invalid command %q, it must be a single non-empty line", command)
+		}
+	}
+}
+
+// envInterfaceOptions reads the InterfaceOptions from the env vars with the
+// given prefix, e.g. WGG_NODE_MTU for "WGG_NODE_".
+func envInterfaceOptions(prefix string, validation *ValidationError) InterfaceOptions {
+	variable := func(field string) string {
+		return prefix + strings.ToUpper(field)
+	}
+
+	options := InterfaceOptions{
+		DNS:    SplitList(os.Getenv(variable("dns"))),
+		Table:  os.Getenv(variable("table")),
+		FwMark: os.Getenv(variable("fwmark")),
+	}
+
+	mtuString := os.Getenv(variable("mtu"))
+	if len(mtuString) > 0 {
+		mtu, err := strconv.Atoi(mtuString)
+		if err != nil {
+			validation.problem(variable("mtu"), "value '%s' is not an int", mtuString)
+		}
+		options.MTU = mtu
+	}
+
+	if command := os.Getenv(variable("post_up")); len(command) > 0 {
+		options.PostUp = []string{command}
+	}
+	if command := os.Getenv(variable("post_down")); len(command) > 0 {
+		options.PostDown = []string{command}
+	}
+
+	options.validate(variable, validation)
+
+	return options
+}
+
+// inventoryInterfaceOptions validates the InterfaceOptions of an inventory
+// field, options may be nil.
+func inventoryInterfaceOptions(
+	options *InterfaceOptions,
+	variable string,
+	validation *ValidationError,
+) InterfaceOptions {
+	if options == nil {
+		return InterfaceOptions{}
+	}
+
+	options.validate(func(field string) string { return variable + "." + field }, validation)
+
+	return *options
+}
+
+// InterfaceSettings holds the InterfaceOptions of the nodes, the clients and
+// of the targets with own options.
+type InterfaceSettings struct {
+	Nodes   InterfaceOptions
+	Clients InterfaceOptions
+	Targets map[string]InterfaceOptions
+}
+
+// Of returns the InterfaceOptions of the target.
+func (settings InterfaceSettings) Of(target WggTarget) InterfaceOptions {
+	options, ok := settings.Targets[target.TargetID()]
+	if ok {
+		return options
+	} else if target.IsNode() {
+		return settings.Nodes
+	}
+
+	return settings.Clients
+}
+
+// InitInterfaceSettings layers the InterfaceOptions of the inventory and the
+// env vars, each field of a more specific layer replaces the one before:
+//
+//   - global: WGG_DNS, WGG_MTU, WGG_TABLE, WGG_FWMARK, WGG_POST_UP and
+//     WGG_POST_DOWN, then the inventory interface
+//   - per role: the same env vars with WGG_NODE_ or WGG_CLIENT_ prefix, e.g.
+//     WGG_CLIENT_DNS, then the inventory node_interface or client_interface
+//   - per target: the interface of the inventory node or client entry
+//
+// WGG_DNS and its role variants are comma separated lists. All problems are
+// returned as *ValidationError.
+func InitInterfaceSettings(inventory *Inventory) (InterfaceSettings, error) {
+	validation := &ValidationError{}
+	if inventory == nil {
+		inventory = &Inventory{}
+	}
+
+	global := MergeInterfaceOptions(
+		envInterfaceOptions("WGG_", validation),
+		inventoryInterfaceOptions(inventory.Interface, "inventory interface", validation),
+	)
+
+	settings := InterfaceSettings{
+		Nodes: MergeInterfaceOptions(global, MergeInterfaceOptions(
+			envInterfaceOptions("WGG_NODE_", validation),
+			inventoryInterfaceOptions(inventory.NodeInterface, "inventory node_interface", validation),
+		)),
+		Clients: MergeInterfaceOptions(global, MergeInterfaceOptions(
+			envInterfaceOptions("WGG_CLIENT_", validation),
+			inventoryInterfaceOptions(inventory.ClientInterface, "inventory client_interface", validation),
+		)),
+		Targets: map[string]InterfaceOptions{},
+	}
+
+	for i, entry := range inventory.Nodes {
+		if entry.Interface != nil && !entry.Removed {
+			settings.Targets[WggNode{ID: i}.TargetID()] = MergeInterfaceOptions(
+				settings.Nodes,
+				inventoryInterfaceOptions(entry.Interface, fmt.Sprintf("inventory nodes[%d].interface", i), validation),
+			)
+		}
+	}
+	for i, entry := range inventory.Clients {
+		if entry.Interface != nil && !entry.Removed {
+			client := WggClient{ID: i, Name: entry.Name}
+			settings.Targets[client.TargetID()] = MergeInterfaceOptions(
+				settings.Clients,
+				inventoryInterfaceOptions(entry.Interface, fmt.Sprintf("inventory clients[%d].interface", i), validation),
+			)
+		}
+	}
+
+	if len(validation.Problems) > 0 {
+		return settings, validation
+	}
+
+	return settings, nil
+}
@@ -0,0 +1,136 @@
+package wgg
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInitInterfaceSettings(t *testing.T) {
+	t.Setenv("WGG_DNS", "10.10.10.1, example.com")
+	t.Setenv("WGG_MTU", "1420")
+	t.Setenv("WGG_NODE_MTU", "")
+	t.Setenv("WGG_CLIENT_MTU", "1380")
+	t.Setenv("WGG_NODE_POST_UP", "sysctl -w net.ipv4.ip_forward=1")
+
+	inventory := &Inventory{
+		NodeInterface: &InterfaceOptions{Table: "off"},
+		Nodes: []InventoryNode{
+			{Endpoint: "192.0.2.1:55333"},
+			{Endpoint: "192.0.2.2:55333", Interface: &InterfaceOptions{MTU: 1300, FwMark: "0xca6c"}},
+		},
+		Clients: []InventoryClient{
+			{Name: "laptop", Interface: &InterfaceOptions{DNS: []string{"1.1.1.1"}}},
+		},
+	}
+
+	settings, err := InitInterfaceSettings(inventory)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	expected := InterfaceOptions{
+		DNS:    []string{"10.10.10.1", "example.com"},
+		MTU:    1420,
+		Table:  "off",
+		PostUp: []string{"sysctl -w net.ipv4.ip_forward=1"},
+	}
+	if options := settings.Of(WggNode{ID: 0}); !reflect.DeepEqual(options, expected) {
+		t.Errorf("expected node options %+v, but got %+v", expected, options)
+	}
+
+	expected.MTU, expected.FwMark = 1300, "0xca6c"
+	if options := settings.Of(WggNode{ID: 1}); !reflect.DeepEqual(options, expected) {
+		t.Errorf("expected target options %+v, but got %+v", expected, options)
+	}
+
+	expected = InterfaceOptions{DNS: []string{"1.1.1.1"}, MTU: 1380}
+	if options := settings.Of(WggClient{ID: 0, Name: "laptop"}); !reflect.DeepEqual(options, expected) {
+		t.Errorf("expected client options %+v, but got %+v", expected, options)
+	}
+}
+
+func TestInitInterfaceSettingsProblems(t *testing.T) {
+	t.Setenv("WGG_DNS", "bad_name")
+	t.Setenv("WGG_MTU", "big")
+	t.Setenv("WGG_CLIENT_MTU", "100")
+	t.Setenv("WGG_NODE_TABLE", "main")
+
+	inventory := &Inventory{
+		Interface: &InterfaceOptions{FwMark: "0x1ffffffff", PostDown: []string{"echo\nrm -rf /"}},
+	}
+
+	_, err := InitInterfaceSettings(inventory)
+	var validation *ValidationError
+	if !errors.As(err, &validation) {
+		t.Fatalf("expected a ValidationError, but got %v", err)
+	}
+
+	variables := []string{}
+	for _, problem := range validation.Problems {
+		variables = append(variables, problem.Variable)
+	}
+	expected := []string{
+		"WGG_MTU",
+		"WGG_DNS",
+		"inventory interface.fwmark",
+		"inventory interface.post_down",
+		"WGG_NODE_TABLE",
+		"WGG_CLIENT_MTU",
+	}
+	if !reflect.DeepEqual(variables, expected) {
+		t.Errorf("expected problems of %v, but got %v", expected, variables)
+	}
+}
+
+func TestGenWgClientConfPartInterfaceOptions(t *testing.T) {
+	keyStore := NewFileKeyStore(t.TempDir(), nil)
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	node := WggNode{ID: 0, Host: "192.0.2.1", Port: 55333}
+	client := NewWggClient(0)
+	options := GenOptions{
+		NodeSideKeys: true,
+		NodeKeyPath:  DefaultNodeKeyPath,
+		Interfaces: InterfaceSettings{
+			Nodes: InterfaceOptions{MTU: 1420, PostUp: []string{"iptables -A FORWARD -i %i -j ACCEPT"}},
+			Clients: InterfaceOptions{
+				DNS:      []string{"10.10.10.1", "mesh.internal"},
+				Table:    "1234",
+				FwMark:   "off",
+				PostDown: []string{"resolvectl revert %i"},
+			},
+		},
+	}
+
+	conf, err := GenWgClientConfPart(node, keyStore, subnet, node, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	expected := "PostUp = wg set %i private-key " + ShellQuote(DefaultNodeKeyPath) + "\n" +
+		"MTU = 1420\n" +
+		"PostUp = iptables -A FORWARD -i %i -j ACCEPT\n"
+	if !strings.HasSuffix(conf, expected) {
+		t.Errorf("expected the node options after the key PostUp, but got:\n%s", conf)
+	}
+
+	conf, err = GenWgClientConfPart(client, keyStore, subnet, client, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	expected = "DNS = 10.10.10.1, mesh.internal\n" +
+		"Table = 1234\n" +
+		"FwMark = off\n" +
+		"PostDown = resolvectl revert %i\n"
+	if !strings.HasSuffix(conf, expected) {
+		t.Errorf("expected the client options, but got:\n%s", conf)
+	}
+
+	conf, err = GenWgClientConfPart(client, keyStore, subnet, node, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	} else if strings.Contains(conf, "DNS") {
+		t.Errorf("expected no interface options in a peer section, but got:\n%s", conf)
+	}
+}
@@ -0,0 +1,335 @@
+package wgg
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/CoreUnit-NET/wgg/lib/stringfs"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Inventory is the declarative description of a mesh. Every field that is
+// left empty falls back to its env var.
+type Inventory struct {
+	Subnet string `json:"subnet,omitempty" yaml:"subnet,omitempty" toml:"subnet,omitempty"`
+
+	// Subnet6 is the IPv6 overlay prefix or "auto", as WGG_SUBNET6.
+	Subnet6 string `json:"subnet6,omitempty" yaml:"subnet6,omitempty" toml:"subnet6,omitempty"`
+	OutDir  string `json:"out_dir,omitempty" yaml:"out_dir,omitempty" toml:"out_dir,omitempty"`
+
+	// NodePrefix, ClientPrefix, Reserved and Allocation configure the
+	// AllocationPolicy, as the WGG_NODE_PREFIX, WGG_CLIENT_PREFIX,
+	// WGG_RESERVED and WGG_ALLOCATION env vars.
+	NodePrefix   string   `json:"node_prefix,omitempty" yaml:"node_prefix,omitempty" toml:"node_prefix,omitempty"`
+	ClientPrefix string   `json:"client_prefix,omitempty" yaml:"client_prefix,omitempty" toml:"client_prefix,omitempty"`
+	Reserved     []string `json:"reserved,omitempty" yaml:"reserved,omitempty" toml:"reserved,omitempty"`
+	Allocation   string   `json:"allocation,omitempty" yaml:"allocation,omitempty" toml:"allocation,omitempty"`
+
+	// Interface, NodeInterface and ClientInterface set the InterfaceOptions
+	// of all targets, of the nodes and of the clients, see
+	// InitInterfaceSettings.
+	Interface       *InterfaceOptions `json:"interface,omitempty" yaml:"interface,omitempty" toml:"interface,omitempty"`
+	NodeInterface   *InterfaceOptions `json:"node_interface,omitempty" yaml:"node_interface,omitempty" toml:"node_interface,omitempty"`
+	ClientInterface *InterfaceOptions `json:"client_interface,omitempty" yaml:"client_interface,omitempty" toml:"client_interface,omitempty"`
+
+	// PersistentKeepalive is the keepalive interval of the targets behind
+	// NAT, as WGG_PERSISTENT_KEEPALIVE.
+	PersistentKeepalive *int `json:"persistent_keepalive,omitempty" yaml:"persistent_keepalive,omitempty" toml:"persistent_keepalive,omitempty"`
+
+	// HubGroups are the groups of clients that reach each other through a
+	// hub node, as WGG_HUB_GROUPS.
+	HubGroups []InventoryHubGroup `json:"hub_groups,omitempty" yaml:"hub_groups,omitempty" toml:"hub_groups,omitempty"`
+
+	Nodes []InventoryNode `json:"nodes,omitempty" yaml:"nodes,omitempty" toml:"nodes,omitempty"`
+
+	// Clients declares every client on its own, ClientCount only declares
+	// the number of clients. Only one of both may be set.
+	Clients     []InventoryClient `json:"clients,omitempty" yaml:"clients,omitempty" toml:"clients,omitempty"`
+	ClientCount *int              `json:"client_count,omitempty" yaml:"client_count,omitempty" toml:"client_count,omitempty"`
+
+	// Path is the path of the inventory file and Dir its directory,
+	// relative paths in the inventory are resolved against it.
+	Path string `json:"-" yaml:"-" toml:"-"`
+	Dir  string `json:"-" yaml:"-" toml:"-"`
+}
+
+// InventoryNode is a node entry of an Inventory. The node IDs follow the
+// order of the entries.
+//
+// A removed node keeps its entry with only Removed set, so the nodes after it
+// keep their IDs.
+type InventoryNode struct {
+	Removed bool `json:"removed,omitempty" yaml:"removed,omitempty" toml:"removed,omitempty"`
+
+	// Endpoint is "<host>:<port>", or an IPv4 and an IPv6 endpoint separated
+	// by a comma, as in the WGG_NODE<n> env vars.
+	Endpoint string `json:"endpoint,omitempty" yaml:"endpoint,omitempty" toml:"endpoint,omitempty"`
+
+	// Address is the static WireGuard address of the node, if any.
+	Address string `json:"address,omitempty" yaml:"address,omitempty" toml:"address,omitempty"`
+
+	// Interface overrides the InterfaceOptions of the node.
+	Interface *InterfaceOptions `json:"interface,omitempty" yaml:"interface,omitempty" toml:"interface,omitempty"`
+
+	// Exit marks the node as exit node, see InitExitSettings.
+	Exit bool `json:"exit,omitempty" yaml:"exit,omitempty" toml:"exit,omitempty"`
+
+	// Routes are the prefixes of the site behind the node, see
+	// InitRouteSettings.
+	Routes []string `json:"routes,omitempty" yaml:"routes,omitempty" toml:"routes,omitempty"`
+
+	// BehindNAT marks the node as behind NAT, PersistentKeepalive overrides
+	// its keepalive interval, see InitKeepaliveSettings.
+	BehindNAT           bool `json:"behind_nat,omitempty" yaml:"behind_nat,omitempty" toml:"behind_nat,omitempty"`
+	PersistentKeepalive *int `json:"persistent_keepalive,omitempty" yaml:"persistent_keepalive,omitempty" toml:"persistent_keepalive,omitempty"`
+
+	Meta map[string]string `json:"meta,omitempty" yaml:"meta,omitempty" toml:"meta,omitempty"`
+}
+
+// InventoryHubGroup is a hub group entry of an Inventory.
+type InventoryHubGroup struct {
+	Name string `json:"name,omitempty" yaml:"name,omitempty" toml:"name,omitempty"`
+
+	// Hubs are the node IDs of the hub nodes, the first existing one is the
+	// hub of the group.
+	Hubs []string `json:"hubs,omitempty" yaml:"hubs,omitempty" toml:"hubs,omitempty"`
+
+	// Prefixes are the parts of the overlay that are routed through the hub,
+	// by default the whole overlay.
+	Prefixes []string `json:"prefixes,omitempty" yaml:"prefixes,omitempty" toml:"prefixes,omitempty"`
+}
+
+// InventoryClient is a client entry of an Inventory. The client IDs, and so
+// the client addresses, follow the order of the entries.
+//
+// A removed client keeps its entry with only Removed set, so the clients
+// after it keep their addresses.
+type InventoryClient struct {
+	Removed bool `json:"removed,omitempty" yaml:"removed,omitempty" toml:"removed,omitempty"`
+
+	Name        string   `json:"name,omitempty" yaml:"name,omitempty" toml:"name,omitempty"`
+	Owner       string   `json:"owner,omitempty" yaml:"owner,omitempty" toml:"owner,omitempty"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty" toml:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty" yaml:"tags,omitempty" toml:"tags,omitempty"`
+
+	// EndpointPreference is "v4", "v6" or "v6-first", see EndpointPreference.
+	EndpointPreference string `json:"endpoint_preference,omitempty" yaml:"endpoint_preference,omitempty" toml:"endpoint_preference,omitempty"`
+
+	// Address is the static WireGuard address of the client, if any.
+	Address string `json:"address,omitempty" yaml:"address,omitempty" toml:"address,omitempty"`
+
+	// Interface overrides the InterfaceOptions of the client.
+	Interface *InterfaceOptions `json:"interface,omitempty" yaml:"interface,omitempty" toml:"interface,omitempty"`
+
+	// HubGroup is the name of the hub group of the client, see
+	// InitHubSettings.
+	HubGroup string `json:"hub_group,omitempty" yaml:"hub_group,omitempty" toml:"hub_group,omitempty"`
+
+	// Exits are the node IDs of the exit nodes of the client, the first
+	// existing one is used, see InitExitSettings.
+	Exits []string `json:"exits,omitempty" yaml:"exits,omitempty" toml:"exits,omitempty"`
+
+	// BehindNAT marks the client as behind NAT, PersistentKeepalive overrides
+	// its keepalive interval, see InitKeepaliveSettings.
+	BehindNAT           bool `json:"behind_nat,omitempty" yaml:"behind_nat,omitempty" toml:"behind_nat,omitempty"`
+	PersistentKeepalive *int `json:"persistent_keepalive,omitempty" yaml:"persistent_keepalive,omitempty" toml:"persistent_keepalive,omitempty"`
+
+	Meta map[string]string `json:"meta,omitempty" yaml:"meta,omitempty" toml:"meta,omitempty"`
+}
+
+// ParseInventory parses the content of an inventory file in the given
+// format, which is "yaml", "toml" or "json". Unknown keys are rejected, so
+// typos do not silently fall back to the env vars.
+func ParseInventory(content []byte, format string) (*Inventory, error) {
+	inventory := &Inventory{}
+
+	var err error
+	switch format {
+	case "yaml":
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		err = decoder.Decode(inventory)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	case "toml":
+		decoder := toml.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(inventory)
+	case "json":
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(inventory)
+	default:
+		return nil, fmt.Errorf("unknown inventory format '%s'", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if inventory.ClientCount != nil && len(inventory.Clients) > 0 {
+		return nil, errors.New("the inventory must not set both clients and client_count")
+	} else if inventory.ClientCount != nil && *inventory.ClientCount < 0 {
+		return nil, errors.New("the inventory client_count must not be negative")
+	}
+
+	return inventory, nil
+}
+
+// InventoryFormat returns the format of an inventory file by its extension.
+func InventoryFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml", nil
+	case ".toml":
+		return "toml", nil
+	case ".json":
+		return "json", nil
+	default:
+		return "", errors.New(
+			"unknown inventory file extension of '" + path +
+				"', expected .yaml, .yml, .toml or .json",
+		)
+	}
+}
+
+// LoadInventory reads and parses the inventory file at path.
+func LoadInventory(path string) (*Inventory, error) {
+	format, err := InventoryFormat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rawData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("Error reading inventory '" + path + "': " + err.Error())
+	}
+
+	inventory, err := ParseInventory(rawData, format)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing inventory '%s': %w", path, err)
+	}
+	inventory.Path = path
+	inventory.Dir = filepath.Dir(path)
+
+	return inventory, nil
+}
+
+// MarshalInventory encodes the inventory in the given format, which is
+// "yaml", "toml" or "json".
+func MarshalInventory(inventory *Inventory, format string) ([]byte, error) {
+	switch format {
+	case "yaml":
+		buffer := bytes.Buffer{}
+		encoder := yaml.NewEncoder(&buffer)
+		encoder.SetIndent(2)
+		err := encoder.Encode(inventory)
+		if err != nil {
+			return nil, err
+		}
+		err = encoder.Close()
+
+		return buffer.Bytes(), err
+	case "toml":
+		return toml.Marshal(inventory)
+	case "json":
+		rawData, err := json.MarshalIndent(inventory, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		return append(rawData, '\n'), nil
+	default:
+		return nil, fmt.Errorf("unknown inventory format '%s'", format)
+	}
+}
+
+// SaveInventory writes the inventory back to its Path in the format of the
+// file extension. Comments and formatting of the file are not preserved.
+func SaveInventory(inventory *Inventory) error {
+	format, err := InventoryFormat(inventory.Path)
+	if err != nil {
+		return err
+	}
+
+	rawData, err := MarshalInventory(inventory, format)
+	if err != nil {
+		return fmt.Errorf("error encoding inventory: %w", err)
+	}
+
+	err = stringfs.SafeWriteFileBytes(inventory.Path, rawData, 0644)
+	if err != nil {
+		return errors.New("Error writing inventory '" + inventory.Path + "': " + err.Error())
+	}
+
+	return nil
+}
+
+// AddInventoryClient appends the client to the inventory. An inventory that
+// only declares a client_count is converted to client entries first, unless
+// the new client is as anonymous as the existing ones.
+func AddInventoryClient(inventory *Inventory, client InventoryClient) {
+	if inventory.ClientCount != nil {
+		isAnonymous := reflect.DeepEqual(client, InventoryClient{})
+		if isAnonymous {
+			*inventory.ClientCount++
+			return
+		}
+
+		inventory.Clients = make([]InventoryClient, *inventory.ClientCount)
+		inventory.ClientCount = nil
+	}
+
+	inventory.Clients = append(inventory.Clients, client)
+}
+
+// RemoveInventoryClient removes the client with the given ID from the
+// inventory.
+//
+// The client is replaced by a removed entry, also if it is the last one, so
+// its ID and address are never issued to another client and the following
+// clients keep theirs.
+func RemoveInventoryClient(inventory *Inventory, id int) error {
+	if inventory.ClientCount != nil {
+		if id < 0 || id >= *inventory.ClientCount {
+			return fmt.Errorf("the inventory has no client #%d", id)
+		}
+
+		inventory.Clients = make([]InventoryClient, *inventory.ClientCount)
+		inventory.ClientCount = nil
+	}
+
+	if id < 0 || id >= len(inventory.Clients) || inventory.Clients[id].Removed {
+		return fmt.Errorf("the inventory has no client #%d", id)
+	}
+
+	inventory.Clients[id] = InventoryClient{Removed: true}
+
+	return nil
+}
+
+// InitInventory loads the inventory file at path, or at WGG_INVENTORY if
+// path is empty. It returns nil if neither is set.
+func InitInventory(path string) (*Inventory, error) {
+	if len(path) <= 0 {
+		path = os.Getenv("WGG_INVENTORY")
+	}
+	if len(path) <= 0 {
+		return nil, nil
+	}
+
+	err := stringfs.ParsePath(&path)
+	if err != nil {
+		return nil, err
+	}
+
+	return LoadInventory(path)
+}
@@ -0,0 +1,256 @@
+package wgg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseInventory(t *testing.T) {
+	tests := []struct {
+		format  string
+		content string
+	}{
+		{
+			"yaml",
+			"subnet: 10.10.10.0/24\n" +
+				"out_dir: config\n" +
+				"nodes:\n" +
+				"  - endpoint: 192.0.2.1:55333\n" +
+				"    meta:\n" +
+				"      site: fra\n" +
+				"  - endpoint: 192.0.2.2:55334\n" +
+				"clients:\n" +
+				"  - meta:\n" +
+				"      owner: alice\n" +
+				"  - {}\n",
+		},
+		{
+			"toml",
+			"subnet = \"10.10.10.0/24\"\n" +
+				"out_dir = \"config\"\n" +
+				"[[nodes]]\n" +
+				"endpoint = \"192.0.2.1:55333\"\n" +
+				"meta = { site = \"fra\" }\n" +
+				"[[nodes]]\n" +
+				"endpoint = \"192.0.2.2:55334\"\n" +
+				"[[clients]]\n" +
+				"meta = { owner = \"alice\" }\n" +
+				"[[clients]]\n",
+		},
+		{
+			"json",
+			`{"subnet": "10.10.10.0/24", "out_dir": "config",` +
+				` "nodes": [{"endpoint": "192.0.2.1:55333", "meta": {"site": "fra"}},` +
+				` {"endpoint": "192.0.2.2:55334"}],` +
+				` "clients": [{"meta": {"owner": "alice"}}, {}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			inventory, err := ParseInventory([]byte(test.content), test.format)
+			if err != nil {
+				t.Fatalf("did not expect error, but got %v", err)
+			}
+
+			subnet, err := InitSubnet(inventory)
+			if err != nil || subnet.String() != "10.10.10.0/24" {
+				t.Errorf("expected subnet 10.10.10.0/24, but got %v, %v", subnet, err)
+			}
+
+			nodeList, err := InitNodeList(inventory)
+			if err != nil {
+				t.Fatalf("did not expect error, but got %v", err)
+			}
+			if len(nodeList) != 2 || nodeList[1].ID != 1 || nodeList[1].Port != 55334 {
+				t.Errorf("unexpected nodes: %+v", nodeList)
+			}
+			if nodeList[0].Meta["site"] != "fra" {
+				t.Errorf("expected node meta site fra, but got %v", nodeList[0].Meta)
+			}
+
+			clientList, err := InitClientList(inventory)
+			if err != nil {
+				t.Fatalf("did not expect error, but got %v", err)
+			}
+			if len(clientList) != 2 || clientList[0].Meta["owner"] != "alice" {
+				t.Errorf("unexpected clients: %+v", clientList)
+			}
+		})
+	}
+}
+
+func TestParseInventoryErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		content string
+	}{
+		{"unknown yaml key", "yaml", "subnett: 10.10.10.0/24\n"},
+		{"unknown toml key", "toml", "subnett = \"10.10.10.0/24\"\n"},
+		{"unknown json key", "json", `{"subnett": "10.10.10.0/24"}`},
+		{"clients and client_count", "yaml", "client_count: 2\nclients:\n  - {}\n"},
+		{"negative client_count", "json", `{"client_count": -1}`},
+		{"unknown format", "ini", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseInventory([]byte(test.content), test.format)
+			if err == nil {
+				t.Errorf("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestInventoryEnvFallback(t *testing.T) {
+	t.Setenv("WGG_SUBNET", "10.20.0.0/16")
+	t.Setenv("WGG_NODE1", "192.0.2.1:55333")
+	t.Setenv("WGG_NODE2", "")
+	t.Setenv("WGG_CLIENT_COUNT", "3")
+
+	dir := t.TempDir()
+	inventoryPath := filepath.Join(dir, "mesh.yml")
+	err := os.WriteFile(inventoryPath, []byte("out_dir: out\nclient_count: 1\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("WGG_INVENTORY", inventoryPath)
+	inventory, err := InitInventory("")
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	subnet, err := InitSubnet(inventory)
+	if err != nil || subnet.String() != "10.20.0.0/16" {
+		t.Errorf("expected subnet from WGG_SUBNET, but got %v, %v", subnet, err)
+	}
+
+	nodeList, err := InitNodeList(inventory)
+	if err != nil || len(nodeList) != 1 {
+		t.Errorf("expected 1 node from WGG_NODE1, but got %v, %v", nodeList, err)
+	}
+
+	clientList, err := InitClientList(inventory)
+	if err != nil || len(clientList) != 1 {
+		t.Errorf("expected 1 client from client_count, but got %v, %v", clientList, err)
+	}
+
+	// relative out dirs are resolved against the inventory file
+	outDir, keyDir, err := InitOutDir(inventory)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	if outDir != dir+"/out" {
+		t.Errorf("expected out dir %s, but got %s", dir+"/out", outDir)
+	}
+	if _, err := os.Stat(keyDir); err != nil {
+		t.Errorf("expected key dir to be created, but got %v", err)
+	}
+
+	t.Setenv("WGG_INVENTORY", "")
+	noInventory, err := InitInventory("")
+	if err != nil || noInventory != nil {
+		t.Errorf("expected no inventory, but got %v, %v", noInventory, err)
+	}
+}
+
+func TestAddRemoveInventoryClient(t *testing.T) {
+	clientCount := 2
+	inventory := &Inventory{ClientCount: &clientCount}
+
+	AddInventoryClient(inventory, InventoryClient{})
+	if *inventory.ClientCount != 3 || len(inventory.Clients) != 0 {
+		t.Errorf("expected an anonymous client to increment client_count, but got %+v", inventory)
+	}
+
+	AddInventoryClient(inventory, InventoryClient{Name: "alice"})
+	if inventory.ClientCount != nil || len(inventory.Clients) != 4 || inventory.Clients[3].Name != "alice" {
+		t.Errorf("expected a named client to convert client_count to entries, but got %+v", inventory)
+	}
+
+	err := RemoveInventoryClient(inventory, 1)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	clientList, err := InitClientList(inventory)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	if len(clientList) != 3 || clientList[1].ID != 2 || clientList[2].TargetID() != "alice" {
+		t.Errorf("expected the following clients to keep their IDs, but got %+v", clientList)
+	}
+
+	err = RemoveInventoryClient(inventory, 1)
+	if err == nil {
+		t.Errorf("expected error for an already removed client, but got none")
+	}
+
+	for _, id := range []int{3, 2, 0} {
+		err = RemoveInventoryClient(inventory, id)
+		if err != nil {
+			t.Fatalf("did not expect error for client #%d, but got %v", id, err)
+		}
+	}
+	if len(inventory.Clients) != 4 || inventory.ClientCount != nil {
+		t.Errorf("expected the removed clients to stay as entries, but got %+v", inventory)
+	}
+
+	clientList, err = InitClientList(inventory)
+	if err != nil || len(clientList) != 0 {
+		t.Errorf("expected no clients, but got %+v, err: %v", clientList, err)
+	}
+
+	AddInventoryClient(inventory, InventoryClient{})
+	clientList, err = InitClientList(inventory)
+	if err != nil || len(clientList) != 1 || clientList[0].ID != 4 {
+		t.Errorf("expected a new client to get an ID never issued before, but got %+v, err: %v", clientList, err)
+	}
+
+	clientCount = 2
+	inventory = &Inventory{ClientCount: &clientCount}
+	err = RemoveInventoryClient(inventory, 1)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	AddInventoryClient(inventory, InventoryClient{})
+	if len(inventory.Clients) != 3 || !inventory.Clients[1].Removed {
+		t.Errorf("expected the last client of client_count to stay removed, but got %+v", inventory)
+	}
+}
+
+func TestSaveInventory(t *testing.T) {
+	for _, fileName := range []string{"mesh.yaml", "mesh.toml", "mesh.json"} {
+		t.Run(fileName, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), fileName)
+			inventory := &Inventory{
+				Path:   path,
+				Subnet: "10.10.10.0/24",
+				Nodes:  []InventoryNode{{Endpoint: "192.0.2.1:55333"}},
+				Clients: []InventoryClient{
+					{Name: "alice", Tags: []string{"laptop"}},
+					{Removed: true},
+					{},
+				},
+			}
+
+			err := SaveInventory(inventory)
+			if err != nil {
+				t.Fatalf("did not expect error, but got %v", err)
+			}
+
+			loaded, err := LoadInventory(path)
+			if err != nil {
+				t.Fatalf("did not expect error, but got %v", err)
+			}
+			if loaded.Subnet != inventory.Subnet || len(loaded.Nodes) != 1 || len(loaded.Clients) != 3 ||
+				loaded.Clients[0].Tags[0] != "laptop" || !loaded.Clients[1].Removed {
+				t.Errorf("expected the saved inventory, but got %+v", loaded)
+			}
+		})
+	}
+}
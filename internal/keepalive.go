@@ -0,0 +1,186 @@
+package wgg
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultPersistentKeepalive is the PersistentKeepalive interval in seconds
+// of the targets behind NAT if WGG_PERSISTENT_KEEPALIVE is not set.
+const DefaultPersistentKeepalive = 25
+
+// MaxPersistentKeepalive is the highest PersistentKeepalive interval
+// WireGuard accepts.
+const MaxPersistentKeepalive = 65535
+
+// KeepaliveSettings holds the PersistentKeepalive intervals of the targets.
+// A target keeps its NAT mapping open with the interval in every node peer
+// section of its own config.
+type KeepaliveSettings struct {
+	// Default applies to the targets behind NAT without an own interval.
+	Default int
+
+	// BehindNAT marks targets by ID, BehindNATNodes and BehindNATClients
+	// mark all targets of a role.
+	BehindNAT        map[string]bool
+	BehindNATNodes   bool
+	BehindNATClients bool
+
+	// Intervals holds the own intervals by target ID, 0 disables the
+	// keepalive of a target behind NAT.
+	Intervals map[string]int
+}
+
+// Of returns the PersistentKeepalive interval of the target, 0 if it has
+// none.
+func (settings KeepaliveSettings) Of(target WggTarget) int {
+	interval, ok := settings.Intervals[target.TargetID()]
+	if ok {
+		return interval
+	}
+
+	if settings.BehindNAT[target.TargetID()] ||
+		(target.IsNode() && settings.BehindNATNodes) ||
+		(!target.IsNode() && settings.BehindNATClients) {
+		return settings.Default
+	}
+
+	return 0
+}
+
+// parseKeepalive parses an interval in seconds and adds a problem if it is
+// invalid.
+func parseKeepalive(value string, variable string, validation *ValidationError) (int, bool) {
+	interval, err := strconv.Atoi(value)
+	if err != nil {
+		validation.problem(variable, "value '%s' is not an int", value)
+		return 0, false
+	}
+
+	return interval, checkKeepalive(interval, variable, validation)
+}
+
+// checkKeepalive adds a problem if the interval is out of range.
+func checkKeepalive(interval int, variable string, validation *ValidationError) bool {
+	if interval < 0 || interval > MaxPersistentKeepalive {
+		validation.problem(variable, "keepalive %d is out of range 0-%d", interval, MaxPersistentKeepalive)
+		return false
+	}
+
+	return true
+}
+
+// InitKeepaliveSettings reads the KeepaliveSettings from the inventory and
+// the env vars.
+//
+// WGG_PERSISTENT_KEEPALIVE, or the inventory persistent_keepalive, is the
+// interval of the targets behind NAT (default DefaultPersistentKeepalive).
+// WGG_BEHIND_NAT is a comma separated list of target IDs behind NAT, "nodes"
+// and "clients" mark all targets of a role, as does behind_nat of the
+// inventory entries. WGG_PERSISTENT_KEEPALIVES is a comma separated list of
+// "<target-id>=<seconds>", the persistent_keepalive of the inventory entries
+// takes precedence over it. nodeList and clientList hold the existing nodes
+// and clients, other target IDs are reported. All problems are returned as
+// *ValidationError.
+func InitKeepaliveSettings(
+	inventory *Inventory,
+	nodeList []WggNode,
+	clientList []WggClient,
+) (KeepaliveSettings, error) {
+	validation := &ValidationError{}
+	settings := KeepaliveSettings{
+		Default:   DefaultPersistentKeepalive,
+		BehindNAT: map[string]bool{},
+		Intervals: map[string]int{},
+	}
+	if inventory == nil {
+		inventory = &Inventory{}
+	}
+
+	targets := map[string]bool{}
+	for _, node := range nodeList {
+		targets[node.TargetID()] = true
+	}
+	for _, client := range clientList {
+		targets[client.TargetID()] = true
+	}
+
+	if inventory.PersistentKeepalive != nil {
+		if checkKeepalive(*inventory.PersistentKeepalive, "inventory persistent_keepalive", validation) {
+			settings.Default = *inventory.PersistentKeepalive
+		}
+	} else if value := os.Getenv("WGG_PERSISTENT_KEEPALIVE"); len(value) > 0 {
+		interval, ok := parseKeepalive(value, "WGG_PERSISTENT_KEEPALIVE", validation)
+		if ok {
+			settings.Default = interval
+		}
+	}
+
+	for _, targetID := range SplitList(os.Getenv("WGG_BEHIND_NAT")) {
+		switch targetID {
+		case "nodes":
+			settings.BehindNATNodes = true
+		case "clients":
+			settings.BehindNATClients = true
+		default:
+			if !targets[targetID] {
+				validation.problem("WGG_BEHIND_NAT", "unknown target '%s'", targetID)
+			}
+			settings.BehindNAT[targetID] = true
+		}
+	}
+
+	addTarget := func(targetID string, behindNAT bool, interval *int, variable string) {
+		if behindNAT {
+			settings.BehindNAT[targetID] = true
+		}
+		if interval != nil && checkKeepalive(*interval, variable, validation) {
+			settings.Intervals[targetID] = *interval
+		}
+	}
+
+	for i, entry := range inventory.Nodes {
+		if !entry.Removed {
+			addTarget(
+				WggNode{ID: i}.TargetID(),
+				entry.BehindNAT,
+				entry.PersistentKeepalive,
+				fmt.Sprintf("inventory nodes[%d].persistent_keepalive", i),
+			)
+		}
+	}
+	for i, entry := range inventory.Clients {
+		if !entry.Removed {
+			client := WggClient{ID: i, Name: entry.Name}
+			addTarget(
+				client.TargetID(),
+				entry.BehindNAT,
+				entry.PersistentKeepalive,
+				fmt.Sprintf("inventory clients[%d].persistent_keepalive", i),
+			)
+		}
+	}
+
+	for _, keepalive := range SplitList(os.Getenv("WGG_PERSISTENT_KEEPALIVES")) {
+		targetID, value, ok := strings.Cut(keepalive, "=")
+		targetID = strings.TrimSpace(targetID)
+		if !ok || len(targetID) <= 0 {
+			validation.problem("WGG_PERSISTENT_KEEPALIVES", "invalid entry '%s', expected <target-id>=<seconds>", keepalive)
+		} else if !targets[targetID] {
+			validation.problem("WGG_PERSISTENT_KEEPALIVES", "unknown target '%s'", targetID)
+		} else if _, ok := settings.Intervals[targetID]; !ok {
+			interval, ok := parseKeepalive(strings.TrimSpace(value), "WGG_PERSISTENT_KEEPALIVES", validation)
+			if ok {
+				settings.Intervals[targetID] = interval
+			}
+		}
+	}
+
+	if len(validation.Problems) > 0 {
+		return settings, validation
+	}
+
+	return settings, nil
+}
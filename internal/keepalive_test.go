@@ -0,0 +1,95 @@
+package wgg
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestInitKeepaliveSettings(t *testing.T) {
+	t.Setenv("WGG_PERSISTENT_KEEPALIVE", "")
+	t.Setenv("WGG_BEHIND_NAT", "c1, nodes")
+	t.Setenv("WGG_PERSISTENT_KEEPALIVES", "c2=15,n1=0")
+
+	off := 0
+	inventory := &Inventory{
+		Nodes: []InventoryNode{
+			{Endpoint: "192.0.2.1:55333"},
+			{Endpoint: "192.0.2.2:55333"},
+		},
+		Clients: []InventoryClient{
+			{Name: "laptop", BehindNAT: true},
+			{},
+			{PersistentKeepalive: &off},
+			{},
+		},
+	}
+
+	nodeList := []WggNode{{ID: 0}, {ID: 1}}
+	clientList := []WggClient{{ID: 0, Name: "laptop"}, {ID: 1}, {ID: 2}, {ID: 3}}
+
+	settings, err := InitKeepaliveSettings(inventory, nodeList, clientList)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	cases := []struct {
+		target   WggTarget
+		expected int
+	}{
+		{WggNode{ID: 0}, DefaultPersistentKeepalive},
+		{WggNode{ID: 1}, 0},
+		{WggClient{ID: 0, Name: "laptop"}, DefaultPersistentKeepalive},
+		{WggClient{ID: 1}, DefaultPersistentKeepalive},
+		{WggClient{ID: 2}, 0},
+		{WggClient{ID: 3}, 0},
+	}
+	for _, c := range cases {
+		if keepalive := settings.Of(c.target); keepalive != c.expected {
+			t.Errorf("expected keepalive %d of %s, but got %d", c.expected, c.target.TargetID(), keepalive)
+		}
+	}
+
+	t.Setenv("WGG_PERSISTENT_KEEPALIVE", "70000")
+	t.Setenv("WGG_PERSISTENT_KEEPALIVES", "c2")
+	_, err = InitKeepaliveSettings(nil, nodeList, clientList)
+	var validation *ValidationError
+	if !errors.As(err, &validation) || len(validation.Problems) != 2 {
+		t.Errorf("expected a ValidationError with 2 problems, but got %v", err)
+	}
+
+	t.Setenv("WGG_PERSISTENT_KEEPALIVE", "")
+	t.Setenv("WGG_BEHIND_NAT", "c0, clients")
+	t.Setenv("WGG_PERSISTENT_KEEPALIVES", "n2=15")
+	_, err = InitKeepaliveSettings(inventory, nodeList, clientList)
+	if !errors.As(err, &validation) || len(validation.Problems) != 2 ||
+		!strings.Contains(err.Error(), "unknown target 'c0'") ||
+		!strings.Contains(err.Error(), "unknown target 'n2'") {
+		t.Errorf("expected the unknown targets c0 and n2, but got %v", err)
+	}
+}
+
+func TestGenWgClientConfPartKeepalive(t *testing.T) {
+	keyStore := NewFileKeyStore(t.TempDir(), nil)
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	node := WggNode{ID: 0, Host: "192.0.2.1", Port: 55333}
+	client := NewWggClient(0)
+	options := GenOptions{
+		Keepalives: KeepaliveSettings{Default: 25, BehindNATClients: true},
+	}
+
+	conf, err := GenWgClientConfPart(node, keyStore, subnet, client, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	} else if !strings.HasSuffix(conf, "Endpoint = 192.0.2.1:55333\nPersistentKeepalive = 25\n") {
+		t.Errorf("expected a keepalive in the node peer of a client behind NAT, but got:\n%s", conf)
+	}
+
+	conf, err = GenWgClientConfPart(client, keyStore, subnet, node, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	} else if strings.Contains(conf, "PersistentKeepalive") {
+		t.Errorf("expected no keepalive in the config of a node, but got:\n%s", conf)
+	}
+}
@@ -0,0 +1,353 @@
+package wgg
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/CoreUnit-NET/wgg/lib/userin"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// EncryptedKeyPrefix marks key files that are encrypted with a KeyCipher.
+// The ciphertext is bound to the name of the key file, so two key files
+// cannot be swapped unnoticed.
+const EncryptedKeyPrefix = "wgg-enc-v1:"
+
+// KeyStoreMetaFile is the name of the file in the keyDir that holds the
+// parameters of an encrypted key dir.
+const KeyStoreMetaFile = "keystore.json"
+
+// keyStoreCheck is encrypted into the keystore meta file to detect a wrong
+// passphrase before any key is touched.
+const keyStoreCheck = "wgg"
+
+// KeyStoreMeta describes how the key encryption key of an encrypted keyDir
+// is derived from the passphrase.
+type KeyStoreMeta struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	Salt    string `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+	Check   string `json:"check"`
+}
+
+// KeyCipher encrypts and decrypts key files with XChaCha20-Poly1305.
+type KeyCipher struct {
+	aead cipher.AEAD
+}
+
+// NewKeyCipher derives the key encryption key from the passphrase via
+// Argon2id with the parameters of the given meta.
+func NewKeyCipher(passphrase string, meta KeyStoreMeta) (*KeyCipher, error) {
+	if meta.KDF != "argon2id" {
+		return nil, errors.New("unsupported keystore kdf '" + meta.KDF + "'")
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(meta.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keystore salt: %w", err)
+	}
+
+	key := argon2.IDKey(
+		[]byte(passphrase),
+		salt,
+		meta.Time,
+		meta.Memory,
+		meta.Threads,
+		chacha20poly1305.KeySize,
+	)
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating key cipher: %w", err)
+	}
+
+	return &KeyCipher{aead: aead}, nil
+}
+
+// Encrypt encrypts the plaintext and returns it base64 encoded with the
+// EncryptedKeyPrefix. The name is authenticated as associated data, the
+// same name has to be given to Decrypt.
+func (keyCipher *KeyCipher) Encrypt(plaintext string, name string) (string, error) {
+	nonce := make([]byte, keyCipher.aead.NonceSize())
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", fmt.Errorf("error generating nonce: %w", err)
+	}
+
+	sealed := keyCipher.aead.Seal(nonce, nonce, []byte(plaintext), []byte(name))
+
+	return EncryptedKeyPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt reverses Encrypt, it fails if the data was encrypted for another
+// name.
+func (keyCipher *KeyCipher) Decrypt(data string, name string) (string, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(data), EncryptedKeyPrefix)
+	if !ok {
+		return "", errors.New("data is not encrypted")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("encrypted data is not valid base64: %w", err)
+	} else if len(sealed) < keyCipher.aead.NonceSize() {
+		return "", errors.New("encrypted data is too short")
+	}
+
+	nonceSize := keyCipher.aead.NonceSize()
+	plaintext, err := keyCipher.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(name))
+	if err != nil {
+		return "", errors.New("error decrypting data: wrong passphrase or corrupted data")
+	}
+
+	return string(plaintext), nil
+}
+
+// OpenKeyCipher unlocks the encrypted keyDir, or initializes a new one if
+// the keyDir has no KeyStoreMetaFile yet.
+//
+// The passphrase func is called once, with isNew set if a new keystore is
+// created.
+func OpenKeyCipher(
+	keyDir string,
+	passphrase func(isNew bool) (string, error),
+) (*KeyCipher, error) {
+	metaPath := keyDir + "/" + KeyStoreMetaFile
+
+	rawMeta, err := os.ReadFile(metaPath)
+	if os.IsNotExist(err) {
+		return createKeyStoreMeta(metaPath, passphrase)
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading keystore meta: %w", err)
+	}
+
+	meta := KeyStoreMeta{}
+	err = json.Unmarshal(rawMeta, &meta)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing keystore meta '%s': %w", metaPath, err)
+	} else if meta.Version != 1 {
+		return nil, fmt.Errorf("unsupported keystore version %d", meta.Version)
+	}
+
+	pass, err := passphrase(false)
+	if err != nil {
+		return nil, fmt.Errorf("error reading passphrase: %w", err)
+	}
+
+	keyCipher, err := NewKeyCipher(pass, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	check, err := keyCipher.Decrypt(meta.Check, KeyStoreMetaFile)
+	if err != nil || check != keyStoreCheck {
+		return nil, errors.New("wrong passphrase for the keys in '" + keyDir + "'")
+	}
+
+	return keyCipher, nil
+}
+
+func createKeyStoreMeta(
+	metaPath string,
+	passphrase func(isNew bool) (string, error),
+) (*KeyCipher, error) {
+	salt := make([]byte, 16)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, fmt.Errorf("error generating salt: %w", err)
+	}
+
+	meta := KeyStoreMeta{
+		Version: 1,
+		KDF:     "argon2id",
+		Salt:    base64.StdEncoding.EncodeToString(salt),
+		Time:    3,
+		Memory:  64 * 1024,
+		Threads: 4,
+	}
+
+	pass, err := passphrase(true)
+	if err != nil {
+		return nil, fmt.Errorf("error reading passphrase: %w", err)
+	}
+
+	keyCipher, err := NewKeyCipher(pass, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	meta.Check, err = keyCipher.Encrypt(keyStoreCheck, KeyStoreMetaFile)
+	if err != nil {
+		return nil, err
+	}
+
+	rawMeta, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding keystore meta: %w", err)
+	}
+
+	err = os.WriteFile(metaPath, rawMeta, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error writing keystore meta: %w", err)
+	}
+
+	return keyCipher, nil
+}
+
+// InitKeyCipher returns the KeyCipher for the keyDir, or nil if the keys
+// are stored in plaintext.
+//
+// Encryption is used if WGG_ENCRYPT_KEYS is set to a true value or if the
+// keyDir is already encrypted. The passphrase is taken from
+// WGG_KEY_PASSPHRASE if set, otherwise it is prompted for.
+func InitKeyCipher(keyDir string) (*KeyCipher, error) {
+	encrypt, err := EnvBool("WGG_ENCRYPT_KEYS")
+	if err != nil {
+		return nil, err
+	}
+
+	if !encrypt {
+		_, err = os.Stat(keyDir + "/" + KeyStoreMetaFile)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+	}
+
+	return OpenKeyCipher(keyDir, func(isNew bool) (string, error) {
+		passphrase := os.Getenv("WGG_KEY_PASSPHRASE")
+		if len(passphrase) > 0 {
+			return passphrase, nil
+		}
+
+		if isNew {
+			fmt.Println("Creating an encrypted key dir at '" + keyDir + "'.")
+			return userin.PromptNewPassword()
+		}
+
+		fmt.Println("Unlocking the encrypted key dir at '" + keyDir + "'.")
+		return userin.PromptPassword()
+	})
+}
+
+// isEncrypted returns true if the key file data is encrypted.
+func isEncrypted(data string) bool {
+	return strings.HasPrefix(data, EncryptedKeyPrefix)
+}
+
+// keyFileName returns the name an encrypted key file is bound to, its base
+// name without the ArchiveTimestamp suffix of an archived key, so
+// "archive/n0.key.<time>" is still bound to "n0.key".
+func keyFileName(path string) string {
+	name := filepath.Base(path)
+	base, suffix, ok := cutLast(name, ".")
+	if ok {
+		_, err := time.Parse(archiveTimestampLayout, suffix)
+		if err == nil {
+			return base
+		}
+	}
+
+	return name
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s string, sep string) (string, string, bool) {
+	index := strings.LastIndex(s, sep)
+	if index < 0 {
+		return s, "", false
+	}
+
+	return s[:index], s[index+len(sep):], true
+}
+
+// ReadSecretKeyFile reads a private or preshared key file and returns its
+// trimmed content. Encrypted files are decrypted with keyCipher.
+func ReadSecretKeyFile(path string, keyCipher *KeyCipher) (string, error) {
+	rawData, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	data := strings.TrimSpace(string(rawData))
+	if !isEncrypted(data) {
+		return data, nil
+	}
+
+	if keyCipher == nil {
+		return "", errors.New("key is encrypted, but no passphrase was given")
+	}
+
+	return keyCipher.Decrypt(data, keyFileName(path))
+}
+
+// WriteSecretKeyFile writes a private or preshared key file that only the
+// owner can read. The key is encrypted with keyCipher if it is not nil.
+func WriteSecretKeyFile(path string, key string, keyCipher *KeyCipher) error {
+	var err error
+	if keyCipher != nil {
+		key, err = keyCipher.Encrypt(key, keyFileName(path))
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.WriteFile(path, []byte(key), 0600)
+}
+
+// EncryptKeyDir encrypts all plaintext private and preshared key files in
+// keyDir and its archive with keyCipher, so a keyDir can be switched to
+// encryption at rest.
+func EncryptKeyDir(keyDir string, keyCipher *KeyCipher) error {
+	files, err := os.ReadDir(keyDir)
+	if err != nil {
+		return errors.New("Error reading keyDir: " + err.Error())
+	}
+
+	for _, file := range files {
+		path := keyDir + "/" + file.Name()
+		name := keyFileName(path)
+
+		if file.IsDir() {
+			if file.Name() == "archive" {
+				err = EncryptKeyDir(path, keyCipher)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		} else if !(strings.HasSuffix(name, ".key") || strings.HasSuffix(name, ".psk")) {
+			continue
+		}
+
+		rawData, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading '%s': %w", path, err)
+		}
+
+		data := strings.TrimSpace(string(rawData))
+		if isEncrypted(data) {
+			continue
+		}
+
+		err = WriteSecretKeyFile(path, data, keyCipher)
+		if err != nil {
+			return fmt.Errorf("error encrypting '%s': %w", path, err)
+		}
+	}
+
+	return nil
+}
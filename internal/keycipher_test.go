@@ -0,0 +1,125 @@
+package wgg
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestOpenKeyCipher(t *testing.T) {
+	keyDir := t.TempDir()
+	passphrase := func(pass string) func(bool) (string, error) {
+		return func(bool) (string, error) { return pass, nil }
+	}
+
+	keyCipher, err := OpenKeyCipher(keyDir, passphrase("secret"))
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	privateKey, _, err := InitWireGuardKeyPair(keyDir+"/n0.key", keyDir+"/n0.pub", keyCipher)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	rawData, err := os.ReadFile(keyDir + "/n0.key")
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.HasPrefix(string(rawData), EncryptedKeyPrefix) {
+		t.Fatalf("expected an encrypted private key, but got %q", rawData)
+	}
+
+	_, err = OpenKeyCipher(keyDir, passphrase("wrong"))
+	if err == nil {
+		t.Errorf("expected error for a wrong passphrase, but got none")
+	}
+
+	keyCipher, err = OpenKeyCipher(keyDir, passphrase("secret"))
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	loadedPrivateKey, _, err := LoadWireGuardKeyPair(keyDir+"/n0.key", keyDir+"/n0.pub", keyCipher)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	} else if loadedPrivateKey != privateKey {
+		t.Errorf("expected private key %s, but got %s", privateKey, loadedPrivateKey)
+	}
+
+	_, _, err = LoadWireGuardKeyPair(keyDir+"/n0.key", keyDir+"/n0.pub", nil)
+	if err == nil {
+		t.Errorf("expected error when loading an encrypted key without cipher, but got none")
+	}
+}
+
+func TestEncryptKeyDir(t *testing.T) {
+	keyDir := t.TempDir()
+	passphrase := func(bool) (string, error) { return "secret", nil }
+
+	err := os.MkdirAll(keyDir+"/archive", 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/n0.key", "/n1.key", "/archive/n0.key.20260101T000000Z"} {
+		_, _, err = InitWireGuardKeyPair(keyDir+path, keyDir+path+".pub", nil)
+		if err != nil {
+			t.Fatalf("did not expect error, but got %v", err)
+		}
+	}
+
+	keyCipher, err := OpenKeyCipher(keyDir, passphrase)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	err = EncryptKeyDir(keyDir, keyCipher)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	archivedPath := keyDir + "/archive/n0.key.20260101T000000Z"
+	rawData, err := os.ReadFile(archivedPath)
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.HasPrefix(string(rawData), EncryptedKeyPrefix) {
+		t.Fatalf("expected an encrypted archived key, but got %q", rawData)
+	}
+
+	_, err = ReadSecretKeyFile(archivedPath, keyCipher)
+	if err != nil {
+		t.Errorf("did not expect error reading the archived key, but got %v", err)
+	}
+
+	n0, err := os.ReadFile(keyDir + "/n0.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyDir+"/n1.key", n0, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ReadSecretKeyFile(keyDir+"/n1.key", keyCipher)
+	if err == nil {
+		t.Errorf("expected error for a key file swapped with another one, but got none")
+	}
+}
+
+func TestKeyFileName(t *testing.T) {
+	cases := []struct {
+		path     string
+		expected string
+	}{
+		{"keys/alice.key", "alice.key"},
+		{"keys/alice.key.key", "alice.key.key"},
+		{"keys/alice.key.psk", "alice.key.psk"},
+		{"keys/archive/alice.key.20260101T000000Z", "alice.key"},
+		{"keys/archive/alice.key.key.20260101T000000Z", "alice.key.key"},
+		{"keys/archive/c0-n0.psk.20260101T000000Z", "c0-n0.psk"},
+	}
+	for _, c := range cases {
+		if name := keyFileName(c.path); name != c.expected {
+			t.Errorf("expected %s for %s, but got %s", c.expected, c.path, name)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package wgg
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// keyringEntry holds a cached key lookup. The lookup runs once, concurrent
+// callers wait for it.
+type keyringEntry struct {
+	once       sync.Once
+	privateKey string
+	publicKey  string
+	err        error
+}
+
+// Keyring is an in-memory cache in front of another KeyStore.
+//
+// Every key pair, public key and preshared key is loaded or created at most
+// once per run, no matter how many configs reference it. A Keyring is safe
+// for concurrent use.
+type Keyring struct {
+	store KeyStore
+
+	mu            sync.Mutex
+	keyPairs      map[string]*keyringEntry
+	publicKeys    map[string]*keyringEntry
+	presharedKeys map[string]*keyringEntry
+}
+
+// NewKeyring returns a new Keyring in front of the given KeyStore.
+func NewKeyring(store KeyStore) *Keyring {
+	return &Keyring{
+		store:         store,
+		keyPairs:      map[string]*keyringEntry{},
+		publicKeys:    map[string]*keyringEntry{},
+		presharedKeys: map[string]*keyringEntry{},
+	}
+}
+
+func (keyring *Keyring) entry(entries map[string]*keyringEntry, id string) *keyringEntry {
+	keyring.mu.Lock()
+	defer keyring.mu.Unlock()
+
+	entry, ok := entries[id]
+	if !ok {
+		entry = &keyringEntry{}
+		entries[id] = entry
+	}
+
+	return entry
+}
+
+// forget drops all cached keys of the target.
+func (keyring *Keyring) forget(targetID string) {
+	keyring.mu.Lock()
+	defer keyring.mu.Unlock()
+
+	delete(keyring.keyPairs, targetID)
+	delete(keyring.publicKeys, targetID)
+	for pairID := range keyring.presharedKeys {
+		if IsKeyFileOf(pairID+".psk", targetID) {
+			delete(keyring.presharedKeys, pairID)
+		}
+	}
+}
+
+// LoadKeyPair loads the key pair from the underlying KeyStore. It is not
+// cached, because it is not used while rendering configs.
+func (keyring *Keyring) LoadKeyPair(targetID string) (string, string, error) {
+	return keyring.store.LoadKeyPair(targetID)
+}
+
+// SaveKeyPair saves the key pair in the underlying KeyStore and drops the
+// cached keys of the target.
+func (keyring *Keyring) SaveKeyPair(
+	targetID string,
+	privateKey string,
+	publicKey string,
+) error {
+	defer keyring.forget(targetID)
+
+	return keyring.store.SaveKeyPair(targetID, privateKey, publicKey)
+}
+
+// LoadPublicKey returns the public key of the target, loading it once. A
+// missing key is not cached, as it may be created later in the run.
+func (keyring *Keyring) LoadPublicKey(targetID string) (string, error) {
+	entry := keyring.entry(keyring.publicKeys, targetID)
+	entry.once.Do(func() {
+		entry.publicKey, entry.err = keyring.store.LoadPublicKey(targetID)
+	})
+
+	if errors.Is(entry.err, ErrKeyNotFound) {
+		keyring.mu.Lock()
+		if keyring.publicKeys[targetID] == entry {
+			delete(keyring.publicKeys, targetID)
+		}
+		keyring.mu.Unlock()
+	}
+
+	return entry.publicKey, entry.err
+}
+
+// SavePublicKey saves the public key in the underlying KeyStore and drops
+// the cached keys of the target.
+func (keyring *Keyring) SavePublicKey(targetID string, publicKey string) error {
+	defer keyring.forget(targetID)
+
+	return keyring.store.SavePublicKey(targetID, publicKey)
+}
+
+// InitKeyPair returns the key pair of the target, initializing it once.
+func (keyring *Keyring) InitKeyPair(targetID string) (string, string, error) {
+	entry := keyring.entry(keyring.keyPairs, targetID)
+	entry.once.Do(func() {
+		entry.privateKey, entry.publicKey, entry.err = keyring.store.InitKeyPair(targetID)
+	})
+
+	return entry.privateKey, entry.publicKey, entry.err
+}
+
+// LoadPresharedKey loads the preshared key from the underlying KeyStore. It
+// is not cached, because it is not used while rendering configs.
+func (keyring *Keyring) LoadPresharedKey(pairID string) (string, error) {
+	return keyring.store.LoadPresharedKey(pairID)
+}
+
+// InitPresharedKey returns the preshared key, initializing it once.
+func (keyring *Keyring) InitPresharedKey(pairID string) (string, error) {
+	entry := keyring.entry(keyring.presharedKeys, pairID)
+	entry.once.Do(func() {
+		entry.privateKey, entry.err = keyring.store.InitPresharedKey(pairID)
+	})
+
+	return entry.privateKey, entry.err
+}
+
+// SavePresharedKey saves the preshared key in the underlying KeyStore and
+// drops the cached preshared key.
+func (keyring *Keyring) SavePresharedKey(pairID string, presharedKey string) error {
+	defer func() {
+		keyring.mu.Lock()
+		delete(keyring.presharedKeys, pairID)
+		keyring.mu.Unlock()
+	}()
+
+	return keyring.store.SavePresharedKey(pairID, presharedKey)
+}
+
+// ArchiveKeys archives the keys in the underlying KeyStore and drops the
+// cached keys of the target.
+func (keyring *Keyring) ArchiveKeys(targetID string, now time.Time) error {
+	defer keyring.forget(targetID)
+
+	return keyring.store.ArchiveKeys(targetID, now)
+}
@@ -0,0 +1,142 @@
+package wgg
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingKeyStore counts the InitKeyPair calls that reach the store.
+type countingKeyStore struct {
+	KeyStore
+	initKeyPairCalls atomic.Int64
+}
+
+func (store *countingKeyStore) InitKeyPair(targetID string) (string, string, error) {
+	store.initKeyPairCalls.Add(1)
+	return store.KeyStore.InitKeyPair(targetID)
+}
+
+func testMesh(nodeCount int, clientCount int) (*net.IPNet, []WggNode, []WggClient) {
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/16")
+
+	nodeList := []WggNode{}
+	for i := range nodeCount {
+		ip := net.ParseIP(fmt.Sprintf("192.0.2.%d", i+1))
+		nodeList = append(nodeList, WggNode{ID: i, PubIp: &ip, Port: 55333})
+	}
+
+	clientList := []WggClient{}
+	for i := range clientCount {
+		clientList = append(clientList, NewWggClient(i))
+	}
+
+	return subnet, nodeList, clientList
+}
+
+func TestKeyring(t *testing.T) {
+	subnet, nodeList, clientList := testMesh(4, 50)
+	store := &countingKeyStore{KeyStore: NewFileKeyStore(t.TempDir(), nil)}
+	keyring := NewKeyring(store)
+	options := GenOptions{PresharedKeys: true, Workers: 8}
+
+	err := GenerateNodeConfigs(subnet, t.TempDir(), keyring, nodeList, clientList, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	err = GenerateClientConfigs(subnet, t.TempDir(), keyring, nodeList, clientList, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	if calls := store.initKeyPairCalls.Load(); calls != 54 {
+		t.Errorf("expected every key pair to be initialized once, but got %d calls", calls)
+	}
+
+	privateKey, _, _ := keyring.InitKeyPair("c0")
+	err = keyring.ArchiveKeys("c0", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newPrivateKey, _, _ := keyring.InitKeyPair("c0")
+	if newPrivateKey == privateKey {
+		t.Errorf("expected the archived key pair to be dropped from the cache")
+	}
+
+	_, err = keyring.LoadPublicKey("c50")
+	if err == nil {
+		t.Fatalf("expected a missing public key, but got none")
+	}
+	_, publicKey, _ := keyring.InitKeyPair("c50")
+	loadedPublicKey, err := keyring.LoadPublicKey("c50")
+	if err != nil || loadedPublicKey != publicKey {
+		t.Errorf("expected the public key created after a miss, but got '%s', %v", loadedPublicKey, err)
+	}
+}
+
+func TestParallelEach(t *testing.T) {
+	sum := atomic.Int64{}
+	err := ParallelEach(100, 7, func(i int) error {
+		sum.Add(int64(i))
+		return nil
+	})
+	if err != nil || sum.Load() != 4950 {
+		t.Errorf("expected every index once, got sum %d and err %v", sum.Load(), err)
+	}
+
+	err = ParallelEach(100, 7, func(i int) error {
+		if i == 42 {
+			return fmt.Errorf("failed at %d", i)
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "failed at 42" {
+		t.Errorf("expected the error of index 42, but got %v", err)
+	}
+}
+
+func benchmarkGenerateConfigs(b *testing.B, cached bool, workers int) {
+	subnet, nodeList, clientList := testMesh(10, 500)
+	keyDir := b.TempDir()
+	outDir := b.TempDir()
+	options := GenOptions{Workers: workers}
+
+	// create all keys up front, the benchmark measures regeneration
+	err := GenerateNodeConfigs(subnet, outDir, NewFileKeyStore(keyDir, nil), nodeList, clientList, options)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for range b.N {
+		var keyStore KeyStore = NewFileKeyStore(keyDir, nil)
+		if cached {
+			keyStore = NewKeyring(keyStore)
+		}
+
+		err = GenerateNodeConfigs(subnet, outDir, keyStore, nodeList, clientList, options)
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = GenerateClientConfigs(subnet, outDir, keyStore, nodeList, clientList, options)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGenerateConfigsUncachedSequential(b *testing.B) {
+	benchmarkGenerateConfigs(b, false, 1)
+}
+
+func BenchmarkGenerateConfigsKeyringSequential(b *testing.B) {
+	benchmarkGenerateConfigs(b, true, 1)
+}
+
+func BenchmarkGenerateConfigsKeyringParallel(b *testing.B) {
+	benchmarkGenerateConfigs(b, true, runtime.NumCPU())
+}
@@ -0,0 +1,302 @@
+package wgg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrKeyNotFound is returned by a KeyStore if a target has no keys yet.
+var ErrKeyNotFound = errors.New("key not found")
+
+// KeyStore loads, saves and initializes the WireGuard keys of all targets.
+type KeyStore interface {
+	// LoadKeyPair returns the validated private and public key of the
+	// target. If the target has no keys yet, an error wrapping
+	// ErrKeyNotFound is returned. On ErrKeyMismatch both keys are returned
+	// as loaded, on ErrPublicKeyMissing only the private key.
+	LoadKeyPair(targetID string) (string, string, error)
+
+	// SaveKeyPair stores the private and public key of the target,
+	// overwriting existing keys.
+	SaveKeyPair(targetID string, privateKey string, publicKey string) error
+
+	// LoadPublicKey returns the validated public key of the target. It also
+	// works for targets whose private key is not held by the KeyStore. If
+	// the target has no public key yet, an error wrapping ErrKeyNotFound is
+	// returned.
+	LoadPublicKey(targetID string) (string, error)
+
+	// SavePublicKey stores only the public key of a target whose private key
+	// is kept elsewhere, e.g. on the node itself.
+	SavePublicKey(targetID string, publicKey string) error
+
+	// InitKeyPair loads the key pair of the target, or generates and saves a
+	// new one if the target has no keys yet.
+	InitKeyPair(targetID string) (string, string, error)
+
+	// LoadPresharedKey returns the validated preshared key with the given
+	// PresharedKeyID. If it does not exist yet, an error wrapping
+	// ErrKeyNotFound is returned.
+	LoadPresharedKey(pairID string) (string, error)
+
+	// InitPresharedKey loads the preshared key with the given
+	// PresharedKeyID, or generates and saves a new one.
+	InitPresharedKey(pairID string) (string, error)
+
+	// SavePresharedKey stores the preshared key with the given
+	// PresharedKeyID, overwriting an existing one.
+	SavePresharedKey(pairID string, presharedKey string) error
+
+	// ArchiveKeys moves the key pair and all preshared keys of the target
+	// out of the way, so the next InitKeyPair creates fresh keys.
+	ArchiveKeys(targetID string, now time.Time) error
+}
+
+// FileKeyStore stores the keys as "<id>.key", "<id>.pub" and
+// "<pair-id>.psk" files in Dir.
+type FileKeyStore struct {
+	Dir string
+
+	// Cipher encrypts and decrypts the private and preshared keys. Nil
+	// stores them in plaintext.
+	Cipher *KeyCipher
+}
+
+// NewFileKeyStore returns a new FileKeyStore for the given keyDir.
+func NewFileKeyStore(
+	keyDir string,
+	keyCipher *KeyCipher,
+) *FileKeyStore {
+	return &FileKeyStore{
+		Dir:    keyDir,
+		Cipher: keyCipher,
+	}
+}
+
+func (store *FileKeyStore) privateKeyPath(targetID string) string {
+	return store.Dir + "/" + targetID + ".key"
+}
+
+func (store *FileKeyStore) publicKeyPath(targetID string) string {
+	return store.Dir + "/" + targetID + ".pub"
+}
+
+// LoadKeyPair loads the key pair of the target via LoadWireGuardKeyPair.
+func (store *FileKeyStore) LoadKeyPair(targetID string) (string, string, error) {
+	_, err := os.Stat(store.privateKeyPath(targetID))
+	if os.IsNotExist(err) {
+		return "", "", ErrKeyNotFound
+	}
+
+	return LoadWireGuardKeyPair(
+		store.privateKeyPath(targetID),
+		store.publicKeyPath(targetID),
+		store.Cipher,
+	)
+}
+
+// SaveKeyPair saves the key pair of the target via SaveWireGuardKeyPair.
+func (store *FileKeyStore) SaveKeyPair(
+	targetID string,
+	privateKey string,
+	publicKey string,
+) error {
+	return SaveWireGuardKeyPair(
+		store.privateKeyPath(targetID),
+		store.publicKeyPath(targetID),
+		privateKey,
+		publicKey,
+		store.Cipher,
+	)
+}
+
+// LoadPublicKey reads and validates the "<id>.pub" file of the target.
+func (store *FileKeyStore) LoadPublicKey(targetID string) (string, error) {
+	publicKey, err := os.ReadFile(store.publicKeyPath(targetID))
+	if os.IsNotExist(err) {
+		return "", ErrKeyNotFound
+	} else if err != nil {
+		return "", fmt.Errorf("error reading public key: %w", err)
+	}
+
+	_, err = ParseWireGuardKey(string(publicKey))
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+
+	return strings.TrimSpace(string(publicKey)), nil
+}
+
+// SavePublicKey writes the "<id>.pub" file of the target.
+func (store *FileKeyStore) SavePublicKey(targetID string, publicKey string) error {
+	err := os.WriteFile(store.publicKeyPath(targetID), []byte(publicKey), 0600)
+	if err != nil {
+		return fmt.Errorf("error writing public key: %w", err)
+	}
+
+	return nil
+}
+
+// InitKeyPair initializes the key pair of the target via
+// InitWireGuardKeyPair.
+func (store *FileKeyStore) InitKeyPair(targetID string) (string, string, error) {
+	return InitWireGuardKeyPair(
+		store.privateKeyPath(targetID),
+		store.publicKeyPath(targetID),
+		store.Cipher,
+	)
+}
+
+// InitPresharedKey initializes the preshared key via
+// InitWireGuardPresharedKey.
+func (store *FileKeyStore) InitPresharedKey(pairID string) (string, error) {
+	return InitWireGuardPresharedKey(
+		store.Dir+"/"+pairID+".psk",
+		store.Cipher,
+	)
+}
+
+// LoadPresharedKey reads and validates the "<pair-id>.psk" file.
+func (store *FileKeyStore) LoadPresharedKey(pairID string) (string, error) {
+	presharedKey, err := ReadSecretKeyFile(store.Dir+"/"+pairID+".psk", store.Cipher)
+	if os.IsNotExist(err) {
+		return "", ErrKeyNotFound
+	} else if err != nil {
+		return "", fmt.Errorf("error reading preshared key: %w", err)
+	}
+
+	_, err = ParseWireGuardKey(presharedKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid preshared key: %w", err)
+	}
+
+	return presharedKey, nil
+}
+
+// SavePresharedKey writes the "<pair-id>.psk" file.
+func (store *FileKeyStore) SavePresharedKey(pairID string, presharedKey string) error {
+	err := WriteSecretKeyFile(store.Dir+"/"+pairID+".psk", presharedKey, store.Cipher)
+	if err != nil {
+		return fmt.Errorf("error writing preshared key: %w", err)
+	}
+
+	return nil
+}
+
+// ArchiveKeys moves the key files of the target to "<Dir>/archive",
+// suffixed with the given time. Archived keys keep their encryption.
+func (store *FileKeyStore) ArchiveKeys(targetID string, now time.Time) error {
+	archiveDir := store.Dir + "/archive"
+	err := os.MkdirAll(archiveDir, 0700)
+	if err != nil {
+		return errors.New("Error creating archive dir at '" + archiveDir + "': " + err.Error())
+	}
+
+	files, err := os.ReadDir(store.Dir)
+	if err != nil {
+		return errors.New("Error reading keyDir: " + err.Error())
+	}
+
+	suffix := "." + ArchiveTimestamp(now)
+
+	for _, file := range files {
+		if !IsKeyFileOf(file.Name(), targetID) {
+			continue
+		}
+
+		err = os.Rename(store.Dir+"/"+file.Name(), archiveDir+"/"+file.Name()+suffix)
+		if err != nil {
+			return fmt.Errorf("error archiving '%s': %w", file.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// archiveTimestampLayout is the time layout of ArchiveTimestamp.
+const archiveTimestampLayout = "20060102T150405Z"
+
+// ArchiveTimestamp formats the time that is appended to archived keys.
+func ArchiveTimestamp(now time.Time) string {
+	return now.UTC().Format(archiveTimestampLayout)
+}
+
+// InitKeyStore returns the KeyStore selected by the WGG_KEYSTORE env var.
+//
+// "file" (the default) stores the keys in keyDir, encrypted if
+// InitKeyCipher returns a cipher. "vault" stores them in a HashiCorp Vault
+// KV v2 engine, see InitVaultKeyStore.
+//
+// If InitMasterSeed returns a seed, the selected store is wrapped in a
+// DerivedKeyStore that records its key sources in "<keyDir>/keysources.json".
+func InitKeyStore(keyDir string) (KeyStore, error) {
+	return initKeyStore(keyDir, false)
+}
+
+// InitReadOnlyKeyStore returns the KeyStore selected as by InitKeyStore as
+// ReadOnlyKeyStore. Nothing is written: a keyDir is only decrypted if it is
+// encrypted already and no master seed file is created.
+func InitReadOnlyKeyStore(keyDir string) (KeyStore, error) {
+	store, err := initKeyStore(keyDir, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReadOnlyKeyStore(store), nil
+}
+
+func initKeyStore(keyDir string, readOnly bool) (KeyStore, error) {
+	var store KeyStore
+
+	backend := os.Getenv("WGG_KEYSTORE")
+	switch backend {
+	case "", "file":
+		var keyCipher *KeyCipher
+		_, err := os.Stat(keyDir + "/" + KeyStoreMetaFile)
+		if !readOnly || err == nil {
+			keyCipher, err = InitKeyCipher(keyDir)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if keyCipher != nil && !readOnly {
+			err = EncryptKeyDir(keyDir, keyCipher)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		store = NewFileKeyStore(keyDir, keyCipher)
+	case "vault":
+		vaultStore, err := InitVaultKeyStore()
+		if err != nil {
+			return nil, err
+		}
+
+		store = vaultStore
+	default:
+		return nil, errors.New(
+			"invalid WGG_KEYSTORE env var: value '" + backend +
+				"': expected 'file' or 'vault'",
+		)
+	}
+
+	seed, err := InitMasterSeed(readOnly)
+	if err != nil {
+		return nil, err
+	} else if seed == nil {
+		return store, nil
+	}
+
+	derived, err := NewDerivedKeyStore(seed, store, keyDir+"/keysources.json")
+	if err != nil {
+		return nil, err
+	}
+	derived.readOnly = readOnly
+
+	return derived, nil
+}
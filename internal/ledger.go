@@ -0,0 +1,351 @@
+package wgg
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"slices"
+
+	"github.com/CoreUnit-NET/wgg/lib/stringfs"
+)
+
+// LedgerFileName is the name of the address ledger in the out dir.
+const LedgerFileName = "ledger.json"
+
+// Ledger records the address of every target when it is first allocated, so
+// later runs keep it, no matter how the node and client lists or the subnet
+// change.
+type Ledger struct {
+	// Subnet is the subnet of the last run, Subnet6 the IPv6 overlay prefix
+	// of a dual-stack overlay.
+	Subnet  string `json:"subnet,omitempty"`
+	Subnet6 string `json:"subnet6,omitempty"`
+
+	// Targets holds the assignment of every target by target ID.
+	Targets map[string]*LedgerEntry `json:"targets"`
+
+	// Path is the path of the ledger file.
+	Path string `json:"-"`
+
+	// Subnet6Generated is set if Subnet6 was generated in this run and is
+	// not saved yet, until then every run generates another one.
+	Subnet6Generated bool `json:"-"`
+}
+
+// LedgerEntry is the assignment of a target.
+type LedgerEntry struct {
+	Address   string `json:"address"`
+	PublicKey string `json:"public_key,omitempty"`
+
+	// Endpoint is the default endpoint of a node, a node with the endpoint
+	// of another node was renumbered.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Removed marks a target that is no longer configured. Its address is
+	// kept as a hole and only handed out again if the same target ID returns.
+	Removed bool `json:"removed,omitempty"`
+}
+
+// LoadLedger reads the ledger file at path. A missing file is an empty
+// ledger.
+func LoadLedger(path string) (*Ledger, error) {
+	ledger := &Ledger{}
+
+	rawData, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.New("Error reading ledger '" + path + "': " + err.Error())
+	} else if err == nil {
+		decoder := json.NewDecoder(bytes.NewReader(rawData))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(ledger)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing ledger '%s': %w", path, err)
+		}
+	}
+
+	if ledger.Targets == nil {
+		ledger.Targets = map[string]*LedgerEntry{}
+	}
+	ledger.Path = path
+
+	return ledger, nil
+}
+
+// InitLedger loads the ledger in the out dir of the inventory, or of the
+// WGG_OUT_DIR env var, see OutDirPath.
+func InitLedger(inventory *Inventory) (*Ledger, error) {
+	outDir, err := OutDirPath(inventory)
+	if err != nil {
+		return nil, err
+	}
+
+	return LoadLedger(outDir + "/" + LedgerFileName)
+}
+
+// SaveLedger writes the ledger to its Path.
+func SaveLedger(ledger *Ledger) error {
+	rawData, err := json.MarshalIndent(ledger, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding ledger: %w", err)
+	}
+
+	err = stringfs.SafeWriteFileBytes(ledger.Path, append(rawData, '\n'), 0644)
+	if err != nil {
+		return errors.New("Error writing ledger '" + ledger.Path + "': " + err.Error())
+	}
+	ledger.Subnet6Generated = false
+
+	return nil
+}
+
+// AssignAddresses sets the Address of every node and client from its static
+// address, its ledger entry or, for a new target, from the policy, and
+// records it in the ledger. Entries of targets that are no longer configured
+// are marked removed.
+//
+// A named client without an entry takes over the entry of its numbered
+// target ID, as MigrateClientKeys moves its keys.
+//
+// A *ValidationError is returned instead of silently renumbering existing
+// targets, i.e. if the subnet or the policy no longer allows a recorded
+// address, a node has the endpoint recorded for another node or a static
+// address is recorded for another target.
+func AssignAddresses(
+	ledger *Ledger,
+	policy *AllocationPolicy,
+	nodeList []WggNode,
+	clientList []WggClient,
+) error {
+	validation := &ValidationError{}
+	subnet := policy.Subnet
+
+	configured := map[string]WggTarget{}
+	for _, target := range Targets(nodeList, clientList) {
+		configured[target.TargetID()] = target
+	}
+
+	for _, client := range clientList {
+		numberedID := NumberedClientID(client.ID)
+		entry, ok := ledger.Targets[numberedID]
+		if len(client.Name) > 0 && ledger.Targets[client.Name] == nil &&
+			ok && configured[numberedID] == nil {
+			ledger.Targets[client.Name] = entry
+			delete(ledger.Targets, numberedID)
+		}
+	}
+
+	// static address -> target ID
+	staticTargets := map[string]string{}
+	for _, targetID := range slices.Sorted(maps.Keys(policy.Static)) {
+		if configured[targetID] == nil {
+			validation.problem(policy.StaticVariables[targetID], "unknown target '%s'", targetID)
+		}
+		staticTargets[policy.Static[targetID].String()] = targetID
+	}
+
+	// endpoint -> target ID of the recorded nodes, including the removed
+	// ones, as a removed node renumbers the nodes after it
+	nodeEndpoints := map[string]string{}
+	for _, targetID := range slices.Sorted(maps.Keys(ledger.Targets)) {
+		entry := ledger.Targets[targetID]
+		target := configured[targetID]
+		entry.Removed = target == nil
+
+		ip := net.ParseIP(entry.Address)
+		staticTargetID, isStatic := "", false
+		if ip != nil {
+			staticTargetID, isStatic = staticTargets[ip.String()]
+		}
+
+		if ip == nil {
+			validation.problem(
+				"",
+				"%s has the invalid address '%s' in %s",
+				targetID,
+				entry.Address,
+				ledger.Path,
+			)
+		} else if isStatic && staticTargetID != targetID && !entry.Removed &&
+			policy.Static[targetID] == nil {
+			validation.problem(
+				policy.StaticVariables[staticTargetID],
+				"static address %s of %s is the address of %s in %s",
+				ip,
+				staticTargetID,
+				targetID,
+				ledger.Path,
+			)
+		} else if isStatic && staticTargetID != targetID && entry.Removed {
+			// the static address explicitly fills the hole
+			delete(ledger.Targets, targetID)
+		} else if !entry.Removed && policy.Static[targetID] == nil && !subnet.Contains(ip) {
+			validation.problem(
+				"",
+				"%s has the address %s, which is not in the subnet %s, "+
+					"changing the subnet would renumber it",
+				targetID,
+				ip,
+				subnet,
+			)
+		} else if !entry.Removed && policy.Static[targetID] == nil {
+			err := policy.Allowed(target, ip)
+			if err != nil {
+				validation.problem(
+					"",
+					"%s has the address %s, but %s, changing the allocation would renumber it",
+					targetID,
+					ip,
+					err.Error(),
+				)
+			}
+		}
+
+		if len(entry.Endpoint) > 0 {
+			nodeEndpoints[entry.Endpoint] = targetID
+		}
+	}
+
+	for i := range nodeList {
+		node := &nodeList[i]
+		entry := ledger.Targets[node.TargetID()]
+		endpoint, _ := node.NodeEndpoint(EndpointDefault)
+		if entry == nil || entry.Endpoint == endpoint {
+			continue
+		}
+
+		otherTargetID, ok := nodeEndpoints[endpoint]
+		if ok && otherTargetID != node.TargetID() {
+			validation.problem(
+				"",
+				"%s has the endpoint %s of %s, removing or reordering nodes would renumber them, "+
+					"mark removed inventory nodes with 'removed: true' instead",
+				node.TargetID(),
+				endpoint,
+				otherTargetID,
+			)
+		}
+	}
+
+	if len(validation.Problems) > 0 {
+		validation.problem(
+			"",
+			"delete the entries from %s to renumber the targets on purpose",
+			ledger.Path,
+		)
+		return validation
+	}
+
+	used := map[string]bool{}
+	for _, entry := range ledger.Targets {
+		used[net.ParseIP(entry.Address).String()] = true
+	}
+	for ip := range staticTargets {
+		used[ip] = true
+	}
+
+	assign := func(target WggTarget, id int) (net.IP, error) {
+		ip := policy.Static[target.TargetID()]
+		entry := ledger.Targets[target.TargetID()]
+		if ip == nil && entry != nil {
+			ip = net.ParseIP(entry.Address)
+			if ipv4 := ip.To4(); ipv4 != nil {
+				ip = ipv4
+			}
+		} else if ip == nil {
+			var err error
+			ip, err = policy.Allocate(target, id, used)
+			if err != nil {
+				return nil, err
+			}
+			used[ip.String()] = true
+		}
+
+		if entry == nil {
+			entry = &LedgerEntry{}
+			ledger.Targets[target.TargetID()] = entry
+		}
+		entry.Address = ip.String()
+
+		return ip, nil
+	}
+
+	for i := range nodeList {
+		ip, err := assign(nodeList[i], nodeList[i].ID)
+		if err != nil {
+			return err
+		}
+		nodeList[i].Address = ip
+
+		endpoint, _ := nodeList[i].NodeEndpoint(EndpointDefault)
+		ledger.Targets[nodeList[i].TargetID()].Endpoint = endpoint
+	}
+	for i := range clientList {
+		ip, err := assign(clientList[i], clientList[i].ID)
+		if err != nil {
+			return err
+		}
+		clientList[i].Address = ip
+	}
+
+	ledger.Subnet = subnet.String()
+
+	return nil
+}
+
+// RecordLedgerKeys records the public key of every target that has one in
+// the ledger. It returns a *ValidationError if a target has the recorded key
+// of another target, i.e. the targets were renumbered.
+//
+// It is run before the configs are generated to refuse renumbered targets,
+// and afterwards to record the keys generated on the way.
+func RecordLedgerKeys(ledger *Ledger, keyStore KeyStore, targets []WggTarget) error {
+	validation := &ValidationError{}
+
+	// public key -> target ID
+	keyOwners := map[string]string{}
+	for targetID, entry := range ledger.Targets {
+		if len(entry.PublicKey) > 0 {
+			keyOwners[entry.PublicKey] = targetID
+		}
+	}
+
+	for _, target := range targets {
+		entry := ledger.Targets[target.TargetID()]
+		if entry == nil {
+			continue
+		}
+
+		publicKey, err := keyStore.LoadPublicKey(target.TargetID())
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("public key of target '%s': %w", target.TargetID(), err)
+		} else if publicKey == entry.PublicKey {
+			continue
+		}
+
+		otherTargetID, ok := keyOwners[publicKey]
+		if ok && otherTargetID != target.TargetID() {
+			validation.problem(
+				"",
+				"%s has the public key of %s in %s, the targets were renumbered",
+				target.TargetID(),
+				otherTargetID,
+				ledger.Path,
+			)
+			continue
+		}
+
+		entry.PublicKey = publicKey
+	}
+
+	if len(validation.Problems) > 0 {
+		return validation
+	}
+
+	return nil
+}
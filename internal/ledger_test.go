@@ -0,0 +1,147 @@
+package wgg
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestAssignAddresses(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	path := filepath.Join(t.TempDir(), LedgerFileName)
+
+	ledger, err := LoadLedger(path)
+	if err != nil {
+		t.Fatalf("did not expect error for a missing ledger, but got %v", err)
+	}
+
+	nodeList := []WggNode{
+		{ID: 0, Host: "192.0.2.1", Port: 55333},
+		{ID: 1, Host: "192.0.2.2", Port: 55333},
+	}
+	clientList := []WggClient{NewWggClient(0), NewWggClient(1)}
+
+	err = AssignAddresses(ledger, &AllocationPolicy{Subnet: subnet}, nodeList, clientList)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	if nodeList[1].WireGuardSubnetIP(subnet).String() != "10.10.10.2" ||
+		clientList[1].WireGuardSubnetIP(subnet).String() != "10.10.10.253" {
+		t.Errorf("expected the derived addresses, but got %+v, %+v", nodeList, clientList)
+	}
+
+	err = SaveLedger(ledger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger, err = LoadLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// c0 is removed, c1 keeps its address and the hole of c0 stays free
+	clientList = []WggClient{NewWggClient(1), NewWggClient(2)}
+	err = AssignAddresses(ledger, &AllocationPolicy{Subnet: subnet}, nodeList, clientList)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	if clientList[0].WireGuardSubnetIP(subnet).String() != "10.10.10.253" ||
+		clientList[1].WireGuardSubnetIP(subnet).String() != "10.10.10.252" {
+		t.Errorf("expected c1 to keep its address, but got %+v", clientList)
+	}
+	if !ledger.Targets["c0"].Removed {
+		t.Errorf("expected c0 to be marked removed, but got %+v", ledger.Targets["c0"])
+	}
+
+	clientList = []WggClient{{ID: 0, Name: "alice"}, {ID: 1}, {ID: 2}}
+	err = AssignAddresses(ledger, &AllocationPolicy{Subnet: subnet}, nodeList, clientList)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	if clientList[0].WireGuardSubnetIP(subnet).String() != "10.10.10.254" || ledger.Targets["c0"] != nil {
+		t.Errorf("expected alice to take over the entry of c0, but got %+v", clientList)
+	}
+
+	// a new node whose derived address is taken gets the next free one
+	ledger.Targets["n2"] = &LedgerEntry{Address: "10.10.10.3", Removed: true}
+	nodeList = append(nodeList, WggNode{ID: 3, Host: "192.0.2.4", Port: 55333})
+	err = AssignAddresses(ledger, &AllocationPolicy{Subnet: subnet}, nodeList, clientList)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	if nodeList[2].WireGuardSubnetIP(subnet).String() != "10.10.10.4" {
+		t.Errorf("expected n3 to get 10.10.10.4, but got %v", nodeList[2].Address)
+	}
+}
+
+func TestAssignAddressesRenumbering(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	ledger := &Ledger{
+		Subnet: "10.10.10.0/24",
+		Targets: map[string]*LedgerEntry{
+			"n0": {Address: "10.10.10.1", Endpoint: "192.0.2.1:55333"},
+			"n1": {Address: "10.10.10.2", Endpoint: "192.0.2.2:55333"},
+			"c0": {Address: "10.10.10.254"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		subnet   string
+		nodeList []WggNode
+	}{
+		{"removed node", "10.10.10.0/24", []WggNode{{ID: 0, Host: "192.0.2.2", Port: 55333}}},
+		{"changed subnet", "10.20.0.0/24", []WggNode{{ID: 0, Host: "192.0.2.1", Port: 55333}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, subnet, _ := net.ParseCIDR(test.subnet)
+			err := AssignAddresses(ledger, &AllocationPolicy{Subnet: subnet}, test.nodeList, []WggClient{NewWggClient(0)})
+
+			var validation *ValidationError
+			if !errors.As(err, &validation) {
+				t.Errorf("expected a ValidationError, but got %v", err)
+			}
+		})
+	}
+
+	// a changed endpoint of the same node and a bigger subnet are fine
+	_, subnet, _ = net.ParseCIDR("10.10.0.0/16")
+	nodeList := []WggNode{{ID: 0, Host: "192.0.2.9", Port: 55333}, {ID: 1, Host: "192.0.2.2", Port: 55333}}
+	err := AssignAddresses(ledger, &AllocationPolicy{Subnet: subnet}, nodeList, []WggClient{NewWggClient(0)})
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	if ledger.Targets["n0"].Endpoint != "192.0.2.9:55333" {
+		t.Errorf("expected the new endpoint to be recorded, but got %+v", ledger.Targets["n0"])
+	}
+}
+
+func TestRecordLedgerKeys(t *testing.T) {
+	keyStore := NewFileKeyStore(t.TempDir(), nil)
+	_, publicKey0, _ := keyStore.InitKeyPair("c0")
+	_, publicKey1, _ := keyStore.InitKeyPair("c1")
+
+	ledger := &Ledger{Targets: map[string]*LedgerEntry{
+		"c0": {Address: "10.10.10.254"},
+		"c1": {Address: "10.10.10.253", PublicKey: publicKey0},
+	}}
+
+	err := RecordLedgerKeys(ledger, keyStore, []WggTarget{NewWggClient(0), NewWggClient(1)})
+
+	var validation *ValidationError
+	if !errors.As(err, &validation) || len(validation.Problems) != 1 {
+		t.Errorf("expected c0 to be reported with the key recorded for c1, but got %v", err)
+	}
+
+	ledger.Targets["c1"].PublicKey = ""
+	err = RecordLedgerKeys(ledger, keyStore, []WggTarget{NewWggClient(0), NewWggClient(1)})
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	if ledger.Targets["c0"].PublicKey != publicKey0 || ledger.Targets["c1"].PublicKey != publicKey1 {
+		t.Errorf("expected the public keys to be recorded, but got %+v", ledger.Targets)
+	}
+}
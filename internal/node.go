@@ -15,23 +15,74 @@ type WggTarget interface {
 	WireGuardSubnetIP(*net.IPNet) net.IP
 	NodePort() int
 	NodePubIp() *net.IP
+	NodeHost() string
+	NodeEndpoint(EndpointPreference) (string, error)
+	EndpointPreference() EndpointPreference
+}
+
+// EndpointPreference selects which endpoint of a node with an IPv4 and an
+// IPv6 endpoint is written into a [Peer] section.
+type EndpointPreference string
+
+const (
+	// EndpointDefault uses the IPv4 or hostname endpoint if the node has
+	// one, otherwise the IPv6 endpoint.
+	EndpointDefault EndpointPreference = ""
+	// EndpointV4 requires the IPv4 or hostname endpoint.
+	EndpointV4 EndpointPreference = "v4"
+	// EndpointV6 requires the IPv6 endpoint.
+	EndpointV6 EndpointPreference = "v6"
+	// EndpointV6First uses the IPv6 endpoint if the node has one, otherwise
+	// the IPv4 or hostname endpoint.
+	EndpointV6First EndpointPreference = "v6-first"
+)
+
+// ParseEndpointPreference parses "", "v4", "v6" or "v6-first".
+func ParseEndpointPreference(value string) (EndpointPreference, error) {
+	preference := EndpointPreference(value)
+	switch preference {
+	case EndpointDefault, EndpointV4, EndpointV6, EndpointV6First:
+		return preference, nil
+	}
+
+	return EndpointDefault, errors.New(
+		"invalid endpoint preference '" + value + "', expected v4, v6 or v6-first",
+	)
 }
 
 type WggNode struct {
 	WggTarget
 
-	ID    int
+	ID int
+
+	// Host is the public IPv4 address or hostname of the node as configured,
+	// Host6 its public IPv6 address. At least one of both is set.
+	// PubIp is the parsed IP address of NodeHost, or nil for a hostname.
+	Host  string
+	Host6 string
 	PubIp *net.IP
 	Port  int
+
+	// Address is the WireGuard address assigned by the Ledger, if any.
+	Address net.IP
+
+	// Meta holds free-form metadata from the inventory.
+	Meta map[string]string
 }
 
 // NewWggNode parses a raw node data string into a WggNode.
 //
-// The raw node data string should be in the format of "<ip>:<port>".
+// The raw node data string should be in the format of "<ip>:<port>" or
+// "<hostname>:<port>". A node with an IPv4 and an IPv6 endpoint lists both,
+// separated by a comma, e.g. "192.0.2.1:55333,[2001:db8::1]:55333". Both
+// must use the same port, as a node only listens on one.
 //
 // The returned WggNode's ID is set to the given ID argument.
 //
-// The returned WggNode's PubIp is set to the parsed IP address.
+// The returned WggNode's Host is set to the IPv4 address or hostname as
+// given, its Host6 to the IPv6 address.
+// The returned WggNode's PubIp is set to the parsed IP address of NodeHost,
+// or nil for a hostname.
 // The returned WggNode's Port is set to the parsed port number.
 //
 // If the raw node data is invalid, an error is returned.
@@ -39,38 +90,82 @@ func NewWggNode(
 	id int,
 	rawData string,
 ) (WggNode, error) {
-	host, portStr, err := net.SplitHostPort(rawData)
-	if err != nil {
+	node := WggNode{
+		ID: id,
+	}
+
+	endpoints := SplitList(rawData)
+	if len(endpoints) == 0 || len(endpoints) > 2 {
 		return WggNode{}, errors.New(
 			"general invalid raw node data: '" +
-				rawData + "': " +
-				err.Error(),
+				rawData + "': expected one or two endpoints",
 		)
 	}
 
-	ip := net.ParseIP(host)
-	if ip == nil {
-		return WggNode{}, errors.New(
-			"invalid ip in raw node data: '" +
-				rawData + "'",
-		)
+	for i, endpoint := range endpoints {
+		host, portStr, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			return WggNode{}, errors.New(
+				"general invalid raw node data: '" +
+					rawData + "': " +
+					err.Error(),
+			)
+		}
+
+		ip := net.ParseIP(host)
+		if ip == nil && !netutils.IsHostname(host) {
+			return WggNode{}, errors.New(
+				"invalid ip or hostname in raw node data: '" +
+					rawData + "'",
+			)
+		}
+
+		if ip != nil && ip.To4() == nil {
+			if len(node.Host6) > 0 {
+				return WggNode{}, errors.New(
+					"more than one IPv6 endpoint in raw node data: '" +
+						rawData + "'",
+				)
+			}
+			node.Host6 = host
+		} else {
+			if len(node.Host) > 0 {
+				return WggNode{}, errors.New(
+					"more than one IPv4 or hostname endpoint in raw node data: '" +
+						rawData + "'",
+				)
+			}
+			node.Host = host
+		}
+
+		// Parse the port
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return WggNode{}, errors.New(
+				"invalid port in raw node data: '" +
+					rawData + "': " +
+					err.Error(),
+			)
+		} else if port < 1 || port > 65535 {
+			return WggNode{}, errors.New(
+				"port out of range 1-65535 in raw node data: '" +
+					rawData + "'",
+			)
+		} else if i > 0 && port != node.Port {
+			return WggNode{}, errors.New(
+				"different ports in raw node data: '" +
+					rawData + "'",
+			)
+		}
+		node.Port = port
 	}
 
-	// Parse the port
-	port, err := strconv.Atoi(portStr)
-	if err != nil {
-		return WggNode{}, errors.New(
-			"invalid port in raw node data: '" +
-				rawData + "': " +
-				err.Error(),
-		)
+	ip := net.ParseIP(node.NodeHost())
+	if ip != nil {
+		node.PubIp = &ip
 	}
 
-	return WggNode{
-		ID:    id,
-		PubIp: &ip,
-		Port:  port,
-	}, nil
+	return node, nil
 }
 
 // TargetID returns the node's target ID, which is in the format of "N<id>".
@@ -86,9 +181,13 @@ func (node WggNode) IsNode() bool {
 // WireGuardSubnetIP returns an IP address in the given subnet that is
 // appropriate for the current node to use as its WireGuard IP address.
 //
-// The returned IP address is the given subnet's IP address incremented by
-// the node's ID plus one.
+// The returned IP address is the node's assigned Address, or the given
+// subnet's IP address incremented by the node's ID plus one.
 func (node WggNode) WireGuardSubnetIP(subnet *net.IPNet) net.IP {
+	if node.Address != nil {
+		return node.Address
+	}
+
 	return netutils.IncrementIP(subnet.IP, node.ID+1)
 }
 
@@ -98,7 +197,61 @@ func (node WggNode) NodePort() int {
 	return node.Port
 }
 
-// NodePubIp returns the public IP address for the current node.
+// NodePubIp returns the public IP address for the current node, or nil if
+// the node is configured by hostname.
 func (node WggNode) NodePubIp() *net.IP {
 	return node.PubIp
 }
+
+// NodeHost returns the public hostname or IP address for the current node,
+// preferring Host over Host6.
+func (node WggNode) NodeHost() string {
+	if len(node.Host) > 0 {
+		return node.Host
+	} else if len(node.Host6) > 0 {
+		return node.Host6
+	} else if node.PubIp != nil {
+		return node.PubIp.String()
+	}
+
+	return ""
+}
+
+// NodeEndpoint returns the "host:port" endpoint of the current node that
+// matches the given preference. IPv6 addresses are enclosed in brackets.
+//
+// An error is returned if the preference requires an endpoint the node does
+// not have.
+func (node WggNode) NodeEndpoint(preference EndpointPreference) (string, error) {
+	host := node.NodeHost()
+
+	switch preference {
+	case EndpointV4:
+		host = node.Host
+		if len(host) <= 0 && node.PubIp != nil && node.PubIp.To4() != nil {
+			host = node.PubIp.String()
+		}
+	case EndpointV6:
+		host = node.Host6
+	case EndpointV6First:
+		if len(node.Host6) > 0 {
+			host = node.Host6
+		}
+	}
+
+	if len(host) <= 0 {
+		return "", fmt.Errorf(
+			"node '%s' has no endpoint for preference '%s'",
+			node.TargetID(),
+			preference,
+		)
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(node.Port)), nil
+}
+
+// EndpointPreference returns EndpointDefault, nodes peer with each other
+// via their default endpoints.
+func (node WggNode) EndpointPreference() EndpointPreference {
+	return EndpointDefault
+}
@@ -0,0 +1,154 @@
+package wgg
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestNewWggNode(t *testing.T) {
+	tests := []struct {
+		rawData      string
+		expectedHost string
+		expectIP     bool
+		expectError  bool
+	}{
+		{"192.0.2.1:55333", "192.0.2.1", true, false},
+		{"vpn1.example.com:55333", "vpn1.example.com", false, false},
+		{"vpn1:55333", "vpn1", false, false},
+		{"vpn1.example.com.:55333", "vpn1.example.com.", false, false},
+		{"192.168.1.300:55333", "", false, true},
+		{"vpn1.example.com", "", false, true},
+		{"vpn1.example.com:port", "", false, true},
+		{"[2001:db8::1]:55333", "2001:db8::1", true, false},
+		{"192.0.2.1:55333,[2001:db8::1]:55333", "192.0.2.1", true, false},
+		{"[2001:db8::1]:55333, vpn1.example.com:55333", "vpn1.example.com", false, false},
+		{"192.0.2.1:55333,[2001:db8::1]:55334", "", false, true},
+		{"192.0.2.1:55333,192.0.2.2:55333", "", false, true},
+		{"[2001:db8::1]:55333,[2001:db8::2]:55333", "", false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.rawData, func(t *testing.T) {
+			node, err := NewWggNode(0, test.rawData)
+			if test.expectError {
+				if err == nil {
+					t.Errorf("expected error for %s, but got none", test.rawData)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("did not expect error for %s, but got %v", test.rawData, err)
+			}
+
+			if node.NodeHost() != test.expectedHost {
+				t.Errorf("expected host %s, but got %s", test.expectedHost, node.NodeHost())
+			}
+			if (node.PubIp != nil) != test.expectIP {
+				t.Errorf("expected PubIp to be set %v, but got %v", test.expectIP, node.PubIp)
+			}
+		})
+	}
+}
+
+func TestNodeEndpoint(t *testing.T) {
+	dualStack, err := NewWggNode(0, "192.0.2.1:55333,[2001:db8::1]:55333")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v4Only, err := NewWggNode(1, "192.0.2.2:55333")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v6Only, err := NewWggNode(2, "[2001:db8::2]:55333")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostname, err := NewWggNode(3, "vpn1.example.com:55333")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		node        WggNode
+		preference  EndpointPreference
+		expected    string
+		expectError bool
+	}{
+		{dualStack, EndpointDefault, "192.0.2.1:55333", false},
+		{dualStack, EndpointV4, "192.0.2.1:55333", false},
+		{dualStack, EndpointV6, "[2001:db8::1]:55333", false},
+		{dualStack, EndpointV6First, "[2001:db8::1]:55333", false},
+		{v4Only, EndpointV6First, "192.0.2.2:55333", false},
+		{v4Only, EndpointV6, "", true},
+		{v6Only, EndpointDefault, "[2001:db8::2]:55333", false},
+		{v6Only, EndpointV4, "", true},
+		{hostname, EndpointDefault, "vpn1.example.com:55333", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.node.TargetID()+"/"+string(test.preference), func(t *testing.T) {
+			endpoint, err := test.node.NodeEndpoint(test.preference)
+			if test.expectError {
+				if err == nil {
+					t.Errorf("expected error, but got endpoint %s", endpoint)
+				}
+			} else if err != nil {
+				t.Errorf("did not expect error, but got %v", err)
+			} else if endpoint != test.expected {
+				t.Errorf("expected %s, but got %s", test.expected, endpoint)
+			}
+		})
+	}
+
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	client := WggClient{ID: 0, PreferredEndpoint: EndpointV6}
+	conf, err := GenWgClientConfPart(dualStack, NewFileKeyStore(t.TempDir(), nil), subnet, client, GenOptions{})
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	if !strings.Contains(conf, "Endpoint = [2001:db8::1]:55333\n") {
+		t.Errorf("expected the bracketed IPv6 endpoint, but got:\n%s", conf)
+	}
+}
+
+type fakeResolver map[string][]string
+
+func (resolver fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	addresses, ok := resolver[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+
+	return addresses, nil
+}
+
+func TestCheckNodeResolution(t *testing.T) {
+	nodeList := []WggNode{}
+	for i, rawData := range []string{
+		"192.0.2.1:55333",
+		"vpn1.example.com:55333",
+		"vpn2.example.com:55333",
+		"vpn3.example.com:55333",
+	} {
+		node, err := NewWggNode(i, rawData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodeList = append(nodeList, node)
+	}
+
+	resolver := fakeResolver{
+		"vpn1.example.com": {"192.0.2.2"},
+		"vpn3.example.com": {},
+	}
+
+	warnings := CheckNodeResolution(context.Background(), resolver, nodeList)
+	if len(warnings) != 2 {
+		t.Fatalf("expected 2 warnings, but got %v", warnings)
+	}
+	if !strings.Contains(warnings[0], "vpn2.example.com") || !strings.Contains(warnings[1], "vpn3.example.com") {
+		t.Errorf("expected warnings for vpn2 and vpn3, but got %v", warnings)
+	}
+}
@@ -0,0 +1,232 @@
+package wgg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CoreUnit-NET/wgg/internal/sftputils"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultNodeKeyPath is the path of the private key on a node if node-side
+// keys are enabled and WGG_NODE_KEY_PATH is not set.
+const DefaultNodeKeyPath = "/etc/wireguard/wgg.key"
+
+// NodePublicKeyPath returns the path of the public key that is written next
+// to the node-side private key at keyPath.
+func NodePublicKeyPath(keyPath string) string {
+	return strings.TrimSuffix(keyPath, ".key") + ".pub"
+}
+
+// NodeKeyCommand returns the shell command that creates the private key at
+// keyPath on a node, unless it exists already, and writes its public key to
+// NodePublicKeyPath.
+//
+// If archiveSuffix is not empty, an existing private key is moved to
+// "<keyPath>.<archiveSuffix>" first, so a fresh key is created.
+func NodeKeyCommand(keyPath string, archiveSuffix string) string {
+	key := ShellQuote(keyPath)
+
+	command := "set -e; umask 077; " +
+		"mkdir -p \"$(dirname " + key + ")\"; "
+
+	if len(archiveSuffix) > 0 {
+		command += "if [ -e " + key + " ]; then mv " + key + " " +
+			ShellQuote(keyPath+"."+archiveSuffix) + "; fi; "
+	}
+
+	return command +
+		"[ -s " + key + " ] || wg genkey > " + key + "; " +
+		"wg pubkey < " + key + " > " + ShellQuote(NodePublicKeyPath(keyPath))
+}
+
+// ShellQuote quotes the given string for a POSIX shell.
+func ShellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// InitNodeSshConfig returns the ssh config to reach the given node host.
+//
+// It is configured by the env vars WGG_SSH_USER (default "root"),
+// WGG_SSH_PORT (default 22), WGG_SSH_KEY_FILE (default "~/.ssh/id_rsa" if
+// no password is set) and WGG_SSH_PASSWORD.
+//
+// The host key of the node is verified with WGG_SSH_KNOWN_HOSTS (default
+// "~/.ssh/known_hosts"), unless WGG_SSH_HOST_KEYS pins it. WGG_SSH_HOST_KEYS
+// is a comma separated list of "<host>=<SHA256 fingerprint>". Unknown hosts
+// are refused, so no node-side key is created on an unverified host.
+func InitNodeSshConfig(host string) (*sftputils.SshConfig, error) {
+	config := &sftputils.SshConfig{
+		Host:     host,
+		User:     os.Getenv("WGG_SSH_USER"),
+		Password: os.Getenv("WGG_SSH_PASSWORD"),
+	}
+
+	portString := os.Getenv("WGG_SSH_PORT")
+	if len(portString) > 0 {
+		port, err := strconv.Atoi(portString)
+		if err != nil {
+			return nil, errors.New(
+				"error while parsing WGG_SSH_PORT as int: value '" +
+					portString + "': " +
+					err.Error(),
+			)
+		}
+		config.Port = port
+	}
+
+	keyFile := os.Getenv("WGG_SSH_KEY_FILE")
+	if len(keyFile) <= 0 && len(config.Password) <= 0 {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, errors.New("cant get users home dir: " + err.Error())
+		}
+		keyFile = homeDir + "/.ssh/id_rsa"
+	}
+
+	if len(keyFile) > 0 {
+		privateKey, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, errors.New("error reading ssh private key: " + err.Error())
+		}
+		config.PrivateKey = string(privateKey)
+	}
+
+	for _, entry := range SplitList(os.Getenv("WGG_SSH_HOST_KEYS")) {
+		entryHost, fingerprint, ok := strings.Cut(entry, "=")
+		if !ok || len(strings.TrimSpace(fingerprint)) <= 0 {
+			return nil, errors.New(
+				"invalid entry '" + entry + "' in WGG_SSH_HOST_KEYS, expected <host>=<SHA256 fingerprint>",
+			)
+		} else if strings.TrimSpace(entryHost) == host {
+			config.HostKeyFingerprint = strings.TrimSpace(fingerprint)
+		}
+	}
+
+	config.KnownHostsFile = os.Getenv("WGG_SSH_KNOWN_HOSTS")
+	if len(config.KnownHostsFile) <= 0 {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, errors.New("cant get users home dir: " + err.Error())
+		}
+		config.KnownHostsFile = homeDir + "/.ssh/known_hosts"
+	}
+
+	return config, nil
+}
+
+// FetchNodePublicKey creates the key pair of a node on the node itself via
+// NodeKeyCommand and downloads only the public key. The private key never
+// leaves the node.
+func FetchNodePublicKey(
+	sshConfig *sftputils.SshConfig,
+	keyPath string,
+	archiveSuffix string,
+) (string, error) {
+	var publicKey string
+
+	err := sftputils.HandleSftp(
+		sshConfig,
+		func(sftp *sftp.Client, session *ssh.Session) error {
+			output, err := session.CombinedOutput(NodeKeyCommand(keyPath, archiveSuffix))
+			if err != nil {
+				return errors.New(
+					"error creating node key: " + err.Error() + ": " +
+						strings.TrimSpace(string(output)),
+				)
+			}
+
+			file, err := sftp.Open(NodePublicKeyPath(keyPath))
+			if err != nil {
+				return errors.New("error opening node public key: " + err.Error())
+			}
+			defer file.Close()
+
+			rawData, err := io.ReadAll(file)
+			if err != nil {
+				return errors.New("error reading node public key: " + err.Error())
+			}
+
+			publicKey = strings.TrimSpace(string(rawData))
+
+			return nil
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = ParseWireGuardKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid node public key: %w", err)
+	}
+
+	return publicKey, nil
+}
+
+// SyncNodeKeys makes sure the keyStore holds the public key of every node
+// whose private key is kept on the node.
+//
+// Nodes without a public key in the keyStore get their key pair created on
+// the node. Nodes in rotated get their old keys archived, locally via
+// KeyStore.ArchiveKeys and on the node next to the key, and a fresh key pair
+// created on the node. The local keys are only archived once the new public
+// key is fetched, so a node that cannot be reached keeps its key.
+func SyncNodeKeys(
+	keyStore KeyStore,
+	nodeList []WggNode,
+	rotated []WggTarget,
+	keyPath string,
+	now time.Time,
+) error {
+	rotatedIDs := map[string]bool{}
+	for _, target := range rotated {
+		rotatedIDs[target.TargetID()] = true
+	}
+
+	for _, node := range nodeList {
+		archiveSuffix := ""
+		if rotatedIDs[node.TargetID()] {
+			archiveSuffix = ArchiveTimestamp(now)
+		} else {
+			_, err := keyStore.LoadPublicKey(node.TargetID())
+			if err == nil {
+				continue
+			} else if !errors.Is(err, ErrKeyNotFound) {
+				return fmt.Errorf("public key of node '%s': %w", node.TargetID(), err)
+			}
+		}
+
+		sshConfig, err := InitNodeSshConfig(node.NodeHost())
+		if err != nil {
+			return err
+		}
+
+		publicKey, err := FetchNodePublicKey(sshConfig, keyPath, archiveSuffix)
+		if err != nil {
+			return fmt.Errorf("error fetching public key of node '%s': %w", node.TargetID(), err)
+		}
+
+		if len(archiveSuffix) > 0 {
+			err = keyStore.ArchiveKeys(node.TargetID(), now)
+			if err != nil {
+				return fmt.Errorf("error archiving keys of node '%s': %w", node.TargetID(), err)
+			}
+		}
+
+		err = keyStore.SavePublicKey(node.TargetID(), publicKey)
+		if err != nil {
+			return fmt.Errorf("error saving public key of node '%s': %w", node.TargetID(), err)
+		}
+
+		fmt.Println("Fetched public key of node " + node.TargetID())
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package wgg
+
+import (
+	"crypto/ed25519"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestNodeKeyCommand(t *testing.T) {
+	command := NodeKeyCommand("/etc/wireguard/wgg.key", "")
+	expected := "set -e; umask 077; mkdir -p \"$(dirname '/etc/wireguard/wgg.key')\"; " +
+		"[ -s '/etc/wireguard/wgg.key' ] || wg genkey > '/etc/wireguard/wgg.key'; " +
+		"wg pubkey < '/etc/wireguard/wgg.key' > '/etc/wireguard/wgg.pub'"
+	if command != expected {
+		t.Errorf("expected %q, but got %q", expected, command)
+	}
+
+	command = NodeKeyCommand("/tmp/it's.key", "20240102T030405Z")
+	if !strings.Contains(command, "mv '/tmp/it'\\''s.key' '/tmp/it'\\''s.key.20240102T030405Z'") {
+		t.Errorf("expected a quoted archive move, but got %q", command)
+	}
+}
+
+func TestGenWgClientConfPartNodeSideKeys(t *testing.T) {
+	keyStore := NewFileKeyStore(t.TempDir(), nil)
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	ip := net.ParseIP("192.0.2.1")
+	node := WggNode{ID: 0, PubIp: &ip, Port: 55333}
+	options := GenOptions{NodeSideKeys: true, NodeKeyPath: DefaultNodeKeyPath}
+
+	conf, err := GenWgClientConfPart(node, keyStore, subnet, node, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	if strings.Contains(conf, "PrivateKey") {
+		t.Errorf("expected no private key in the node config, but got:\n%s", conf)
+	}
+	if !strings.Contains(conf, "PostUp = wg set %i private-key '/etc/wireguard/wgg.key'\n") {
+		t.Errorf("expected a PostUp line that loads the key, but got:\n%s", conf)
+	}
+
+	quotedOptions := GenOptions{NodeSideKeys: true, NodeKeyPath: "/etc/wire guard/it's.key; reboot"}
+	conf, err = GenWgClientConfPart(node, keyStore, subnet, node, quotedOptions)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	} else if !strings.Contains(conf, "PostUp = wg set %i private-key '/etc/wire guard/it'\\''s.key; reboot'\n") {
+		t.Errorf("expected a quoted key path, but got:\n%s", conf)
+	}
+
+	_, err = GenWgClientConfPart(node, keyStore, subnet, WggClient{ID: 0}, options)
+	if err == nil {
+		t.Errorf("expected error for a node without fetched public key, but got none")
+	}
+
+	err = keyStore.SavePublicKey(node.TargetID(), "hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo=")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err = GenWgClientConfPart(node, keyStore, subnet, WggClient{ID: 0}, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	} else if !strings.Contains(conf, "PublicKey = hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo=\n") {
+		t.Errorf("expected the fetched public key, but got:\n%s", conf)
+	}
+}
+
+func TestInitNodeSshConfigHostKeys(t *testing.T) {
+	dir := t.TempDir()
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublicKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ssh.NewPublicKey(otherPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	knownHosts := knownhosts.Line([]string{"192.0.2.1"}, hostKey) + "\n"
+	err = os.WriteFile(dir+"/known_hosts", []byte(knownHosts), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("WGG_SSH_PASSWORD", "secret")
+	t.Setenv("WGG_SSH_KNOWN_HOSTS", dir+"/known_hosts")
+	t.Setenv("WGG_SSH_HOST_KEYS", "192.0.2.2="+ssh.FingerprintSHA256(hostKey))
+
+	for _, test := range []struct {
+		host     string
+		key      ssh.PublicKey
+		verified bool
+	}{
+		{"192.0.2.1", hostKey, true},
+		{"192.0.2.1", otherKey, false},
+		{"192.0.2.2", hostKey, true},
+		{"192.0.2.2", otherKey, false},
+		{"192.0.2.3", hostKey, false},
+	} {
+		config, err := InitNodeSshConfig(test.host)
+		if err != nil {
+			t.Fatalf("did not expect error, but got %v", err)
+		}
+
+		callback, err := config.HostKeyCallback()
+		if err != nil {
+			t.Fatalf("did not expect error, but got %v", err)
+		}
+
+		remote := &net.TCPAddr{IP: net.ParseIP(test.host), Port: 22}
+		err = callback(test.host+":22", remote, test.key)
+		if test.verified && err != nil {
+			t.Errorf("expected the host key of %s to be verified, but got %v", test.host, err)
+		} else if !test.verified && err == nil {
+			t.Errorf("expected the host key of %s to be refused, but got none", test.host)
+		}
+	}
+
+	t.Setenv("WGG_SSH_HOST_KEYS", "192.0.2.1")
+	_, err = InitNodeSshConfig("192.0.2.1")
+	if err == nil {
+		t.Errorf("expected error for an invalid WGG_SSH_HOST_KEYS entry, but got none")
+	}
+}
+
+func TestSyncNodeKeysUnreachableNode(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	t.Setenv("WGG_SSH_PASSWORD", "secret")
+	t.Setenv("WGG_SSH_PORT", strconv.Itoa(listener.Addr().(*net.TCPAddr).Port))
+	t.Setenv("WGG_SSH_HOST_KEYS", "127.0.0.1=SHA256:unknown")
+
+	keyDir := t.TempDir()
+	keyStore := NewFileKeyStore(keyDir, nil)
+	node := WggNode{ID: 0, Host: "127.0.0.1", Port: 55333}
+	_, publicKey, err := GenerateWireGuardKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = keyStore.SavePublicKey(node.TargetID(), publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SyncNodeKeys(keyStore, []WggNode{node}, []WggTarget{node}, DefaultNodeKeyPath, time.Now())
+	if err == nil {
+		t.Fatalf("expected error for an unreachable node, but got none")
+	}
+
+	kept, err := keyStore.LoadPublicKey(node.TargetID())
+	if err != nil || kept != publicKey {
+		t.Errorf("expected the public key %s to be kept, but got %q, %v", publicKey, kept, err)
+	}
+}
@@ -0,0 +1,125 @@
+package wgg
+
+import (
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// GenOptions holds the optional settings that apply to the whole config
+// generation.
+type GenOptions struct {
+	// PresharedKeys adds a PresharedKey to every [Peer] section. Each pair of
+	// targets shares its own key, identified by PresharedKeyID.
+	PresharedKeys bool
+
+	// NodeSideKeys keeps the private keys of the nodes on the nodes. The
+	// node configs load the key from NodeKeyPath via PostUp instead of
+	// containing it, only the public keys are held by the KeyStore.
+	NodeSideKeys bool
+	NodeKeyPath  string
+
+	// Workers is the number of configs that are rendered concurrently.
+	Workers int
+
+	// Subnet6 is the IPv6 overlay prefix of a dual-stack overlay, see
+	// InitSubnet6, or nil. It is not read from the environment by
+	// InitGenOptions.
+	Subnet6 *net.IPNet
+
+	// Interfaces holds the InterfaceOptions of the targets, see
+	// InitInterfaceSettings. It is not read by InitGenOptions either.
+	Interfaces InterfaceSettings
+
+	// Keepalives holds the PersistentKeepalive intervals of the targets, see
+	// InitKeepaliveSettings. It is not read by InitGenOptions either.
+	Keepalives KeepaliveSettings
+
+	// Exits holds the exit nodes and the exits of the clients, see
+	// InitExitSettings. It is not read by InitGenOptions either.
+	Exits ExitSettings
+
+	// Routes holds the routed prefixes behind the nodes, see
+	// InitRouteSettings. It is not read by InitGenOptions either.
+	Routes RouteSettings
+
+	// Hubs holds the hub groups, see InitHubSettings. It is not read by
+	// InitGenOptions either.
+	Hubs HubSettings
+}
+
+// InitGenOptions reads the GenOptions from the environment.
+//
+// WGG_PRESHARED_KEYS enables per-pair preshared keys if set to a true value.
+// WGG_NODE_SIDE_KEYS enables node-side keys if set to a true value, stored
+// on the nodes at WGG_NODE_KEY_PATH (default DefaultNodeKeyPath).
+// WGG_WORKERS limits the concurrent config rendering (default the number of
+// CPUs).
+func InitGenOptions() (GenOptions, error) {
+	options := GenOptions{
+		Workers: runtime.NumCPU(),
+	}
+
+	presharedKeys, err := EnvBool("WGG_PRESHARED_KEYS")
+	if err != nil {
+		return options, err
+	}
+	options.PresharedKeys = presharedKeys
+
+	nodeSideKeys, err := EnvBool("WGG_NODE_SIDE_KEYS")
+	if err != nil {
+		return options, err
+	}
+	options.NodeSideKeys = nodeSideKeys
+
+	options.NodeKeyPath = os.Getenv("WGG_NODE_KEY_PATH")
+	if len(options.NodeKeyPath) <= 0 {
+		options.NodeKeyPath = DefaultNodeKeyPath
+	} else if !strings.HasPrefix(options.NodeKeyPath, "/") {
+		return options, errors.New(
+			"the WGG_NODE_KEY_PATH env var must be an absolute path: value '" +
+				options.NodeKeyPath + "'",
+		)
+	}
+
+	workersString := os.Getenv("WGG_WORKERS")
+	if len(workersString) > 0 {
+		workers, err := strconv.Atoi(workersString)
+		if err != nil {
+			return options, errors.New(
+				"error while parsing WGG_WORKERS as int: value '" +
+					workersString + "': " +
+					err.Error(),
+			)
+		} else if workers < 1 {
+			return options, errors.New("the WGG_WORKERS env var must be greater than 0")
+		}
+		options.Workers = workers
+	}
+
+	return options, nil
+}
+
+// EnvBool parses the env var with the given name as bool.
+//
+// An unset or empty env var is false.
+func EnvBool(name string) (bool, error) {
+	rawValue := os.Getenv(name)
+	if len(rawValue) <= 0 {
+		return false, nil
+	}
+
+	value, err := strconv.ParseBool(rawValue)
+	if err != nil {
+		return false, errors.New(
+			"error while parsing " + name + " as bool: value '" +
+				rawValue + "': " +
+				err.Error(),
+		)
+	}
+
+	return value, nil
+}
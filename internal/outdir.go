@@ -28,22 +28,48 @@ func CleanUpOutDir(outDir string) error {
 	return nil
 }
 
-// InitOutDir initializes the output directory for configuration files.
-// It retrieves the directory path from the WGG_OUT_DIR environment variable.
-// If the path is not absolute, it prefixes it with the current working directory.
-// If the directory does not exist, it attempts to create it with the appropriate permissions.
-// Returns the absolute path of the output directory or an error if any operation fails.
-func InitOutDir() (string, string, error) {
-	outDir := os.Getenv("WGG_OUT_DIR")
-	if len(outDir) <= 0 {
-		return "", "", errors.New("the WGG_OUT_DIR env var is not set or empty")
-	} else if !strings.HasPrefix(outDir, "/") {
-		outDir = FatalCwd() + "/" + outDir
+// OutDirPath returns the path of the output directory for configuration
+// files without creating it.
+// It retrieves the directory path from the inventory, or from the WGG_OUT_DIR
+// environment variable if the inventory is nil or does not set it.
+// A relative inventory path is resolved against the inventory file's
+// directory, a relative env var path against the current working directory.
+// With an active profile (WGG_PROFILE) the profile name is appended, unless
+// the env file of the profile sets WGG_OUT_DIR or WGG_INVENTORY, see
+// ProfilePath.
+func OutDirPath(inventory *Inventory) (string, error) {
+	var outDir string
+	variable := "WGG_OUT_DIR"
+	if inventory != nil && len(inventory.OutDir) > 0 {
+		variable = "WGG_INVENTORY"
+		outDir = inventory.OutDir
+		if !strings.HasPrefix(outDir, "/") {
+			outDir = inventory.Dir + "/" + outDir
+		}
+	} else {
+		outDir = os.Getenv("WGG_OUT_DIR")
+		if len(outDir) <= 0 {
+			return "", errors.New("the WGG_OUT_DIR env var is not set or empty")
+		} else if !strings.HasPrefix(outDir, "/") {
+			outDir = FatalCwd() + "/" + outDir
+		}
 	}
 
+	return ProfilePath(outDir, variable), nil
+}
+
+// InitOutDir initializes the output directory for configuration files at
+// OutDirPath.
+// If the directory does not exist, it attempts to create it with the appropriate permissions.
+// Returns the absolute path of the output directory and its key directory or an error if any operation fails.
+func InitOutDir(inventory *Inventory) (string, string, error) {
+	outDir, err := OutDirPath(inventory)
+	if err != nil {
+		return "", "", err
+	}
 	keyDir := outDir + "/keys"
 
-	_, err := os.Stat(keyDir)
+	_, err = os.Stat(keyDir)
 	if os.IsNotExist(err) {
 		err = os.MkdirAll(keyDir, 0755)
 		if err != nil {
@@ -0,0 +1,111 @@
+package wgg
+
+import (
+	"errors"
+	"os"
+	"regexp"
+
+	"github.com/joho/godotenv"
+)
+
+// profileNamePattern allows profile names that are safe as file and dir
+// names.
+var profileNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// profileEnvVars holds the env vars that LoadEnvFiles set from the env file
+// of the profile.
+var profileEnvVars = map[string]bool{}
+
+// ValidateProfileName returns an error if name can not be used as profile
+// name.
+func ValidateProfileName(name string) error {
+	if !profileNamePattern.MatchString(name) {
+		return errors.New(
+			"invalid profile name '" + name +
+				"': only letters, digits, '_', '.' and '-' are allowed",
+		)
+	}
+
+	return nil
+}
+
+// ProfileEnvFiles returns the env files of the given profile in the order
+// of increasing precedence: ".env" and, for a profile, ".env.<profile>".
+func ProfileEnvFiles(profile string) []string {
+	if len(profile) <= 0 {
+		return []string{".env"}
+	}
+
+	return []string{".env", ".env." + profile}
+}
+
+// LoadEnvFiles loads the ProfileEnvFiles of the profile into the process
+// env and returns the files it loaded.
+//
+// The precedence is: process env, then ".env.<profile>", then ".env". A
+// missing ".env" is skipped, a missing profile file is an error, so a typo
+// in the profile name can not silently use the base config. WGG_PROFILE is
+// ignored in env files.
+func LoadEnvFiles(profile string) ([]string, error) {
+	if len(profile) > 0 {
+		err := ValidateProfileName(profile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	loaded := []string{}
+	values := map[string]string{}
+	sources := map[string]string{}
+	profileEnvVars = map[string]bool{}
+
+	for _, file := range ProfileEnvFiles(profile) {
+		fileValues, err := godotenv.Read(file)
+		if os.IsNotExist(err) && file == ".env" {
+			continue
+		} else if os.IsNotExist(err) {
+			return nil, errors.New("the env file '" + file + "' of profile '" + profile + "' does not exist")
+		} else if err != nil {
+			return nil, errors.New("Error reading env file '" + file + "': " + err.Error())
+		}
+
+		for key, value := range fileValues {
+			values[key] = value
+			sources[key] = file
+		}
+		loaded = append(loaded, file)
+	}
+
+	for key, value := range values {
+		// the profile selects the env files, it can not come from one
+		if key == "WGG_PROFILE" {
+			continue
+		}
+
+		_, isSet := os.LookupEnv(key)
+		if isSet {
+			continue
+		}
+
+		err := os.Setenv(key, value)
+		if err != nil {
+			return nil, errors.New("Error setting env var '" + key + "': " + err.Error())
+		}
+		profileEnvVars[key] = sources[key] != ".env"
+	}
+
+	return loaded, nil
+}
+
+// ProfilePath appends the active profile of WGG_PROFILE to path, so every
+// profile gets its own out dir and Vault path. variable is the env var path
+// comes from, if ".env.<profile>" set it, the path belongs to the profile
+// already and is returned unchanged, as it is without profile.
+func ProfilePath(path string, variable string) string {
+	profile := os.Getenv("WGG_PROFILE")
+	if len(profile) <= 0 || profileEnvVars[variable] {
+		return path
+	}
+
+	return path + "/" + profile
+}
@@ -0,0 +1,114 @@
+package wgg
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLoadEnvFiles(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	keys := []string{"WGG_TEST_BASE", "WGG_TEST_PROFILE", "WGG_TEST_PROCESS"}
+	for _, key := range keys {
+		t.Cleanup(func() { os.Unsetenv(key) })
+	}
+	t.Setenv("WGG_PROFILE", "prod")
+	t.Setenv("WGG_TEST_PROCESS", "process")
+
+	err := os.WriteFile(".env", []byte(
+		"WGG_TEST_BASE=base\nWGG_TEST_PROFILE=base\nWGG_TEST_PROCESS=base\n",
+	), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(".env.prod", []byte(
+		"WGG_TEST_PROFILE=prod\nWGG_TEST_PROCESS=prod\nWGG_PROFILE=staging\n",
+	), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadEnvFiles("prod")
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	if len(loaded) != 2 {
+		t.Errorf("expected .env and .env.prod to be loaded, but got %v", loaded)
+	}
+
+	expected := map[string]string{
+		"WGG_TEST_BASE":    "base",
+		"WGG_TEST_PROFILE": "prod",
+		"WGG_TEST_PROCESS": "process",
+		"WGG_PROFILE":      "prod",
+	}
+	for key, value := range expected {
+		if os.Getenv(key) != value {
+			t.Errorf("expected %s=%s, but got %s", key, value, os.Getenv(key))
+		}
+	}
+
+	if ProfilePath("out", "WGG_OUT_DIR") != "out/prod" {
+		t.Errorf("expected out/prod, but got %s", ProfilePath("out", "WGG_OUT_DIR"))
+	}
+
+	_, err = LoadEnvFiles("staging")
+	if err == nil {
+		t.Errorf("expected error for a profile without env file, but got none")
+	}
+
+	_, err = LoadEnvFiles("../prod")
+	if err == nil {
+		t.Errorf("expected error for an invalid profile name, but got none")
+	}
+}
+
+func TestProfileOutDir(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("WGG_OUT_DIR", dir)
+
+	t.Setenv("WGG_PROFILE", "")
+	outDir, _, err := InitOutDir(nil)
+	if err != nil || outDir != dir {
+		t.Errorf("expected %s without profile, but got %s, %v", dir, outDir, err)
+	}
+
+	t.Setenv("WGG_PROFILE", "staging")
+	outDir, keyDir, err := InitOutDir(nil)
+	if err != nil || outDir != dir+"/staging" || keyDir != dir+"/staging/keys" {
+		t.Errorf("expected %s/staging with profile, but got %s, %s, %v", dir, outDir, keyDir, err)
+	}
+}
+
+func TestProfileOwnOutDir(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("WGG_PROFILE", "prod")
+	t.Setenv("WGG_OUT_DIR", "")
+	t.Setenv("WGG_VAULT_PATH", "")
+	os.Unsetenv("WGG_OUT_DIR")
+	os.Unsetenv("WGG_VAULT_PATH")
+	t.Cleanup(func() { profileEnvVars = map[string]bool{} })
+
+	err := os.WriteFile(".env", []byte("WGG_OUT_DIR="+dir+"/out\nWGG_VAULT_PATH=wgg\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(".env.prod", []byte("WGG_OUT_DIR="+dir+"/prod-out\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LoadEnvFiles("prod")
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	outDir, err := OutDirPath(nil)
+	if err != nil || outDir != dir+"/prod-out" {
+		t.Errorf("expected the out dir of the profile file unchanged, but got %s, %v", outDir, err)
+	}
+	if path := ProfilePath("wgg", "WGG_VAULT_PATH"); path != "wgg/prod" {
+		t.Errorf("expected the base Vault path with the profile, but got %s", path)
+	}
+}
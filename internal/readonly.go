@@ -0,0 +1,81 @@
+package wgg
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrReadOnly is returned by a ReadOnlyKeyStore for every write.
+var ErrReadOnly = errors.New("the keys are opened read-only")
+
+// ReadOnlyKeyStore gives read access to another KeyStore, for commands that
+// only show keys or configs.
+//
+// Missing keys are not created, InitKeyPair and InitPresharedKey return an
+// error wrapping ErrKeyNotFound instead. All writes fail with ErrReadOnly.
+type ReadOnlyKeyStore struct {
+	store KeyStore
+}
+
+// NewReadOnlyKeyStore returns a new ReadOnlyKeyStore in front of the given
+// KeyStore.
+func NewReadOnlyKeyStore(store KeyStore) *ReadOnlyKeyStore {
+	return &ReadOnlyKeyStore{store: store}
+}
+
+// LoadKeyPair loads the key pair from the underlying KeyStore.
+func (store *ReadOnlyKeyStore) LoadKeyPair(targetID string) (string, string, error) {
+	return store.store.LoadKeyPair(targetID)
+}
+
+// SaveKeyPair returns ErrReadOnly.
+func (store *ReadOnlyKeyStore) SaveKeyPair(string, string, string) error {
+	return ErrReadOnly
+}
+
+// LoadPublicKey loads the public key from the underlying KeyStore.
+func (store *ReadOnlyKeyStore) LoadPublicKey(targetID string) (string, error) {
+	return store.store.LoadPublicKey(targetID)
+}
+
+// SavePublicKey returns ErrReadOnly.
+func (store *ReadOnlyKeyStore) SavePublicKey(string, string) error {
+	return ErrReadOnly
+}
+
+// InitKeyPair loads the key pair, it is not created if it is missing.
+func (store *ReadOnlyKeyStore) InitKeyPair(targetID string) (string, string, error) {
+	privateKey, publicKey, err := store.store.LoadKeyPair(targetID)
+	if errors.Is(err, ErrKeyNotFound) {
+		return "", "", fmt.Errorf("no key pair yet, run generate first: %w", err)
+	}
+
+	return privateKey, publicKey, err
+}
+
+// LoadPresharedKey loads the preshared key from the underlying KeyStore.
+func (store *ReadOnlyKeyStore) LoadPresharedKey(pairID string) (string, error) {
+	return store.store.LoadPresharedKey(pairID)
+}
+
+// InitPresharedKey loads the preshared key, it is not created if it is
+// missing.
+func (store *ReadOnlyKeyStore) InitPresharedKey(pairID string) (string, error) {
+	presharedKey, err := store.store.LoadPresharedKey(pairID)
+	if errors.Is(err, ErrKeyNotFound) {
+		return "", fmt.Errorf("no preshared key yet, run generate first: %w", err)
+	}
+
+	return presharedKey, err
+}
+
+// SavePresharedKey returns ErrReadOnly.
+func (store *ReadOnlyKeyStore) SavePresharedKey(string, string) error {
+	return ErrReadOnly
+}
+
+// ArchiveKeys returns ErrReadOnly.
+func (store *ReadOnlyKeyStore) ArchiveKeys(string, time.Time) error {
+	return ErrReadOnly
+}
@@ -0,0 +1,69 @@
+package wgg
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestInitReadOnlyKeyStore(t *testing.T) {
+	keyDir := t.TempDir()
+	t.Setenv("WGG_KEYSTORE", "")
+	t.Setenv("WGG_ENCRYPT_KEYS", "true")
+	t.Setenv("WGG_KEY_PASSPHRASE", "secret")
+	t.Setenv("WGG_MASTER_SEED", "")
+	t.Setenv("WGG_MASTER_SEED_FILE", "")
+
+	store, err := InitReadOnlyKeyStore(keyDir)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	_, _, err = store.InitKeyPair("n0")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound for a missing key pair, but got %v", err)
+	}
+	_, err = store.InitPresharedKey(PresharedKeyID("n0", "c0"))
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound for a missing preshared key, but got %v", err)
+	}
+
+	files, err := os.ReadDir(keyDir)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(files) != 0 {
+		t.Errorf("expected the read-only key store to write nothing, but got %v", files)
+	}
+
+	writable, err := InitKeyStore(keyDir)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	privateKey, _, err := writable.InitKeyPair("n0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = InitReadOnlyKeyStore(keyDir)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	loadedPrivateKey, _, err := store.InitKeyPair("n0")
+	if err != nil || loadedPrivateKey != privateKey {
+		t.Errorf("expected the existing encrypted key pair, but got %v", err)
+	}
+
+	err = store.ArchiveKeys("n0", time.Now())
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, but got %v", err)
+	}
+
+	t.Setenv("WGG_MASTER_SEED_FILE", keyDir+"/missing.seed")
+	_, err = InitReadOnlyKeyStore(keyDir)
+	if err == nil {
+		t.Errorf("expected error for a missing master seed file, but got none")
+	} else if _, err := os.Stat(keyDir + "/missing.seed"); !os.IsNotExist(err) {
+		t.Errorf("expected no master seed file to be created")
+	}
+}
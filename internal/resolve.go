@@ -0,0 +1,58 @@
+package wgg
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ResolveTimeout limits the lookup of a single node hostname.
+const ResolveTimeout = 5 * time.Second
+
+// Resolver looks up the addresses of a hostname. *net.Resolver implements
+// it, tests use a fake.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// CheckNodeResolution resolves the hostname of every node that is not
+// configured by IP address and returns a warning for each name that does not
+// resolve.
+//
+// Unresolvable names are not an error, a DDNS name may only resolve once the
+// node is up.
+func CheckNodeResolution(
+	ctx context.Context,
+	resolver Resolver,
+	nodeList []WggNode,
+) []string {
+	warnings := []string{}
+
+	for _, node := range nodeList {
+		if node.PubIp != nil || net.ParseIP(node.NodeHost()) != nil {
+			continue
+		}
+
+		lookupCtx, cancel := context.WithTimeout(ctx, ResolveTimeout)
+		addresses, err := resolver.LookupHost(lookupCtx, node.NodeHost())
+		cancel()
+
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf(
+				"node %s: hostname '%s' does not resolve: %s",
+				node.TargetID(),
+				node.NodeHost(),
+				err.Error(),
+			))
+		} else if len(addresses) == 0 {
+			warnings = append(warnings, fmt.Sprintf(
+				"node %s: hostname '%s' resolves to no address",
+				node.TargetID(),
+				node.NodeHost(),
+			))
+		}
+	}
+
+	return warnings
+}
@@ -0,0 +1,187 @@
+package wgg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SelectTargets returns the targets matching the given selector.
+//
+// The selector is either "all", "nodes", "clients" or a single target ID
+// like "n0" or "c3".
+func SelectTargets(
+	selector string,
+	nodeList []WggNode,
+	clientList []WggClient,
+) ([]WggTarget, error) {
+	switch selector {
+	case "all":
+		return Targets(nodeList, clientList), nil
+	case "nodes":
+		return Targets(nodeList, nil), nil
+	case "clients":
+		return Targets(nil, clientList), nil
+	}
+
+	for _, target := range Targets(nodeList, clientList) {
+		if target.TargetID() == selector {
+			return []WggTarget{target}, nil
+		}
+	}
+
+	return nil, errors.New("unknown target '" + selector + "'")
+}
+
+// AffectedTargets returns the targets whose configs change if the keys of
+// the rotated targets change.
+//
+// Every node peers with every target, so a rotated node affects everyone.
+// A rotated client only affects itself and the nodes.
+func AffectedTargets(
+	rotated []WggTarget,
+	nodeList []WggNode,
+	clientList []WggClient,
+) []WggTarget {
+	rotatedIDs := map[string]bool{}
+	for _, target := range rotated {
+		if target.IsNode() {
+			return Targets(nodeList, clientList)
+		}
+		rotatedIDs[target.TargetID()] = true
+	}
+
+	affected := Targets(nodeList, nil)
+	for _, client := range clientList {
+		if rotatedIDs[client.TargetID()] {
+			affected = append(affected, client)
+		}
+	}
+
+	return affected
+}
+
+// RotateWireGuardKeyPairs archives the current keys of the given targets and
+// creates fresh ones.
+//
+// The previous key pair and all preshared keys of a target are archived via
+// KeyStore.ArchiveKeys. The new key pair is created via KeyStore.InitKeyPair,
+// new preshared keys are created on the next config generation.
+func RotateWireGuardKeyPairs(
+	keyStore KeyStore,
+	targets []WggTarget,
+	now time.Time,
+) error {
+	for _, target := range targets {
+		err := keyStore.ArchiveKeys(target.TargetID(), now)
+		if err != nil {
+			return fmt.Errorf("error archiving keys of target '%s': %w", target.TargetID(), err)
+		}
+
+		_, _, err = keyStore.InitKeyPair(target.TargetID())
+		if err != nil {
+			return fmt.Errorf("error creating key pair of target '%s': %w", target.TargetID(), err)
+		}
+	}
+
+	return nil
+}
+
+// IsKeyFileOf returns true if the file name belongs to the key pair of the
+// given target or to one of its preshared keys.
+func IsKeyFileOf(fileName string, targetID string) bool {
+	if fileName == targetID+".key" || fileName == targetID+".pub" {
+		return true
+	}
+
+	pairID, ok := strings.CutSuffix(fileName, ".psk")
+	if !ok {
+		return false
+	}
+
+	first, second, ok := strings.Cut(pairID, "-")
+
+	return ok && (first == targetID || second == targetID)
+}
+
+// MigrateClientKeys moves the key pair of every named client that has no
+// keys yet from its former numbered ID "c<id>" to its name, so naming an
+// existing client keeps its identity.
+//
+// The keys under the numbered ID are archived afterwards, its preshared keys
+// are not moved and get re-created. A DerivedKeyStore derives keys for any
+// ID, so there the recorded KeySource decides which IDs have keys. It
+// returns the migrated clients.
+func MigrateClientKeys(
+	keyStore KeyStore,
+	clientList []WggClient,
+	now time.Time,
+) ([]WggClient, error) {
+	migrated := []WggClient{}
+
+	for _, client := range clientList {
+		if len(client.Name) <= 0 {
+			continue
+		}
+
+		hasKeys, err := hasKeyPair(keyStore, client.Name)
+		if err != nil {
+			return migrated, fmt.Errorf("key pair of client '%s': %w", client.Name, err)
+		} else if hasKeys {
+			continue
+		}
+
+		numberedID := NumberedClientID(client.ID)
+		hasKeys, err = hasKeyPair(keyStore, numberedID)
+		if err != nil {
+			return migrated, fmt.Errorf("key pair of client '%s': %w", numberedID, err)
+		} else if !hasKeys {
+			continue
+		}
+
+		privateKey, publicKey, err := keyStore.LoadKeyPair(numberedID)
+		if errors.Is(err, ErrPublicKeyMissing) {
+			publicKey, err = DeriveWireGuardPublicKey(privateKey)
+		}
+		if err != nil {
+			return migrated, fmt.Errorf("key pair of client '%s': %w", numberedID, err)
+		}
+
+		err = keyStore.SaveKeyPair(client.Name, privateKey, publicKey)
+		if err != nil {
+			return migrated, fmt.Errorf("error saving key pair of client '%s': %w", client.Name, err)
+		}
+
+		err = keyStore.ArchiveKeys(numberedID, now)
+		if err != nil {
+			return migrated, fmt.Errorf("error archiving keys of client '%s': %w", numberedID, err)
+		}
+
+		migrated = append(migrated, client)
+	}
+
+	return migrated, nil
+}
+
+// hasKeyPair returns true if the target already has a key pair. For a
+// DerivedKeyStore, also behind a Keyring, only targets with a recorded
+// KeySource have one, as keys are derived for any ID.
+func hasKeyPair(keyStore KeyStore, targetID string) (bool, error) {
+	if keyring, ok := keyStore.(*Keyring); ok {
+		keyStore = keyring.store
+	}
+
+	if derived, ok := keyStore.(*DerivedKeyStore); ok {
+		return len(derived.KeySource(targetID)) > 0, nil
+	}
+
+	_, _, err := keyStore.LoadKeyPair(targetID)
+	if err == nil || errors.Is(err, ErrKeyMismatch) || errors.Is(err, ErrPublicKeyMissing) {
+		return true, nil
+	} else if errors.Is(err, ErrKeyNotFound) {
+		return false, nil
+	}
+
+	return false, err
+}
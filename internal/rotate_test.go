@@ -0,0 +1,72 @@
+package wgg
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIsKeyFileOf(t *testing.T) {
+	tests := []struct {
+		fileName string
+		targetID string
+		expected bool
+	}{
+		{"n0.key", "n0", true},
+		{"n0.pub", "n0", true},
+		{"n0-n1.psk", "n1", true},
+		{"c1-n0.psk", "c1", true},
+		{"n10.key", "n1", false},
+		{"c10-n0.psk", "c1", false},
+		{"n0.key", "c0", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fileName+"/"+test.targetID, func(t *testing.T) {
+			if IsKeyFileOf(test.fileName, test.targetID) != test.expected {
+				t.Errorf("expected %v for %s and %s", test.expected, test.fileName, test.targetID)
+			}
+		})
+	}
+}
+
+func TestRotateWireGuardKeyPairs(t *testing.T) {
+	keyDir := t.TempDir()
+	nodeList := []WggNode{{ID: 0}, {ID: 1}}
+	clientList := []WggClient{{ID: 0}}
+
+	oldPrivateKey, _, err := InitWireGuardKeyPair(keyDir+"/c0.key", keyDir+"/c0.pub", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := SelectTargets("c0", nodeList, clientList)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = RotateWireGuardKeyPairs(NewFileKeyStore(keyDir, nil), rotated, now)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	archived, err := os.ReadFile(keyDir + "/archive/c0.key.20240102T030405Z")
+	if err != nil {
+		t.Fatalf("expected archived private key: %v", err)
+	} else if string(archived) != oldPrivateKey {
+		t.Errorf("archived key differs from the previous key")
+	}
+
+	newPrivateKey, _, err := LoadWireGuardKeyPair(keyDir+"/c0.key", keyDir+"/c0.pub", nil)
+	if err != nil {
+		t.Fatalf("expected new key pair: %v", err)
+	} else if newPrivateKey == oldPrivateKey {
+		t.Errorf("expected a fresh private key")
+	}
+
+	affected := AffectedTargets(rotated, nodeList, clientList)
+	if len(affected) != 3 {
+		t.Errorf("expected both nodes and c0 to be affected, but got %d targets", len(affected))
+	}
+}
@@ -0,0 +1,121 @@
+package wgg
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/CoreUnit-NET/wgg/lib/netutils"
+)
+
+// RouteSettings holds the routed prefixes of the sites behind the nodes.
+type RouteSettings struct {
+	// Routes maps node target IDs to the prefixes behind the node.
+	Routes map[string][]*net.IPNet
+}
+
+// Of returns the routed prefixes behind the target, nil if it has none.
+func (settings RouteSettings) Of(target WggTarget) []*net.IPNet {
+	if !target.IsNode() {
+		return nil
+	}
+
+	return settings.Routes[target.TargetID()]
+}
+
+// InitRouteSettings reads the routed prefixes of the nodes from the
+// inventory and the env vars, nodeList holds the existing nodes and subnet6
+// may be nil.
+//
+// WGG_NODE_ROUTES is a comma separated list of
+// "<node-id>=<prefix>|<prefix>|...", as routes of the inventory nodes, which
+// take precedence over it. A prefix must not overlap the overlay or the
+// prefix of another site. All problems are returned as *ValidationError.
+func InitRouteSettings(
+	inventory *Inventory,
+	subnet *net.IPNet,
+	subnet6 *net.IPNet,
+	nodeList []WggNode,
+) (RouteSettings, error) {
+	validation := &ValidationError{}
+	settings := RouteSettings{
+		Routes: map[string][]*net.IPNet{},
+	}
+	if inventory == nil {
+		inventory = &Inventory{}
+	}
+
+	nodes := map[string]bool{}
+	for _, node := range nodeList {
+		nodes[node.TargetID()] = true
+	}
+
+	// routeOwners remembers the node of every accepted prefix
+	routePrefixes := []*net.IPNet{}
+	routeOwners := []string{}
+
+	addRoute := func(nodeID string, value string, variable string) {
+		ip, prefix, err := net.ParseCIDR(value)
+		if err != nil {
+			validation.problem(variable, "invalid prefix '%s' of %s: %s", value, nodeID, err.Error())
+			return
+		} else if !ip.Equal(prefix.IP) {
+			validation.problem(variable, "%s of %s is not a network prefix, expected %s", value, nodeID, prefix)
+			return
+		}
+
+		for _, overlay := range []*net.IPNet{subnet, subnet6} {
+			if overlay != nil && netutils.SubnetsOverlap(prefix, overlay) {
+				validation.problem(variable, "%s of %s overlaps the overlay %s", prefix, nodeID, overlay)
+				return
+			}
+		}
+
+		for i, other := range routePrefixes {
+			if netutils.SubnetsOverlap(prefix, other) {
+				validation.problem(variable, "%s of %s overlaps %s of %s", prefix, nodeID, other, routeOwners[i])
+				return
+			}
+		}
+
+		routePrefixes = append(routePrefixes, prefix)
+		routeOwners = append(routeOwners, nodeID)
+		settings.Routes[nodeID] = append(settings.Routes[nodeID], prefix)
+	}
+
+	inventoryRoutes := map[string]bool{}
+	for i, entry := range inventory.Nodes {
+		if len(entry.Routes) > 0 && !entry.Removed {
+			nodeID := WggNode{ID: i}.TargetID()
+			inventoryRoutes[nodeID] = true
+			for j, value := range entry.Routes {
+				addRoute(nodeID, value, fmt.Sprintf("inventory nodes[%d].routes[%d]", i, j))
+			}
+		}
+	}
+
+	for _, routes := range SplitList(os.Getenv("WGG_NODE_ROUTES")) {
+		nodeID, value, ok := strings.Cut(routes, "=")
+		nodeID = strings.TrimSpace(nodeID)
+		if !ok || len(nodeID) <= 0 {
+			validation.problem("WGG_NODE_ROUTES", "invalid entry '%s', expected <node-id>=<prefix>|<prefix>|...", routes)
+			continue
+		} else if !nodes[nodeID] {
+			validation.problem("WGG_NODE_ROUTES", "%s is not a node", nodeID)
+			continue
+		} else if inventoryRoutes[nodeID] {
+			continue
+		}
+
+		for _, prefix := range splitAlternatives(value) {
+			addRoute(nodeID, prefix, "WGG_NODE_ROUTES")
+		}
+	}
+
+	if len(validation.Problems) > 0 {
+		return settings, validation
+	}
+
+	return settings, nil
+}
@@ -0,0 +1,93 @@
+package wgg
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestInitRouteSettings(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	_, subnet6, _ := net.ParseCIDR("fd00:10::/64")
+	nodeList := []WggNode{{ID: 0}, {ID: 1}}
+
+	t.Setenv("WGG_NODE_ROUTES", "n0=192.168.99.0/24,n1=192.168.20.0/24|fd00:20::/64")
+	inventory := &Inventory{
+		Nodes: []InventoryNode{
+			{Endpoint: "192.0.2.1:55333", Routes: []string{"192.168.10.0/24"}},
+			{Endpoint: "192.0.2.2:55333"},
+		},
+	}
+
+	settings, err := InitRouteSettings(inventory, subnet, subnet6, nodeList)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	routes := map[string]string{}
+	for _, node := range nodeList {
+		prefixes := []string{}
+		for _, prefix := range settings.Of(node) {
+			prefixes = append(prefixes, prefix.String())
+		}
+		routes[node.TargetID()] = strings.Join(prefixes, ", ")
+	}
+	if routes["n0"] != "192.168.10.0/24" || routes["n1"] != "192.168.20.0/24, fd00:20::/64" {
+		t.Errorf("expected the inventory routes of n0 and the env routes of n1, but got %v", routes)
+	}
+	if settings.Of(WggClient{ID: 0}) != nil {
+		t.Errorf("expected no routes behind a client, but got %v", settings.Of(WggClient{ID: 0}))
+	}
+
+	cases := []struct {
+		routes   string
+		expected string
+	}{
+		{"n1=192.168.10.128/25", "192.168.10.128/25 of n1 overlaps 192.168.10.0/24 of n0"},
+		{"n1=10.10.0.0/16", "10.10.0.0/16 of n1 overlaps the overlay 10.10.10.0/24"},
+		{"n1=fd00:10::/48", "fd00:10::/48 of n1 overlaps the overlay fd00:10::/64"},
+		{"n1=0.0.0.0/0", "0.0.0.0/0 of n1 overlaps the overlay"},
+		{"n1=192.168.20.1/24", "is not a network prefix, expected 192.168.20.0/24"},
+		{"n2=192.168.20.0/24", "n2 is not a node"},
+		{"n1=192.168.20.0", "invalid prefix '192.168.20.0'"},
+	}
+	for _, c := range cases {
+		t.Setenv("WGG_NODE_ROUTES", c.routes)
+
+		_, err := InitRouteSettings(inventory, subnet, subnet6, nodeList)
+		var validation *ValidationError
+		if !errors.As(err, &validation) || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("expected a ValidationError with %q, but got %v", c.expected, err)
+		}
+	}
+}
+
+func TestGenWgClientConfPartRoutes(t *testing.T) {
+	keyStore := NewFileKeyStore(t.TempDir(), nil)
+	_, subnet, _ := net.ParseCIDR("10.10.10.0/24")
+	_, site, _ := net.ParseCIDR("192.168.10.0/24")
+	siteNode := WggNode{ID: 0, Host: "192.0.2.1", Port: 55333}
+	otherNode := WggNode{ID: 1, Host: "192.0.2.2", Port: 55333}
+	client := NewWggClient(0)
+	options := GenOptions{
+		Routes: RouteSettings{Routes: map[string][]*net.IPNet{"n0": {site}}},
+	}
+
+	for _, forTarget := range []WggTarget{otherNode, client} {
+		conf, err := GenWgClientConfPart(siteNode, keyStore, subnet, forTarget, options)
+		if err != nil {
+			t.Fatalf("did not expect error, but got %v", err)
+		} else if !strings.Contains(conf, "AllowedIPs = 10.10.10.1/32, 192.168.10.0/24\n") {
+			t.Errorf("expected the site in the AllowedIPs for %s, but got:\n%s", forTarget.TargetID(), conf)
+		}
+	}
+
+	conf, err := GenWgClientConfPart(siteNode, keyStore, subnet, siteNode, options)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	} else if !strings.Contains(conf, "PostUp = iptables -A FORWARD -i %i -j ACCEPT\n") ||
+		strings.Contains(conf, "MASQUERADE") {
+		t.Errorf("expected forwarding without masquerading on the site node, but got:\n%s", conf)
+	}
+}
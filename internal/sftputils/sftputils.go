@@ -9,6 +9,7 @@ import (
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 type SshConfig struct {
@@ -18,6 +19,12 @@ type SshConfig struct {
 	PrivateKey string
 	Password   string
 	Timeout    time.Duration
+
+	// HostKeyFingerprint pins the SHA256 fingerprint of the host key, as
+	// printed by "ssh-keygen -lf", otherwise the host key is verified with
+	// KnownHostsFile.
+	HostKeyFingerprint string
+	KnownHostsFile     string
 }
 
 func (config *SshConfig) VerifySshConfig() error {
@@ -41,9 +48,38 @@ func (config *SshConfig) VerifySshConfig() error {
 		return errors.New("sshconfig: timeout lesser then 1 is not allowed")
 	}
 
+	if len(config.HostKeyFingerprint) <= 0 && len(config.KnownHostsFile) <= 0 {
+		return errors.New("sshconfig: host key fingerprint or known hosts file is required")
+	}
+
 	return nil
 }
 
+// HostKeyCallback returns the callback that verifies the host key against
+// the pinned fingerprint or the known hosts file. Unknown hosts are refused.
+func (config *SshConfig) HostKeyCallback() (ssh.HostKeyCallback, error) {
+	if len(config.HostKeyFingerprint) > 0 {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			fingerprint := ssh.FingerprintSHA256(key)
+			if fingerprint != config.HostKeyFingerprint {
+				return errors.New(
+					"host key " + fingerprint + " of " + hostname +
+						" does not match the pinned " + config.HostKeyFingerprint,
+				)
+			}
+
+			return nil
+		}, nil
+	}
+
+	hostKeyCallback, err := knownhosts.New(config.KnownHostsFile)
+	if err != nil {
+		return nil, errors.New("error parsing known hosts: " + err.Error())
+	}
+
+	return hostKeyCallback, nil
+}
+
 func (config *SshConfig) FillSshConfig() {
 	if config.Port == 0 {
 		config.Port = 22
@@ -58,6 +94,10 @@ func (config *SshConfig) FillSshConfig() {
 	}
 }
 
+// HandleSftp connects to the host of the given config and calls handle with
+// an sftp client and an ssh session on the same connection.
+//
+// The session can run a single command, like any ssh.Session.
 func HandleSftp(
 	sshConfig *SshConfig,
 	handle func(
@@ -65,18 +105,17 @@ func HandleSftp(
 		*ssh.Session,
 	) error,
 ) error {
-	// var hostkeyCallback ssh.HostKeyCallback
-	// hostkeyCallback, err = knownhosts.New(homeDir + "/.ssh/known_hosts")
-	// if err != nil {
-	// 	return errors.New("error parsing known hosts: " + err.Error())
-	// }
-
 	sshConfig.FillSshConfig()
 	err := sshConfig.VerifySshConfig()
 	if err != nil {
 		return errors.New("error verifying ssh config: " + err.Error())
 	}
 
+	hostKeyCallback, err := sshConfig.HostKeyCallback()
+	if err != nil {
+		return err
+	}
+
 	authMethods := []ssh.AuthMethod{}
 
 	if len(sshConfig.Password) > 0 {
@@ -93,15 +132,13 @@ func HandleSftp(
 	}
 
 	conf := &ssh.ClientConfig{
-		User: sshConfig.User,
-		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			return nil
-		},
-		Auth: authMethods,
+		User:            sshConfig.User,
+		HostKeyCallback: hostKeyCallback,
+		Auth:            authMethods,
 	}
 
 	// sftp
-	sftpSshClient, err := ssh.Dial("tcp", sshConfig.Host+":"+strconv.Itoa(sshConfig.Port), conf)
+	sftpSshClient, err := ssh.Dial("tcp", net.JoinHostPort(sshConfig.Host, strconv.Itoa(sshConfig.Port)), conf)
 	if err != nil {
 		return errors.New("error dialing: " + err.Error())
 	}
@@ -115,21 +152,15 @@ func HandleSftp(
 	}
 	defer sftp.Close()
 
-	// // session
-	// sessionSshClient, err := ssh.Dial("tcp", sshConfig.Host+":"+strconv.Itoa(sshConfig.Port), conf)
-	// if err != nil {
-	// 	return errors.New("error dialing: " + err.Error())
-	// }
-	// defer sessionSshClient.Close()
-
-	// session, err := sessionSshClient.NewSession()
-	// if err != nil {
-	// 	return errors.New("error creating ssh session: " + err.Error())
-	// }
-	// defer session.Close()
+	// session
+	session, err := sftpSshClient.NewSession()
+	if err != nil {
+		return errors.New("error creating ssh session: " + err.Error())
+	}
+	defer session.Close()
 
 	// handle
-	err = handle(sftp, nil)
+	err = handle(sftp, session)
 	if err != nil {
 		return errors.New("error handling: " + err.Error())
 	}
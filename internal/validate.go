@@ -0,0 +1,355 @@
+package wgg
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ConfigProblem is a single configuration mistake found by ValidateConfig.
+type ConfigProblem struct {
+	// Variable names the env var or inventory field that causes the
+	// problem, e.g. "WGG_NODE3" or "inventory nodes[2].endpoint".
+	Variable string
+	Message  string
+}
+
+func (problem ConfigProblem) String() string {
+	if len(problem.Variable) <= 0 {
+		return problem.Message
+	}
+
+	return problem.Variable + ": " + problem.Message
+}
+
+// ValidationError lists every problem found by ValidateConfig.
+type ValidationError struct {
+	Problems []ConfigProblem
+}
+
+func (err *ValidationError) Error() string {
+	lines := []string{"invalid configuration:"}
+	for _, problem := range err.Problems {
+		lines = append(lines, "- "+problem.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (err *ValidationError) problem(variable string, format string, args ...any) {
+	err.Problems = append(err.Problems, ConfigProblem{
+		Variable: variable,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// merge adds the problems of a *ValidationError, or any other error as a
+// single problem.
+func (err *ValidationError) merge(other error) {
+	otherValidation, ok := other.(*ValidationError)
+	if ok {
+		err.Problems = append(err.Problems, otherValidation.Problems...)
+	} else if other != nil {
+		err.problem("", "%s", other.Error())
+	}
+}
+
+// MaxTargetCount is the highest number of nodes or clients ValidateConfig
+// accepts, every node config contains a peer section for each of them.
+const MaxTargetCount = 65534
+
+// nodeEnvPattern matches the WGG_NODE<n> env vars.
+var nodeEnvPattern = regexp.MustCompile(`^WGG_NODE([0-9]+)=`)
+
+// ValidateConfig checks the whole configuration of the inventory and the env
+// vars before anything is written and returns a *ValidationError with every
+// problem it finds, or nil.
+//
+// Besides the checks of the Init functions it detects gaps in the WGG_NODE<n>
+// indexes and a subnet or prefix that can not hold all nodes and clients.
+func ValidateConfig(inventory *Inventory) error {
+	validation := &ValidationError{}
+
+	subnetVariable := "WGG_SUBNET"
+	if inventory != nil && len(inventory.Subnet) > 0 {
+		subnetVariable = "inventory subnet"
+	}
+	subnet, err := InitSubnet(inventory)
+	if err != nil {
+		validation.problem(subnetVariable, "%s", err.Error())
+	}
+
+	subnet6Variable, subnet6Value := "WGG_SUBNET6", os.Getenv("WGG_SUBNET6")
+	if inventory != nil && len(inventory.Subnet6) > 0 {
+		subnet6Variable, subnet6Value = "inventory subnet6", inventory.Subnet6
+	}
+	subnet6Auto := subnet6Value == Subnet6Auto
+	if subnet6Auto {
+		// checks the subnet against a generated prefix
+		subnet6Value = "fd00::/64"
+	}
+	var subnet6 *net.IPNet
+	if subnet != nil && len(subnet6Value) > 0 {
+		subnet6, err = ParseSubnet6(subnet6Value, subnet)
+		if err != nil {
+			validation.problem(subnet6Variable, "%s", err.Error())
+		}
+	}
+
+	if (inventory == nil || len(inventory.OutDir) <= 0) && len(os.Getenv("WGG_OUT_DIR")) <= 0 {
+		validation.problem("WGG_OUT_DIR", "the out dir is not set")
+	}
+
+	_, err = InitGenOptions()
+	if err != nil {
+		validation.problem("", "%s", err.Error())
+	}
+
+	_, err = InitInterfaceSettings(inventory)
+	validation.merge(err)
+	nodeIDs := validateNodes(inventory, validation)
+	clientIDs := validateClients(inventory, validation)
+
+	nodeList := []WggNode{}
+	for _, id := range nodeIDs {
+		nodeList = append(nodeList, WggNode{ID: id})
+	}
+	clientList := []WggClient{}
+	for _, id := range clientIDs {
+		client := WggClient{ID: id}
+		if inventory != nil && id < len(inventory.Clients) {
+			client.Name = inventory.Clients[id].Name
+		}
+		clientList = append(clientList, client)
+	}
+
+	_, err = InitKeepaliveSettings(inventory, nodeList, clientList)
+	validation.merge(err)
+	_, err = InitExitSettings(inventory, nodeList, clientList)
+	validation.merge(err)
+
+	if subnet != nil {
+		if subnet6Auto {
+			// the generated prefix is checked by LoadMesh
+			subnet6 = nil
+		}
+		_, err = InitRouteSettings(inventory, subnet, subnet6, nodeList)
+		validation.merge(err)
+
+		if !subnet6Auto {
+			// IPv6 hub prefixes need the generated prefix, LoadMesh checks
+			// them
+			_, err = InitHubSettings(inventory, subnet, subnet6, nodeList, clientList)
+			validation.merge(err)
+		}
+	}
+
+	if subnet != nil {
+		policy, err := InitAllocationPolicy(inventory, subnet)
+		if err != nil {
+			validation.merge(err)
+		} else {
+			validateAddresses(policy, subnetVariable, nodeIDs, clientIDs, validation)
+		}
+	}
+
+	if len(validation.Problems) > 0 {
+		return validation
+	}
+
+	return nil
+}
+
+// validateNodes checks every node entry and returns the ID of each node.
+func validateNodes(inventory *Inventory, validation *ValidationError) []int {
+	ids := []int{}
+
+	if inventory != nil && len(inventory.Nodes) > 0 {
+		for i, entry := range inventory.Nodes {
+			if entry.Removed {
+				continue
+			}
+
+			variable := fmt.Sprintf("inventory nodes[%d].endpoint", i)
+			_, err := NewWggNode(i, entry.Endpoint)
+			if err != nil {
+				validation.problem(variable, "%s", err.Error())
+			}
+			ids = append(ids, i)
+		}
+
+		return ids
+	}
+
+	indexes := []int{}
+	for _, env := range os.Environ() {
+		match := nodeEnvPattern.FindStringSubmatch(env)
+		if match == nil {
+			continue
+		}
+
+		index, err := strconv.Atoi(match[1])
+		if err == nil && len(os.Getenv("WGG_NODE"+match[1])) > 0 {
+			indexes = append(indexes, index)
+		}
+	}
+	slices.Sort(indexes)
+
+	// InitNodeList only reads the nodes up to the first missing index
+	for i, index := range indexes {
+		variable := "WGG_NODE" + strconv.Itoa(index)
+		if index != i+1 {
+			validation.problem(
+				variable,
+				"is set, but WGG_NODE%d is missing, the node indexes must be contiguous from 1",
+				i+1,
+			)
+			break
+		}
+
+		_, err := NewWggNode(i, os.Getenv(variable))
+		if err != nil {
+			validation.problem(variable, "%s", err.Error())
+		}
+		ids = append(ids, i)
+	}
+
+	return ids
+}
+
+// validateClients checks every client entry and returns the ID of each
+// client.
+func validateClients(inventory *Inventory, validation *ValidationError) []int {
+	ids := []int{}
+
+	_, err := ParseEndpointPreference(os.Getenv("WGG_ENDPOINT_PREFERENCE"))
+	if err != nil {
+		validation.problem("WGG_ENDPOINT_PREFERENCE", "%s", err.Error())
+	}
+
+	if inventory != nil && len(inventory.Clients) > 0 {
+		names := map[string]string{}
+
+		for i, entry := range inventory.Clients {
+			if entry.Removed {
+				continue
+			}
+
+			variable := fmt.Sprintf("inventory clients[%d]", i)
+			if len(entry.Name) > 0 {
+				err := ValidateClientName(entry.Name)
+				if err != nil {
+					validation.problem(variable+".name", "%s", err.Error())
+				} else if otherVariable, ok := names[entry.Name]; ok {
+					validation.problem(variable+".name", "duplicate client name '%s', also used by %s", entry.Name, otherVariable)
+				}
+				names[entry.Name] = variable
+			}
+
+			_, err := ParseEndpointPreference(entry.EndpointPreference)
+			if err != nil {
+				validation.problem(variable+".endpoint_preference", "%s", err.Error())
+			}
+
+			ids = append(ids, i)
+		}
+
+		return ids
+	}
+
+	if inventory != nil && inventory.ClientCount != nil {
+		if *inventory.ClientCount > MaxTargetCount {
+			validation.problem("inventory client_count", "value %d is greater than %d", *inventory.ClientCount, MaxTargetCount)
+			return ids
+		}
+
+		for id := range *inventory.ClientCount {
+			ids = append(ids, id)
+		}
+
+		return ids
+	}
+
+	clientCountString := os.Getenv("WGG_CLIENT_COUNT")
+	clientCount, err := strconv.Atoi(clientCountString)
+	if len(clientCountString) <= 0 {
+		validation.problem("WGG_CLIENT_COUNT", "the client count is not set")
+	} else if err != nil {
+		validation.problem("WGG_CLIENT_COUNT", "value '%s' is not an int", clientCountString)
+	} else if clientCount < 0 {
+		validation.problem("WGG_CLIENT_COUNT", "value %d must not be negative", clientCount)
+	} else if clientCount > MaxTargetCount {
+		validation.problem("WGG_CLIENT_COUNT", "value %d is greater than %d", clientCount, MaxTargetCount)
+		clientCount = 0
+	}
+
+	for id := range max(clientCount, 0) {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// validateAddresses checks that the policy has an address for every node and
+// client slot. Removed entries keep their slot, as their addresses stay
+// holes.
+func validateAddresses(
+	policy *AllocationPolicy,
+	subnetVariable string,
+	nodeIDs []int,
+	clientIDs []int,
+	validation *ValidationError,
+) {
+	nodeSlots := 0
+	if len(nodeIDs) > 0 {
+		nodeSlots = nodeIDs[len(nodeIDs)-1] + 1
+	}
+	clientSlots := 0
+	if len(clientIDs) > 0 {
+		clientSlots = clientIDs[len(clientIDs)-1] + 1
+	}
+
+	if policy.SharedPrefix() {
+		capacity := policy.Capacity(true)
+		if big.NewInt(int64(nodeSlots+clientSlots)).Cmp(capacity) > 0 {
+			validation.problem(
+				subnetVariable,
+				"%s holds %s addresses, but %d node slots and %d client slots need %d",
+				policy.Subnet,
+				capacity,
+				nodeSlots,
+				clientSlots,
+				nodeSlots+clientSlots,
+			)
+		}
+
+		return
+	}
+
+	nodeCapacity := policy.Capacity(true)
+	if big.NewInt(int64(nodeSlots)).Cmp(nodeCapacity) > 0 {
+		validation.problem(
+			"",
+			"the node addresses in %s hold %s addresses, but %d node slots need them",
+			policy.Prefix(WggNode{}),
+			nodeCapacity,
+			nodeSlots,
+		)
+	}
+
+	clientCapacity := policy.Capacity(false)
+	if big.NewInt(int64(clientSlots)).Cmp(clientCapacity) > 0 {
+		validation.problem(
+			"",
+			"the client addresses in %s hold %s addresses, but %d client slots need them",
+			policy.Prefix(WggClient{}),
+			clientCapacity,
+			clientSlots,
+		)
+	}
+}
@@ -0,0 +1,141 @@
+package wgg
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		expected []string
+	}{
+		{
+			"valid",
+			map[string]string{"WGG_NODE1": "192.0.2.1:55333", "WGG_NODE2": "192.0.2.2:55333"},
+			nil,
+		},
+		{
+			"node index gap",
+			map[string]string{"WGG_NODE1": "192.0.2.1:55333", "WGG_NODE3": "192.0.2.3:55333"},
+			[]string{"WGG_NODE3: is set, but WGG_NODE2 is missing"},
+		},
+		{
+			"port range",
+			map[string]string{"WGG_NODE1": "192.0.2.1:70000", "WGG_NODE2": "192.0.2.2:0"},
+			[]string{"WGG_NODE1: port out of range", "WGG_NODE2: port out of range"},
+		},
+		{
+			"subnet capacity",
+			map[string]string{"WGG_SUBNET": "10.10.10.0/29", "WGG_NODE1": "192.0.2.1:55333", "WGG_CLIENT_COUNT": "6"},
+			[]string{"WGG_SUBNET: 10.10.10.0/29 holds 6 addresses, but 1 node slots and 6 client slots need 7"},
+		},
+		{
+			"allocation policy",
+			map[string]string{
+				"WGG_NODE1":         "192.0.2.1:55333",
+				"WGG_NODE_PREFIX":   "10.10.10.0/30",
+				"WGG_CLIENT_PREFIX": "10.10.11.0/25",
+				"WGG_RESERVED":      "10.10.10.1-10.10.10.2",
+				"WGG_ALLOCATION":    "random",
+			},
+			[]string{
+				"WGG_CLIENT_PREFIX: 10.10.11.0/25 is not within the subnet 10.10.10.0/24",
+				"WGG_ALLOCATION: invalid allocation 'random'",
+			},
+		},
+		{
+			"reserved node prefix",
+			map[string]string{
+				"WGG_NODE1":       "192.0.2.1:55333",
+				"WGG_NODE_PREFIX": "10.10.10.0/30",
+				"WGG_RESERVED":    "10.10.10.1-10.10.10.3",
+			},
+			[]string{"the node addresses in 10.10.10.0/30 hold 0 addresses, but 1 node slots need them"},
+		},
+		{
+			"everything at once",
+			map[string]string{
+				"WGG_SUBNET":       "10.10.10.0/33",
+				"WGG_OUT_DIR":      "",
+				"WGG_CLIENT_COUNT": "-1",
+				"WGG_WORKERS":      "0",
+			},
+			[]string{
+				"WGG_SUBNET: error while parsing",
+				"WGG_OUT_DIR: the out dir is not set",
+				"WGG_WORKERS env var must be greater than 0",
+				"WGG_CLIENT_COUNT: value -1 must not be negative",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("WGG_SUBNET", "10.10.10.0/24")
+			t.Setenv("WGG_OUT_DIR", "out")
+			t.Setenv("WGG_CLIENT_COUNT", "2")
+			for _, key := range []string{
+				"WGG_NODE1", "WGG_NODE2", "WGG_NODE3", "WGG_WORKERS",
+				"WGG_NODE_PREFIX", "WGG_CLIENT_PREFIX", "WGG_RESERVED", "WGG_ALLOCATION",
+			} {
+				t.Setenv(key, "")
+			}
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+
+			err := ValidateConfig(nil)
+			if len(test.expected) == 0 {
+				if err != nil {
+					t.Errorf("did not expect error, but got %v", err)
+				}
+				return
+			}
+
+			var validation *ValidationError
+			if !errors.As(err, &validation) {
+				t.Fatalf("expected a ValidationError, but got %v", err)
+			}
+			if len(validation.Problems) != len(test.expected) {
+				t.Errorf("expected %d problems, but got:\n%v", len(test.expected), err)
+			}
+			for _, expected := range test.expected {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("expected problem '%s', but got:\n%v", expected, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateInventory(t *testing.T) {
+	t.Setenv("WGG_OUT_DIR", "out")
+
+	inventory, err := ParseInventory([]byte(
+		"subnet: 10.10.10.0/24\n"+
+			"nodes:\n"+
+			"  - endpoint: 192.0.2.1:55333\n"+
+			"  - endpoint: bad_host:55333\n"+
+			"clients:\n"+
+			"  - name: alice\n"+
+			"  - name: alice\n"+
+			"    endpoint_preference: v5\n",
+	), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ValidateConfig(inventory)
+	for _, expected := range []string{
+		"inventory nodes[1].endpoint: invalid ip or hostname",
+		"inventory clients[1].name: duplicate client name 'alice', also used by inventory clients[0]",
+		"inventory clients[1].endpoint_preference: invalid endpoint preference",
+	} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected problem '%s', but got:\n%v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package wgg
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// VaultKeyStore stores the keys in a HashiCorp Vault KV v2 secrets engine.
+//
+// Key pairs are stored at "<Path>/keys/<id>" with the fields "private_key"
+// and "public_key", preshared keys at "<Path>/psk/<pair-id>" with the field
+// "preshared_key". Archived keys are copied to "<Path>/archive/...".
+type VaultKeyStore struct {
+	Addr      string
+	Token     string
+	Namespace string
+	Mount     string
+	Path      string
+
+	Client *http.Client
+}
+
+// NewVaultKeyStore returns a new VaultKeyStore.
+//
+// The addr is the base URL of the Vault server, e.g.
+// "https://vault.example.com:8200".
+func NewVaultKeyStore(
+	addr string,
+	token string,
+	mount string,
+	path string,
+) *VaultKeyStore {
+	return &VaultKeyStore{
+		Addr:  strings.TrimSuffix(addr, "/"),
+		Token: token,
+		Mount: strings.Trim(mount, "/"),
+		Path:  strings.Trim(path, "/"),
+		Client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// InitVaultKeyStore returns a VaultKeyStore configured by the env vars
+// VAULT_ADDR, VAULT_TOKEN, VAULT_NAMESPACE (optional), WGG_VAULT_MOUNT
+// (default "secret") and WGG_VAULT_PATH (default "wgg"). The active profile
+// is appended to the path, unless its env file sets WGG_VAULT_PATH, see
+// ProfilePath.
+func InitVaultKeyStore() (*VaultKeyStore, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if len(addr) <= 0 {
+		return nil, errors.New("the VAULT_ADDR env var is not set or empty")
+	}
+
+	token := os.Getenv("VAULT_TOKEN")
+	if len(token) <= 0 {
+		return nil, errors.New("the VAULT_TOKEN env var is not set or empty")
+	}
+
+	mount := os.Getenv("WGG_VAULT_MOUNT")
+	if len(mount) <= 0 {
+		mount = "secret"
+	}
+
+	path := os.Getenv("WGG_VAULT_PATH")
+	if len(path) <= 0 {
+		path = "wgg"
+	}
+	path = ProfilePath(path, "WGG_VAULT_PATH")
+
+	store := NewVaultKeyStore(addr, token, mount, path)
+	store.Namespace = os.Getenv("VAULT_NAMESPACE")
+
+	return store, nil
+}
+
+type vaultSecret struct {
+	Data struct {
+		Data map[string]string `json:"data"`
+	} `json:"data"`
+}
+
+type vaultList struct {
+	Data struct {
+		Keys []string `json:"keys"`
+	} `json:"data"`
+}
+
+// request sends a request to the KV v2 API. The api is either "data" or
+// "metadata", the path is relative to the store's Path.
+//
+// A 404 response is returned as ErrKeyNotFound.
+func (store *VaultKeyStore) request(
+	method string,
+	api string,
+	path string,
+	query string,
+	body any,
+	out any,
+) error {
+	url := store.Addr + "/v1/" + store.Mount + "/" + api + "/" + store.Path + "/" + path
+	if len(query) > 0 {
+		url += "?" + query
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		rawBody, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error encoding vault request: %w", err)
+		}
+		reqBody = bytes.NewReader(rawBody)
+	}
+
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return fmt.Errorf("error creating vault request: %w", err)
+	}
+
+	req.Header.Set("X-Vault-Token", store.Token)
+	if len(store.Namespace) > 0 {
+		req.Header.Set("X-Vault-Namespace", store.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := store.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending vault request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrKeyNotFound
+	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		rawError, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf(
+			"vault %s %s failed with status %d: %s",
+			method,
+			api+"/"+store.Path+"/"+path,
+			resp.StatusCode,
+			strings.TrimSpace(string(rawError)),
+		)
+	}
+
+	if out != nil {
+		err = json.NewDecoder(resp.Body).Decode(out)
+		if err != nil {
+			return fmt.Errorf("error decoding vault response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (store *VaultKeyStore) readSecret(path string) (map[string]string, error) {
+	secret := vaultSecret{}
+
+	err := store.request(http.MethodGet, "data", path, "", nil, &secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret.Data.Data, nil
+}
+
+func (store *VaultKeyStore) writeSecret(path string, data map[string]string) error {
+	return store.request(
+		http.MethodPost,
+		"data",
+		path,
+		"",
+		map[string]any{"data": data},
+		nil,
+	)
+}
+
+// LoadKeyPair reads and validates the key pair of the target. Only a missing
+// secret is reported as ErrKeyNotFound, a secret without a private key is an
+// error.
+func (store *VaultKeyStore) LoadKeyPair(targetID string) (string, string, error) {
+	data, err := store.readSecret("keys/" + targetID)
+	if err != nil {
+		return "", "", err
+	}
+
+	privateKey := strings.TrimSpace(data["private_key"])
+	publicKey := strings.TrimSpace(data["public_key"])
+	if len(privateKey) == 0 {
+		// e.g. only the public key of a node-side key pair is stored, a new
+		// pair must not replace it
+		return "", "", fmt.Errorf("the secret of '%s' holds no private key", targetID)
+	} else if len(publicKey) == 0 {
+		return privateKey, "", ErrPublicKeyMissing
+	}
+
+	err = ValidateWireGuardKeyPair(privateKey, publicKey)
+	if errors.Is(err, ErrKeyMismatch) {
+		return privateKey, publicKey, err
+	} else if err != nil {
+		return "", "", err
+	}
+
+	return privateKey, publicKey, nil
+}
+
+// SaveKeyPair writes the key pair of the target as a new secret version.
+func (store *VaultKeyStore) SaveKeyPair(
+	targetID string,
+	privateKey string,
+	publicKey string,
+) error {
+	return store.writeSecret("keys/"+targetID, map[string]string{
+		"private_key": privateKey,
+		"public_key":  publicKey,
+	})
+}
+
+// LoadPublicKey reads and validates the public key of the target.
+func (store *VaultKeyStore) LoadPublicKey(targetID string) (string, error) {
+	data, err := store.readSecret("keys/" + targetID)
+	if err != nil {
+		return "", err
+	}
+
+	publicKey := strings.TrimSpace(data["public_key"])
+
+	_, err = ParseWireGuardKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+
+	return publicKey, nil
+}
+
+// SavePublicKey writes only the public key of the target as a new secret
+// version.
+func (store *VaultKeyStore) SavePublicKey(targetID string, publicKey string) error {
+	return store.writeSecret("keys/"+targetID, map[string]string{
+		"public_key": publicKey,
+	})
+}
+
+// InitKeyPair loads the key pair of the target, or generates and saves a
+// new one if the target has none yet.
+func (store *VaultKeyStore) InitKeyPair(targetID string) (string, string, error) {
+	privateKey, publicKey, err := store.LoadKeyPair(targetID)
+	if !errors.Is(err, ErrKeyNotFound) {
+		return privateKey, publicKey, err
+	}
+
+	privateKey, publicKey, err = GenerateWireGuardKeyPair()
+	if err != nil {
+		return "", "", err
+	}
+
+	err = store.SaveKeyPair(targetID, privateKey, publicKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	return privateKey, publicKey, nil
+}
+
+// LoadPresharedKey reads and validates the preshared key.
+func (store *VaultKeyStore) LoadPresharedKey(pairID string) (string, error) {
+	data, err := store.readSecret("psk/" + pairID)
+	if err != nil {
+		return "", err
+	}
+
+	presharedKey := strings.TrimSpace(data["preshared_key"])
+
+	_, err = ParseWireGuardKey(presharedKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid preshared key: %w", err)
+	}
+
+	return presharedKey, nil
+}
+
+// InitPresharedKey loads the preshared key, or generates and saves a new
+// one if it does not exist yet.
+func (store *VaultKeyStore) InitPresharedKey(pairID string) (string, error) {
+	presharedKey, err := store.LoadPresharedKey(pairID)
+	if !errors.Is(err, ErrKeyNotFound) {
+		return presharedKey, err
+	}
+
+	presharedKey, err = GenerateWireGuardPresharedKey()
+	if err != nil {
+		return "", err
+	}
+
+	err = store.SavePresharedKey(pairID, presharedKey)
+	if err != nil {
+		return "", err
+	}
+
+	return presharedKey, nil
+}
+
+// SavePresharedKey writes the preshared key as a new secret version.
+func (store *VaultKeyStore) SavePresharedKey(pairID string, presharedKey string) error {
+	return store.writeSecret("psk/"+pairID, map[string]string{
+		"preshared_key": presharedKey,
+	})
+}
+
+// ArchiveKeys copies the key pair and the preshared keys of the target to
+// "<Path>/archive/..." suffixed with the given time and deletes the current
+// versions. The previous versions also stay in the KV v2 history.
+func (store *VaultKeyStore) ArchiveKeys(targetID string, now time.Time) error {
+	paths := []string{"keys/" + targetID}
+
+	list := vaultList{}
+	err := store.request(http.MethodGet, "metadata", "psk", "list=true", nil, &list)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return err
+	}
+
+	for _, pairID := range list.Data.Keys {
+		if IsKeyFileOf(pairID+".psk", targetID) {
+			paths = append(paths, "psk/"+pairID)
+		}
+	}
+
+	suffix := "." + ArchiveTimestamp(now)
+
+	for _, path := range paths {
+		data, err := store.readSecret(path)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = store.writeSecret("archive/"+path+suffix, data)
+		if err != nil {
+			return err
+		}
+
+		err = store.request(http.MethodDelete, "data", path, "", nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
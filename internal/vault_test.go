@@ -0,0 +1,156 @@
+package wgg
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newFakeVault returns a minimal KV v2 stand-in mounted at "secret".
+func newFakeVault(t *testing.T, token string) (*httptest.Server, map[string]map[string]string) {
+	secrets := map[string]map[string]string{}
+	mu := sync.Mutex{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if path, ok := strings.CutPrefix(r.URL.Path, "/v1/secret/metadata/"); ok {
+			if r.URL.Query().Get("list") != "true" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			keys := []string{}
+			for secretPath := range secrets {
+				if key, ok := strings.CutPrefix(secretPath, path+"/"); ok && !strings.Contains(key, "/") {
+					keys = append(keys, key)
+				}
+			}
+			if len(keys) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"keys": keys}})
+			return
+		}
+
+		path, ok := strings.CutPrefix(r.URL.Path, "/v1/secret/data/")
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			data, ok := secrets[path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"data": data}})
+		case http.MethodPost:
+			body := struct {
+				Data map[string]string `json:"data"`
+			}{}
+			err := json.NewDecoder(r.Body).Decode(&body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			secrets[path] = body.Data
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			delete(secrets, path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, secrets
+}
+
+func TestVaultKeyStore(t *testing.T) {
+	server, secrets := newFakeVault(t, "s.token")
+	store := NewVaultKeyStore(server.URL, "s.token", "secret", "wgg")
+
+	_, _, err := store.LoadKeyPair("n0")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, but got %v", err)
+	}
+
+	privateKey, publicKey, err := store.InitKeyPair("n0")
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	if secrets["wgg/keys/n0"]["private_key"] != privateKey {
+		t.Errorf("expected the private key to be stored in vault")
+	}
+
+	loadedPrivateKey, loadedPublicKey, err := store.InitKeyPair("n0")
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	} else if loadedPrivateKey != privateKey || loadedPublicKey != publicKey {
+		t.Errorf("expected the existing key pair to be loaded")
+	}
+
+	presharedKey, err := store.InitPresharedKey(PresharedKeyID("n0", "c0"))
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	} else if secrets["wgg/psk/c0-n0"]["preshared_key"] != presharedKey {
+		t.Errorf("expected the preshared key to be stored in vault")
+	}
+
+	err = RotateWireGuardKeyPairs(store, []WggTarget{WggNode{ID: 0}}, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	if secrets["wgg/archive/keys/n0.20240102T030405Z"]["private_key"] != privateKey {
+		t.Errorf("expected the previous key pair to be archived")
+	}
+	if secrets["wgg/archive/psk/c0-n0.20240102T030405Z"]["preshared_key"] != presharedKey {
+		t.Errorf("expected the previous preshared key to be archived")
+	}
+	if _, ok := secrets["wgg/psk/c0-n0"]; ok {
+		t.Errorf("expected the previous preshared key to be removed")
+	}
+	if secrets["wgg/keys/n0"]["private_key"] == privateKey {
+		t.Errorf("expected a fresh private key")
+	}
+
+	err = store.SavePublicKey("n1", publicKey)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	_, _, err = store.InitKeyPair("n1")
+	if err == nil || errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected error for a secret with only a public key, but got %v", err)
+	} else if secrets["wgg/keys/n1"]["public_key"] != publicKey {
+		t.Errorf("expected the recorded public key to be kept")
+	}
+}
+
+func TestVaultKeyStoreForbidden(t *testing.T) {
+	server, _ := newFakeVault(t, "s.token")
+	store := NewVaultKeyStore(server.URL, "wrong", "secret", "wgg")
+
+	_, _, err := store.InitKeyPair("n0")
+	if err == nil || errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected a permission error, but got %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package wgg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// WgQuickConf is a parsed wg-quick config file.
+type WgQuickConf struct {
+	Interface WgQuickInterface
+	Peers     []WgQuickPeer
+}
+
+// WgQuickInterface is the [Interface] section of a wg-quick config.
+type WgQuickInterface struct {
+	Addresses  []string
+	PrivateKey string
+	ListenPort int
+}
+
+// WgQuickPeer is a [Peer] section of a wg-quick config.
+type WgQuickPeer struct {
+	PublicKey    string
+	PresharedKey string
+	AllowedIPs   []string
+	Endpoint     string
+}
+
+// ParseWgQuickConf parses the content of a wg-quick config file.
+//
+// Keys are matched case-insensitively, comments and unknown keys are
+// ignored. List values like Address and AllowedIPs may be comma separated
+// and repeated.
+func ParseWgQuickConf(content string) (WgQuickConf, error) {
+	conf := WgQuickConf{}
+	section := ""
+	hasInterface := false
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			switch section {
+			case "interface":
+				if hasInterface {
+					return conf, fmt.Errorf("line %d: duplicate [Interface] section", lineNumber)
+				}
+				hasInterface = true
+			case "peer":
+				conf.Peers = append(conf.Peers, WgQuickPeer{})
+			default:
+				return conf, fmt.Errorf("line %d: unknown section '%s'", lineNumber, line)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return conf, fmt.Errorf("line %d: expected 'key = value', got '%s'", lineNumber, line)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch section {
+		case "interface":
+			switch key {
+			case "address":
+				conf.Interface.Addresses = append(conf.Interface.Addresses, SplitList(value)...)
+			case "privatekey":
+				conf.Interface.PrivateKey = value
+			case "listenport":
+				port, err := strconv.Atoi(value)
+				if err != nil {
+					return conf, fmt.Errorf("line %d: invalid ListenPort '%s': %w", lineNumber, value, err)
+				}
+				conf.Interface.ListenPort = port
+			}
+		case "peer":
+			peer := &conf.Peers[len(conf.Peers)-1]
+			switch key {
+			case "publickey":
+				peer.PublicKey = value
+			case "presharedkey":
+				peer.PresharedKey = value
+			case "allowedips":
+				peer.AllowedIPs = append(peer.AllowedIPs, SplitList(value)...)
+			case "endpoint":
+				peer.Endpoint = value
+			}
+		default:
+			return conf, fmt.Errorf("line %d: key '%s' outside of a section", lineNumber, key)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return conf, err
+	}
+
+	if !hasInterface {
+		return conf, errors.New("no [Interface] section")
+	}
+
+	return conf, nil
+}
+
+// SplitList splits a comma separated list and drops empty entries.
+func SplitList(value string) []string {
+	list := []string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) > 0 {
+			list = append(list, entry)
+		}
+	}
+
+	return list
+}
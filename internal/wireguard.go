@@ -1,79 +1,187 @@
 package wgg
 
 import (
-	"bytes"
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"strings"
+
+	"golang.org/x/crypto/curve25519"
 )
 
+// WireGuardKeyLen is the length of a raw WireGuard key in bytes.
+const WireGuardKeyLen = curve25519.ScalarSize
+
 // GenerateWireGuardKeyPair generates a new WireGuard key pair.
 //
-// This function runs the "wg genkey" command to create a private key
-// and then pipes it into the "wg pubkey" command to derive the corresponding
-// public key. It returns the private key, public key, and an error if any
-// command execution fails.
+// The private key is read from crypto/rand and clamped as described in
+// RFC 7748, the public key is derived from it via Curve25519. Both keys are
+// returned base64 encoded, which is the same format "wg genkey" and
+// "wg pubkey" produce.
 func GenerateWireGuardKeyPair() (string, string, error) {
-	cmdGenKey := exec.Command("wg", "genkey")
+	var privateKey [WireGuardKeyLen]byte
 
-	privateKeyBuf := &bytes.Buffer{}
-	cmdGenKey.Stdout = privateKeyBuf
-
-	if err := cmdGenKey.Run(); err != nil {
+	_, err := rand.Read(privateKey[:])
+	if err != nil {
 		return "", "", fmt.Errorf("failed to generate private key: %w", err)
 	}
 
-	privateKeyString := privateKeyBuf.String()
+	ClampPrivateKey(&privateKey)
 
-	cmdPubKey := exec.Command("wg", "pubkey")
-	cmdPubKey.Stdin = bytes.NewReader([]byte(privateKeyString))
+	privateKeyString := base64.StdEncoding.EncodeToString(privateKey[:])
 
-	publicKeyBuf := &bytes.Buffer{}
-	cmdPubKey.Stdout = publicKeyBuf
-
-	if err := cmdPubKey.Run(); err != nil {
+	publicKeyString, err := DeriveWireGuardPublicKey(privateKeyString)
+	if err != nil {
 		return "", "", fmt.Errorf("failed to generate public key: %w", err)
 	}
 
-	privateKeyString = strings.TrimSpace(privateKeyString)
-	publicKeyString := strings.TrimSpace(publicKeyBuf.String())
-
 	return privateKeyString, publicKeyString, nil
 }
 
+// GenerateWireGuardPresharedKey generates a new base64 encoded WireGuard
+// preshared key, like "wg genpsk" does.
+func GenerateWireGuardPresharedKey() (string, error) {
+	var presharedKey [WireGuardKeyLen]byte
+
+	_, err := rand.Read(presharedKey[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to generate preshared key: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(presharedKey[:]), nil
+}
+
+// ClampPrivateKey clamps the given raw Curve25519 private key in place,
+// like "wg genkey" does.
+func ClampPrivateKey(privateKey *[WireGuardKeyLen]byte) {
+	privateKey[0] &= 248
+	privateKey[31] = (privateKey[31] & 127) | 64
+}
+
+// ErrKeyMismatch is returned if a public key does not derive from the
+// private key it is stored next to.
+var ErrKeyMismatch = errors.New("public key does not match private key")
+
+// ParseWireGuardKey decodes the given base64 encoded WireGuard key.
+//
+// Surrounding whitespace is ignored. If the key is not valid base64 or does
+// not decode to exactly 32 bytes, an error is returned.
+func ParseWireGuardKey(key string) ([WireGuardKeyLen]byte, error) {
+	var rawKey [WireGuardKeyLen]byte
+
+	key = strings.TrimSpace(key)
+	if len(key) == 0 {
+		return rawKey, errors.New("key is empty")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return rawKey, fmt.Errorf("key is not valid base64: %w", err)
+	} else if len(decoded) != WireGuardKeyLen {
+		return rawKey, fmt.Errorf(
+			"key must be %d bytes, got %d",
+			WireGuardKeyLen,
+			len(decoded),
+		)
+	}
+
+	copy(rawKey[:], decoded)
+
+	return rawKey, nil
+}
+
+// DeriveWireGuardPublicKey derives the base64 encoded public key from the
+// given base64 encoded private key, like "wg pubkey" does.
+func DeriveWireGuardPublicKey(privateKey string) (string, error) {
+	rawPrivateKey, err := ParseWireGuardKey(privateKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key: %w", err)
+	}
+
+	rawPublicKey, err := curve25519.X25519(rawPrivateKey[:], curve25519.Basepoint)
+	if err != nil {
+		return "", fmt.Errorf("failed to derive public key: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(rawPublicKey), nil
+}
+
+// ValidateWireGuardKeyPair checks that both keys are well-formed and that
+// the public key derives from the private key.
+//
+// If the keys do not belong together, an error wrapping ErrKeyMismatch is
+// returned.
+func ValidateWireGuardKeyPair(
+	privateKey string,
+	publicKey string,
+) error {
+	derivedPublicKey, err := DeriveWireGuardPublicKey(privateKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = ParseWireGuardKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key: %w", err)
+	}
+
+	if derivedPublicKey != strings.TrimSpace(publicKey) {
+		return ErrKeyMismatch
+	}
+
+	return nil
+}
+
+// ErrPublicKeyMissing is returned if a private key is stored without its
+// public key.
+var ErrPublicKeyMissing = errors.New("public key is missing")
+
 // LoadWireGuardKeyPair loads a WireGuard key pair from the given paths.
 //
-// It reads the private and public key files and returns the contents of both
-// as strings. If any file I/O fails, it returns an error. If the file contents
-// are empty, it returns an error.
+// It reads the private and public key files and returns the trimmed contents
+// of both as strings. An encrypted private key is decrypted with keyCipher.
+// If any file I/O fails, it returns an error. If a key is malformed or the
+// public key does not derive from the private key, it returns an error too.
+//
+// On ErrKeyMismatch both keys are returned as loaded, on ErrPublicKeyMissing
+// the private key is returned, so the caller can repair the public key.
 func LoadWireGuardKeyPair(
 	privateKeyPath string,
 	publicKeyPath string,
+	keyCipher *KeyCipher,
 ) (string, string, error) {
-	privateKey, err := os.ReadFile(privateKeyPath)
+	privateKey, err := ReadSecretKeyFile(privateKeyPath, keyCipher)
 	if err != nil {
 		return "", "", fmt.Errorf("error reading private key: %w", err)
-	} else if len(privateKey) == 0 {
-		return "", "", errors.New("private key is empty")
 	}
 
 	publicKey, err := os.ReadFile(publicKeyPath)
-	if err != nil {
+	if os.IsNotExist(err) {
+		return privateKey, "", ErrPublicKeyMissing
+	} else if err != nil {
 		return "", "", fmt.Errorf("error reading public key: %w", err)
-	} else if len(publicKey) == 0 {
-		return "", "", errors.New("public key is empty")
 	}
 
-	return string(privateKey), string(publicKey), nil
+	publicKeyString := strings.TrimSpace(string(publicKey))
+
+	err = ValidateWireGuardKeyPair(privateKey, publicKeyString)
+	if errors.Is(err, ErrKeyMismatch) {
+		return privateKey, publicKeyString, err
+	} else if err != nil {
+		return "", "", err
+	}
+
+	return privateKey, publicKeyString, nil
 }
 
 // SaveWireGuardKeyPair saves the given WireGuard key pair to the specified file paths.
 //
 // It writes the private key to the provided privateKeyPath, and the public key
 // to the provided publicKeyPath, ensuring that only the owner has read/write
-// permissions on the files.
+// permissions on the files. The private key is encrypted with keyCipher if
+// it is not nil.
 //
 // If writing any of the keys to their respective files fails, the function
 // returns an error.
@@ -82,8 +190,9 @@ func SaveWireGuardKeyPair(
 	publicKeyPath string,
 	privateKey string,
 	publicKey string,
+	keyCipher *KeyCipher,
 ) error {
-	err := os.WriteFile(privateKeyPath, []byte(privateKey), 0600)
+	err := WriteSecretKeyFile(privateKeyPath, privateKey, keyCipher)
 	if err != nil {
 		return fmt.Errorf("error writing private key: %w", err)
 	}
@@ -99,30 +208,138 @@ func SaveWireGuardKeyPair(
 // InitWireGuardKeyPair initializes a new WireGuard key pair if the given
 // files do not exist yet.
 //
-// If the private key and public key files already exist, the function does
-// nothing and returns the contents of both files as strings. Otherwise, it
-// generates a new key pair and writes the private key and public key to the
-// respective files, making sure that only the owner can read them.
+// If the private key and public key files already exist, the function
+// validates them and returns the contents of both files as strings. If the
+// private key file does not exist, it generates a new key pair and writes the
+// private key and public key to the respective files, making sure that only
+// the owner can read them. A missing public key file is re-derived from the
+// existing private key.
+//
+// Existing but invalid keys are never overwritten, an error is returned
+// instead.
 //
-// If any command execution or file I/O fails, the function returns an error.
+// If the key generation or any file I/O fails, the function returns an error.
 func InitWireGuardKeyPair(
 	privateKeyPath string,
 	publicKeyPath string,
+	keyCipher *KeyCipher,
 ) (string, string, error) {
-	privateKey, publicKey, err := LoadWireGuardKeyPair(privateKeyPath, publicKeyPath)
-	if err != nil {
+	_, err := os.Stat(privateKeyPath)
+	if os.IsNotExist(err) {
 		// gen keys and save them
-		privateKey, publicKey, err = GenerateWireGuardKeyPair()
+		privateKey, publicKey, err := GenerateWireGuardKeyPair()
 		if err != nil {
 			return "", "", err
 		}
 
-		err = SaveWireGuardKeyPair(privateKeyPath, publicKeyPath, privateKey, publicKey)
+		err = SaveWireGuardKeyPair(privateKeyPath, publicKeyPath, privateKey, publicKey, keyCipher)
 		if err != nil {
 			return "", "", err
 		}
+
+		return privateKey, publicKey, nil
 	}
 
-	return privateKey, publicKey, nil
+	_, err = os.Stat(publicKeyPath)
+	if os.IsNotExist(err) {
+		// recover the lost public key from the private key
+		privateKey, err := ReadSecretKeyFile(privateKeyPath, keyCipher)
+		if err != nil {
+			return "", "", fmt.Errorf("error reading private key: %w", err)
+		}
+
+		publicKey, err := DeriveWireGuardPublicKey(privateKey)
+		if err != nil {
+			return "", "", err
+		}
+
+		err = os.WriteFile(publicKeyPath, []byte(publicKey), 0600)
+		if err != nil {
+			return "", "", fmt.Errorf("error writing public key: %w", err)
+		}
+
+		return privateKey, publicKey, nil
+	}
+
+	return LoadWireGuardKeyPair(privateKeyPath, publicKeyPath, keyCipher)
+}
+
+// CheckWireGuardKeyPairs validates the existing key pairs of all given
+// targets in the keyStore before any config is written.
+//
+// Targets without keys are skipped, their keys are generated later. A
+// missing public key is re-derived from the private key. If a public key does
+// not derive from its private key, repair is called with the target ID and
+// the public key is re-derived from the private key if it returns true. Every
+// other problem is returned as an error that contains the target ID.
+func CheckWireGuardKeyPairs(
+	keyStore KeyStore,
+	targets []WggTarget,
+	repair func(targetID string) bool,
+) error {
+	for _, target := range targets {
+		privateKey, _, err := keyStore.LoadKeyPair(target.TargetID())
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		} else if errors.Is(err, ErrPublicKeyMissing) ||
+			(errors.Is(err, ErrKeyMismatch) && repair != nil && repair(target.TargetID())) {
+			var publicKey string
+			publicKey, err = DeriveWireGuardPublicKey(privateKey)
+			if err == nil {
+				err = keyStore.SaveKeyPair(target.TargetID(), privateKey, publicKey)
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("invalid key pair of target '%s': %w", target.TargetID(), err)
+		}
+	}
+
+	return nil
+}
+
+// PresharedKeyID returns the ID of the preshared key shared by the two given
+// targets. The ID does not depend on the order of the arguments, so both
+// sides of a peer pair resolve to the same key.
+func PresharedKeyID(targetID string, otherTargetID string) string {
+	if otherTargetID < targetID {
+		targetID, otherTargetID = otherTargetID, targetID
+	}
+
+	return targetID + "-" + otherTargetID
+}
+
+// InitWireGuardPresharedKey loads the preshared key stored at
+// presharedKeyPath, or generates and saves a new one if the file does not
+// exist yet. The preshared key is encrypted with keyCipher if it is not nil.
+//
+// An existing but malformed preshared key is never overwritten, an error is
+// returned instead.
+func InitWireGuardPresharedKey(
+	presharedKeyPath string,
+	keyCipher *KeyCipher,
+) (string, error) {
+	presharedKey, err := ReadSecretKeyFile(presharedKeyPath, keyCipher)
+	if os.IsNotExist(err) {
+		presharedKeyString, err := GenerateWireGuardPresharedKey()
+		if err != nil {
+			return "", err
+		}
+
+		err = WriteSecretKeyFile(presharedKeyPath, presharedKeyString, keyCipher)
+		if err != nil {
+			return "", fmt.Errorf("error writing preshared key: %w", err)
+		}
+
+		return presharedKeyString, nil
+	} else if err != nil {
+		return "", fmt.Errorf("error reading preshared key: %w", err)
+	}
+
+	_, err = ParseWireGuardKey(presharedKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid preshared key: %w", err)
+	}
 
+	return presharedKey, nil
 }
@@ -0,0 +1,156 @@
+package wgg
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDeriveWireGuardPublicKey(t *testing.T) {
+	// RFC 7748 section 6.1 test vector (Alice)
+	tests := []struct {
+		privateKey  string
+		expected    string
+		expectError bool
+	}{
+		{"dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo=", "hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo=", false},
+		{"dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo=\n", "hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo=", false},
+		{"dwdtCnMYpX08FsFyUbJmRd9ML4frwJkq", "", true},
+		{"not base64!", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.privateKey, func(t *testing.T) {
+			publicKey, err := DeriveWireGuardPublicKey(test.privateKey)
+			if test.expectError {
+				if err == nil {
+					t.Errorf("expected error for key %s, but got none", test.privateKey)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("did not expect error for key %s, but got %v", test.privateKey, err)
+				return
+			}
+
+			if publicKey != test.expected {
+				t.Errorf("expected %s, but got %s", test.expected, publicKey)
+			}
+		})
+	}
+}
+
+func TestGenerateWireGuardKeyPair(t *testing.T) {
+	privateKey, publicKey, err := GenerateWireGuardKeyPair()
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	rawPrivateKey, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil {
+		t.Fatalf("private key is not base64: %v", err)
+	} else if len(rawPrivateKey) != WireGuardKeyLen {
+		t.Fatalf("expected %d private key bytes, but got %d", WireGuardKeyLen, len(rawPrivateKey))
+	}
+
+	if rawPrivateKey[0]&7 != 0 || rawPrivateKey[31]&128 != 0 || rawPrivateKey[31]&64 == 0 {
+		t.Errorf("private key is not clamped: %x", rawPrivateKey)
+	}
+
+	derivedPublicKey, err := DeriveWireGuardPublicKey(privateKey)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	if derivedPublicKey != publicKey {
+		t.Errorf("expected public key %s, but got %s", derivedPublicKey, publicKey)
+	}
+}
+
+func TestValidateWireGuardKeyPair(t *testing.T) {
+	privateKey := "dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo="
+	publicKey := "hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo="
+	otherPublicKey := "3p7bfXt9wbTTW2HC7OQ1Nz+DQ8hbeGdNrfx+FG+IK08="
+
+	err := ValidateWireGuardKeyPair(privateKey, publicKey+"\n")
+	if err != nil {
+		t.Errorf("did not expect error, but got %v", err)
+	}
+
+	err = ValidateWireGuardKeyPair(privateKey, otherPublicKey)
+	if !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("expected ErrKeyMismatch, but got %v", err)
+	}
+
+	err = ValidateWireGuardKeyPair(privateKey, publicKey[:20])
+	if err == nil || errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("expected malformed public key error, but got %v", err)
+	}
+}
+
+func TestCheckWireGuardKeyPairs(t *testing.T) {
+	keyDir := t.TempDir()
+	node := WggNode{ID: 0}
+
+	privateKey, _, err := InitWireGuardKeyPair(keyDir+"/n0.key", keyDir+"/n0.pub", nil)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	err = os.WriteFile(keyDir+"/n0.pub", []byte("3p7bfXt9wbTTW2HC7OQ1Nz+DQ8hbeGdNrfx+FG+IK08="), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = CheckWireGuardKeyPairs(NewFileKeyStore(keyDir, nil), []WggTarget{node}, nil)
+	if !errors.Is(err, ErrKeyMismatch) || !strings.Contains(err.Error(), "n0") {
+		t.Errorf("expected ErrKeyMismatch for n0, but got %v", err)
+	}
+
+	err = CheckWireGuardKeyPairs(NewFileKeyStore(keyDir, nil), []WggTarget{node}, func(string) bool { return true })
+	if err != nil {
+		t.Errorf("did not expect error after repair, but got %v", err)
+	}
+
+	_, publicKey, err := LoadWireGuardKeyPair(keyDir+"/n0.key", keyDir+"/n0.pub", nil)
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	expected, _ := DeriveWireGuardPublicKey(privateKey)
+	if publicKey != expected {
+		t.Errorf("expected repaired public key %s, but got %s", expected, publicKey)
+	}
+}
+
+func TestCheckWireGuardKeyPairsMissingPublicKey(t *testing.T) {
+	keyDir := t.TempDir()
+	keyStore := NewKeyring(NewFileKeyStore(keyDir, nil))
+	node := WggNode{ID: 0}
+
+	_, publicKey, err := keyStore.InitKeyPair(node.TargetID())
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	err = os.Remove(keyDir + "/n0.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = CheckWireGuardKeyPairs(keyStore, []WggTarget{node}, func(targetID string) bool {
+		t.Errorf("did not expect a repair prompt for %s", targetID)
+		return false
+	})
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+
+	savedPublicKey, err := os.ReadFile(keyDir + "/n0.pub")
+	if err != nil || string(savedPublicKey) != publicKey {
+		t.Errorf("expected the public key %s to be re-derived, but got %q, %v", publicKey, savedPublicKey, err)
+	}
+}
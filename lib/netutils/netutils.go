@@ -1,8 +1,13 @@
 package netutils
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"math/big"
 	"net"
+	"slices"
+	"strings"
 )
 
 func BroadcastAddress(subnet *net.IPNet) net.IP {
@@ -74,3 +79,225 @@ func IncrementIP(ip net.IP, increment int) net.IP {
 		return resultIP
 	}
 }
+
+// IsHostname returns true if name is a syntactically valid DNS hostname.
+//
+// Labels consist of letters, digits and hyphens, do not start or end with a
+// hyphen and are at most 63 characters long. The last label must not be
+// numeric, so a malformed IPv4 address like "192.168.1.300" is no hostname.
+// A trailing dot is allowed.
+func IsHostname(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if len(name) == 0 || len(name) > 253 {
+		return false
+	}
+
+	labels := strings.Split(name, ".")
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return false
+	}
+
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 ||
+			label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+
+		for _, char := range label {
+			isLetter := (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+			isDigit := char >= '0' && char <= '9'
+			if !isLetter && !isDigit && char != '-' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// CompareIP compares two IP addresses of the same family numerically and
+// returns -1, 0 or +1.
+func CompareIP(a net.IP, b net.IP) int {
+	if a4, b4 := a.To4(), b.To4(); a4 != nil && b4 != nil {
+		return bytes.Compare(a4, b4)
+	}
+
+	return bytes.Compare(a.To16(), b.To16())
+}
+
+// ContainsSubnet returns true if inner lies completely within outer.
+func ContainsSubnet(outer *net.IPNet, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+
+	return outerBits == innerBits && innerOnes >= outerOnes && outer.Contains(inner.IP)
+}
+
+// SubnetsOverlap returns true if the two subnets share at least one address.
+func SubnetsOverlap(a *net.IPNet, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// IPRange is the inclusive range of addresses from First to Last.
+type IPRange struct {
+	First net.IP
+	Last  net.IP
+}
+
+// SubnetRange returns the range of all addresses of the subnet, including its
+// network and broadcast address.
+func SubnetRange(subnet *net.IPNet) IPRange {
+	return IPRange{First: subnet.IP, Last: BroadcastAddress(subnet)}
+}
+
+// ParseIPRange parses "<first>-<last>", a CIDR subnet or a single address.
+func ParseIPRange(value string) (IPRange, error) {
+	if strings.Contains(value, "/") {
+		_, subnet, err := net.ParseCIDR(value)
+		if err != nil {
+			return IPRange{}, err
+		}
+
+		return SubnetRange(subnet), nil
+	}
+
+	firstString, lastString, isRange := strings.Cut(value, "-")
+	first := net.ParseIP(strings.TrimSpace(firstString))
+	last := first
+	if isRange {
+		last = net.ParseIP(strings.TrimSpace(lastString))
+	}
+
+	if first == nil || last == nil {
+		return IPRange{}, errors.New("invalid address range '" + value + "'")
+	} else if (first.To4() == nil) != (last.To4() == nil) {
+		return IPRange{}, errors.New("mixed address families in range '" + value + "'")
+	} else if CompareIP(first, last) > 0 {
+		return IPRange{}, errors.New("the first address is greater than the last in range '" + value + "'")
+	}
+
+	return IPRange{First: first, Last: last}, nil
+}
+
+func (ipRange IPRange) String() string {
+	if ipRange.First.Equal(ipRange.Last) {
+		return ipRange.First.String()
+	}
+
+	return ipRange.First.String() + "-" + ipRange.Last.String()
+}
+
+// Contains returns true if ip lies within the range.
+func (ipRange IPRange) Contains(ip net.IP) bool {
+	return (ip.To4() == nil) == (ipRange.First.To4() == nil) &&
+		CompareIP(ipRange.First, ip) <= 0 && CompareIP(ip, ipRange.Last) <= 0
+}
+
+// Intersect returns the addresses both ranges share and false if there are
+// none.
+func (ipRange IPRange) Intersect(other IPRange) (IPRange, bool) {
+	if (ipRange.First.To4() == nil) != (other.First.To4() == nil) {
+		return IPRange{}, false
+	}
+
+	first := ipRange.First
+	if CompareIP(other.First, first) > 0 {
+		first = other.First
+	}
+	last := ipRange.Last
+	if CompareIP(other.Last, last) < 0 {
+		last = other.Last
+	}
+
+	if CompareIP(first, last) > 0 {
+		return IPRange{}, false
+	}
+
+	return IPRange{First: first, Last: last}, true
+}
+
+// Size returns the number of addresses in the range.
+func (ipRange IPRange) Size() *big.Int {
+	first := new(big.Int).SetBytes(ipRange.First.To16())
+	last := new(big.Int).SetBytes(ipRange.Last.To16())
+
+	return new(big.Int).Add(new(big.Int).Sub(last, first), big.NewInt(1))
+}
+
+// MergeIPRanges returns the ranges sorted, with overlapping and adjacent
+// ranges of the same family merged, so no address is in more than one.
+func MergeIPRanges(ranges []IPRange) []IPRange {
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a IPRange, b IPRange) int {
+		if (a.First.To4() == nil) != (b.First.To4() == nil) {
+			if a.First.To4() != nil {
+				return -1
+			}
+			return 1
+		}
+
+		return CompareIP(a.First, b.First)
+	})
+
+	merged := []IPRange{}
+	for _, ipRange := range sorted {
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+			next := IncrementIP(last.Last, 1)
+			if (last.First.To4() == nil) == (ipRange.First.To4() == nil) &&
+				next != nil && CompareIP(ipRange.First, next) <= 0 {
+				if CompareIP(ipRange.Last, last.Last) > 0 {
+					last.Last = ipRange.Last
+				}
+				continue
+			}
+		}
+
+		merged = append(merged, ipRange)
+	}
+
+	return merged
+}
+
+// NewULAPrefix returns a new RFC 4193 unique local /64 prefix with the 40 bit
+// global ID read from random, e.g. crypto/rand.Reader, and subnet ID 0.
+func NewULAPrefix(random io.Reader) (*net.IPNet, error) {
+	ip := make(net.IP, net.IPv6len)
+	ip[0] = 0xfd
+	_, err := io.ReadFull(random, ip[1:6])
+	if err != nil {
+		return nil, err
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(64, 128)}, nil
+}
+
+// HostOffset returns the distance of ip from the network address of the
+// subnet.
+func HostOffset(subnet *net.IPNet, ip net.IP) *big.Int {
+	first := new(big.Int).SetBytes(subnet.IP.To16())
+	address := new(big.Int).SetBytes(ip.To16())
+
+	return address.Sub(address, first)
+}
+
+// AddOffset returns the address at the given distance from the network
+// address of the subnet, or nil if it is not in the subnet.
+func AddOffset(subnet *net.IPNet, offset *big.Int) net.IP {
+	address := new(big.Int).Add(new(big.Int).SetBytes(subnet.IP.To16()), offset)
+	addressBytes := address.Bytes()
+	if len(addressBytes) > net.IPv6len {
+		return nil
+	}
+
+	ip := make(net.IP, net.IPv6len)
+	copy(ip[net.IPv6len-len(addressBytes):], addressBytes)
+	if ipv4 := ip.To4(); ipv4 != nil && subnet.IP.To4() != nil {
+		ip = ipv4
+	}
+	if !subnet.Contains(ip) {
+		return nil
+	}
+
+	return ip
+}
@@ -0,0 +1,295 @@
+package netutils
+
+import (
+	"bytes"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+)
+
+func mustParseCIDR(t *testing.T, value string) *net.IPNet {
+	_, subnet, err := net.ParseCIDR(value)
+	if err != nil {
+		t.Fatalf("cant parse subnet %s: %v", value, err)
+	}
+
+	return subnet
+}
+
+func mustParseIPRange(t *testing.T, value string) IPRange {
+	ipRange, err := ParseIPRange(value)
+	if err != nil {
+		t.Fatalf("cant parse range %s: %v", value, err)
+	}
+
+	return ipRange
+}
+
+func TestCompareIP(t *testing.T) {
+	tests := []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{"10.0.0.1", "10.0.0.2", -1},
+		{"10.0.0.2", "10.0.0.1", 1},
+		{"10.0.0.1", "::ffff:10.0.0.1", 0},
+		{"10.0.1.0", "10.0.0.255", 1},
+		{"fd00::1", "fd00::1:0", -1},
+		{"fd00::1", "fd00::1", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.a+" "+test.b, func(t *testing.T) {
+			result := CompareIP(net.ParseIP(test.a), net.ParseIP(test.b))
+			if result != test.expected {
+				t.Errorf("expected %d, but got %d", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestContainsSubnet(t *testing.T) {
+	tests := []struct {
+		outer    string
+		inner    string
+		contains bool
+		overlaps bool
+	}{
+		{"10.10.0.0/16", "10.10.10.0/24", true, true},
+		{"10.10.10.0/24", "10.10.0.0/16", false, true},
+		{"10.10.10.0/24", "10.10.10.0/24", true, true},
+		{"10.10.10.0/24", "10.10.11.0/24", false, false},
+		{"fd00::/48", "fd00:0:0:1::/64", true, true},
+		{"fd00::/64", "fd00:0:0:1::/64", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.outer+" "+test.inner, func(t *testing.T) {
+			outer, inner := mustParseCIDR(t, test.outer), mustParseCIDR(t, test.inner)
+			if ContainsSubnet(outer, inner) != test.contains {
+				t.Errorf("expected ContainsSubnet to be %v", test.contains)
+			}
+			if SubnetsOverlap(outer, inner) != test.overlaps || SubnetsOverlap(inner, outer) != test.overlaps {
+				t.Errorf("expected SubnetsOverlap to be %v", test.overlaps)
+			}
+		})
+	}
+}
+
+func TestParseIPRange(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    string
+		expectError bool
+	}{
+		{"10.10.10.5", "10.10.10.5", false},
+		{"10.10.10.5-10.10.10.9", "10.10.10.5-10.10.10.9", false},
+		{"10.10.10.5 - 10.10.10.9", "10.10.10.5-10.10.10.9", false},
+		{"10.10.10.0/30", "10.10.10.0-10.10.10.3", false},
+		{"fd00::1-fd00::ff", "fd00::1-fd00::ff", false},
+		{"10.10.10.9-10.10.10.5", "", true},
+		{"10.10.10.5-fd00::1", "", true},
+		{"10.10.10.300", "", true},
+		{"10.10.10.0/33", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			ipRange, err := ParseIPRange(test.input)
+			if test.expectError {
+				if err == nil {
+					t.Errorf("expected error for range %s, but got none", test.input)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("did not expect error for range %s, but got %v", test.input, err)
+			}
+
+			if ipRange.String() != test.expected {
+				t.Errorf("expected %s, but got %s", test.expected, ipRange.String())
+			}
+		})
+	}
+}
+
+func TestIPRangeSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"10.10.10.5", "1"},
+		{"10.10.10.0/24", "256"},
+		{"10.10.10.250-10.10.11.5", "12"},
+		{"fd00::/64", "18446744073709551616"},
+		{"fd00::/48", "1208925819614629174706176"},
+		{"::/0", "340282366920938463463374607431768211456"},
+		{"fd00::fffe-fd00::1:1", "4"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			size := mustParseIPRange(t, test.input).Size()
+			if size.String() != test.expected {
+				t.Errorf("expected size %s, but got %s", test.expected, size)
+			}
+		})
+	}
+}
+
+func TestIPRangeIntersect(t *testing.T) {
+	tests := []struct {
+		a        string
+		b        string
+		expected string
+	}{
+		{"10.10.10.0/24", "10.10.10.128/25", "10.10.10.128-10.10.10.255"},
+		{"10.10.10.1-10.10.10.9", "10.10.10.5-10.10.10.20", "10.10.10.5-10.10.10.9"},
+		{"10.10.10.1-10.10.10.9", "10.10.10.9-10.10.10.20", "10.10.10.9"},
+		{"10.10.10.1-10.10.10.9", "10.10.10.10-10.10.10.20", ""},
+		{"10.10.10.0/24", "fd00::/64", ""},
+		{"fd00::/64", "fd00::10-fd00:0:0:1::10", "fd00::10-fd00::ffff:ffff:ffff:ffff"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.a+" "+test.b, func(t *testing.T) {
+			a, b := mustParseIPRange(t, test.a), mustParseIPRange(t, test.b)
+			intersection, ok := a.Intersect(b)
+			if len(test.expected) <= 0 {
+				if ok {
+					t.Errorf("expected no intersection, but got %s", intersection)
+				}
+				return
+			}
+
+			if !ok || intersection.String() != test.expected {
+				t.Errorf("expected %s, but got %s, %v", test.expected, intersection, ok)
+			}
+			if !a.Contains(intersection.First) || !b.Contains(intersection.Last) {
+				t.Errorf("expected %s to lie in both ranges", intersection)
+			}
+		})
+	}
+}
+
+func TestMergeIPRanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []string
+		expected []string
+	}{
+		{"empty", []string{}, []string{}},
+		{"disjoint", []string{"10.0.0.5", "10.0.0.1"}, []string{"10.0.0.1", "10.0.0.5"}},
+		{"adjacent", []string{"10.0.0.1-10.0.0.4", "10.0.0.5-10.0.0.9"}, []string{"10.0.0.1-10.0.0.9"}},
+		{"adjacent across octets", []string{"10.0.1.0/24", "10.0.0.0/24"}, []string{"10.0.0.0-10.0.1.255"}},
+		{"overlapping", []string{"10.0.0.1-10.0.0.6", "10.0.0.4-10.0.0.9"}, []string{"10.0.0.1-10.0.0.9"}},
+		{"contained", []string{"10.0.0.0/24", "10.0.0.10-10.0.0.20"}, []string{"10.0.0.0-10.0.0.255"}},
+		{"duplicate", []string{"10.0.0.7", "10.0.0.7"}, []string{"10.0.0.7"}},
+		{"mixed families", []string{"fd00::1-fd00::5", "10.0.0.1", "fd00::6"}, []string{"10.0.0.1", "fd00::1-fd00::6"}},
+		{"ipv6 overlapping", []string{"fd00::/64", "fd00::ffff-fd00:0:0:1::1"}, []string{"fd00::-fd00:0:0:1::1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ranges := []IPRange{}
+			for _, value := range test.input {
+				ranges = append(ranges, mustParseIPRange(t, value))
+			}
+
+			merged := MergeIPRanges(ranges)
+			if len(merged) != len(test.expected) {
+				t.Fatalf("expected %v, but got %v", test.expected, merged)
+			}
+			for i, ipRange := range merged {
+				if ipRange.String() != test.expected[i] {
+					t.Errorf("expected %v, but got %v", test.expected, merged)
+				}
+			}
+		})
+	}
+}
+
+func TestOffsets(t *testing.T) {
+	tests := []struct {
+		subnet   string
+		offset   int64
+		expected string
+	}{
+		{"10.10.10.0/24", 0, "10.10.10.0"},
+		{"10.10.10.0/24", 255, "10.10.10.255"},
+		{"10.10.10.0/24", 256, ""},
+		{"10.10.10.0/24", -1, ""},
+		{"255.255.255.0/24", 256, ""},
+		{"fd00::/64", 1, "fd00::1"},
+		{"fd00::/64", 1 << 62, "fd00::4000:0:0:0"},
+		{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ff00/120", 256, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.subnet, func(t *testing.T) {
+			subnet := mustParseCIDR(t, test.subnet)
+			ip := AddOffset(subnet, big.NewInt(test.offset))
+			if len(test.expected) <= 0 {
+				if ip != nil {
+					t.Errorf("expected no address for offset %d, but got %s", test.offset, ip)
+				}
+				return
+			}
+
+			if ip == nil || ip.String() != test.expected {
+				t.Fatalf("expected %s for offset %d, but got %v", test.expected, test.offset, ip)
+			}
+			if offset := HostOffset(subnet, ip); offset.Int64() != test.offset {
+				t.Errorf("expected offset %d of %s, but got %s", test.offset, ip, offset)
+			}
+		})
+	}
+}
+
+func TestNewULAPrefix(t *testing.T) {
+	prefix, err := NewULAPrefix(bytes.NewReader([]byte{1, 2, 3, 4, 5, 6}))
+	if err != nil {
+		t.Fatalf("did not expect error, but got %v", err)
+	}
+	if prefix.String() != "fd01:203:405::/64" {
+		t.Errorf("expected fd01:203:405::/64, but got %s", prefix)
+	}
+
+	_, err = NewULAPrefix(bytes.NewReader([]byte{1, 2}))
+	if err == nil {
+		t.Errorf("expected error for a short random source, but got none")
+	}
+}
+
+func TestIsHostname(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"vpn1", true},
+		{"vpn1.example.com", true},
+		{"vpn1.example.com.", true},
+		{"123.example.com", true},
+		{"vpn-1.example.com", true},
+		{"", false},
+		{".", false},
+		{"vpn_1.example.com", false},
+		{"-vpn.example.com", false},
+		{"vpn-.example.com", false},
+		{"vpn1..example.com", false},
+		{"vpn1.123", false},
+		{"192.168.1.300", false},
+		{"1234", false},
+		{"a" + strings.Repeat("b", 63) + ".example.com", false},
+		{strings.Repeat("a.", 127) + "com", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			if IsHostname(test.input) != test.expected {
+				t.Errorf("expected IsHostname(%q) to be %v", test.input, test.expected)
+			}
+		})
+	}
+}
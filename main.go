@@ -1,13 +1,14 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
-	"log"
-	"net"
 	"os"
+	"strings"
 
 	wgg "github.com/CoreUnit-NET/wgg/internal"
-	"github.com/joho/godotenv"
+	"github.com/CoreUnit-NET/wgg/lib/userin"
 )
 
 var DisplayName string = "Unset"
@@ -15,81 +16,249 @@ var ShortName string = "unset"
 var Version string = "?.?.?"
 var Commit string = "???????"
 
-func main() {
-	fmt.Println(DisplayName + " version v" + Version + ", build " + Commit)
+// Exit codes of wgg.
+const (
+	ExitOK = 0
+	// ExitFailure is returned if a command failed.
+	ExitFailure = 1
+	// ExitUsage is returned for an invalid command line.
+	ExitUsage = 2
+	// ExitProblems is returned if a command completed, but reported
+	// problems, e.g. an import with conflicting keys.
+	ExitProblems = 3
+	// ExitInvalid is returned if the configuration is invalid.
+	ExitInvalid = 4
+)
 
-	err := godotenv.Load()
-	if err == nil {
-		fmt.Println("Environment variables from .env loaded")
-	}
+// UsageError is returned by a command for an invalid command line. An empty
+// Message means the flag package already reported the error.
+type UsageError struct {
+	Message string
+}
+
+func (err UsageError) Error() string {
+	return err.Message
+}
 
-	// err = Test()
-	// if err != nil {
-	// 	log.Fatalln(err.Error())
-	// }
+// ErrProblems is returned by a command that already reported its problems.
+var ErrProblems = errors.New("completed with problems")
+
+// Command is a wgg subcommand.
+type Command struct {
+	Name    string
+	Summary string
+	Run     func(args []string) error
+}
+
+// Commands lists all subcommands in the order of the usage.
+var Commands = []Command{
+	{"generate", "generate all node and client configs", GenerateCommand},
+	{"list", "list the nodes or clients and their addresses", ListCommand},
+	{"show", "print the config of a node or client", ShowCommand},
+	{"add-client", "add a client to the inventory", AddClientCommand},
+	{"remove-client", "remove a client from the inventory and archive its keys", RemoveClientCommand},
+	{"keys", "list the public keys of all targets", KeysCommand},
+	{"rotate", "re-key targets and regenerate all configs", RotateCommand},
+	{"import", "import the keys of existing wg-quick configs", ImportCommand},
+	{"validate", "check the whole configuration without writing anything", ValidateCommand},
+	{"version", "print the version", VersionCommand},
+}
+
+// envFlags are accepted by every command. A set flag overrides its env var
+// and the .env file.
+var envFlags = []struct {
+	Name   string
+	Env    string
+	IsBool bool
+	Usage  string
+}{
+	{"profile", "WGG_PROFILE", false, "profile whose .env.<profile> file is loaded after .env"},
+	{"inventory", "WGG_INVENTORY", false, "path of a .yaml, .toml or .json inventory file"},
+	{"subnet", "WGG_SUBNET", false, "WireGuard subnet in CIDR notation"},
+	{"out-dir", "WGG_OUT_DIR", false, "output dir of the configs and keys"},
+	{"client-count", "WGG_CLIENT_COUNT", false, "number of clients without inventory"},
+	{"endpoint-preference", "WGG_ENDPOINT_PREFERENCE", false, "default endpoint of dual-stack nodes for clients: v4, v6 or v6-first"},
+	{"keystore", "WGG_KEYSTORE", false, "key store backend: file or vault"},
+	{"preshared-keys", "WGG_PRESHARED_KEYS", true, "add a per-pair PresharedKey to every peer"},
+	{"node-side-keys", "WGG_NODE_SIDE_KEYS", true, "keep the node private keys on the nodes"},
+	{"workers", "WGG_WORKERS", false, "number of configs rendered concurrently"},
+}
 
-	subnetString := os.Getenv("WGG_SUBNET")
-	if len(subnetString) <= 0 {
-		log.Fatalln("the WGG_SUBNET env var is not set or empty")
+// envFlag sets its env var when the flag is set.
+type envFlag struct {
+	env    string
+	isBool bool
+}
+
+func (value *envFlag) String() string {
+	return ""
+}
+
+func (value *envFlag) Set(rawValue string) error {
+	return os.Setenv(value.env, rawValue)
+}
+
+func (value *envFlag) IsBoolFlag() bool {
+	return value.isBool
+}
+
+// loadedEnvFiles lists the env files loaded by ParseFlags.
+var loadedEnvFiles []string
+
+// NewFlagSet returns a FlagSet with the envFlags for the given command.
+func NewFlagSet(name string, args string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: "+ShortName+" [flags] "+name+" [flags] "+args)
+		flags.PrintDefaults()
 	}
 
-	_, subnet, err := net.ParseCIDR(subnetString)
-	if err != nil {
-		log.Fatalln(
-			"error while parsing WGG_SUBNET env var as CIDR: value '" +
-				subnetString + "': " +
-				err.Error(),
+	for _, envFlagDef := range envFlags {
+		flags.Var(
+			&envFlag{env: envFlagDef.Env, isBool: envFlagDef.IsBool},
+			envFlagDef.Name,
+			envFlagDef.Usage+", overrides "+envFlagDef.Env,
 		)
 	}
 
-	outDir, keyDir, err := wgg.InitOutDir()
-	if err != nil {
-		log.Fatalln(err.Error())
+	return flags
+}
+
+// ParseFlags parses the command line of a command and loads the env files of
+// the profile afterwards, so the flags take precedence over them. It returns the
+// positional args and a UsageError if their count is not in [minArgs,
+// maxArgs], a negative maxArgs allows any number.
+func ParseFlags(
+	flags *flag.FlagSet,
+	args []string,
+	minArgs int,
+	maxArgs int,
+) ([]string, error) {
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil, err
+	} else if err != nil {
+		return nil, UsageError{}
 	}
 
-	fmt.Println("Output dir: " + outDir)
-	err = wgg.CleanUpOutDir(outDir)
-	if err != nil {
-		log.Fatalln(err.Error())
+	positional := flags.Args()
+	if len(positional) < minArgs || (maxArgs >= 0 && len(positional) > maxArgs) {
+		flags.Usage()
+		return nil, UsageError{"invalid number of arguments for " + flags.Name()}
 	}
 
-	nodeList, err := wgg.InitNodeList()
+	loadedEnvFiles, err = wgg.LoadEnvFiles(os.Getenv("WGG_PROFILE"))
 	if err != nil {
-		log.Fatalln(err.Error())
+		return nil, err
 	}
 
-	wgg.PrintNodes(subnet, nodeList)
+	return positional, nil
+}
 
-	clientList, err := wgg.InitClientList()
-	if err != nil {
-		log.Fatalln(err.Error())
+func main() {
+	os.Exit(Run(os.Args[1:]))
+}
+
+// Run runs the command line and returns the exit code.
+func Run(args []string) int {
+	globalFlags := NewFlagSet("<command>", "")
+	globalFlags.Usage = PrintUsage
+
+	err := globalFlags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return ExitOK
+	} else if err != nil {
+		return ExitUsage
 	}
 
-	wgg.PrintClients(subnet, clientList)
+	args = globalFlags.Args()
+	if len(args) == 0 {
+		PrintUsage()
+		return ExitUsage
+	}
 
-	err = wgg.GenerateNodeConfigs(
-		subnet,
-		outDir,
-		keyDir,
-		nodeList,
-		clientList,
-	)
-	if err != nil {
-		log.Fatalln(err.Error())
+	for _, command := range Commands {
+		if command.Name != args[0] {
+			continue
+		}
+
+		err = command.Run(args[1:])
+
+		var usageError UsageError
+		var validationError *wgg.ValidationError
+		switch {
+		case err == nil:
+			return ExitOK
+		case errors.Is(err, flag.ErrHelp):
+			return ExitOK
+		case errors.As(err, &usageError):
+			if len(usageError.Message) > 0 {
+				fmt.Fprintln(os.Stderr, "Error: "+usageError.Message)
+			}
+			return ExitUsage
+		case errors.Is(err, ErrProblems):
+			return ExitProblems
+		case errors.As(err, &validationError):
+			fmt.Fprintln(os.Stderr, "Error: "+err.Error())
+			return ExitInvalid
+		default:
+			fmt.Fprintln(os.Stderr, "Error: "+err.Error())
+			return ExitFailure
+		}
 	}
 
-	err = wgg.GenerateClientConfigs(
-		subnet,
-		outDir,
-		keyDir,
-		nodeList,
-		clientList,
+	fmt.Fprintln(os.Stderr, "Error: unknown command '"+args[0]+"'")
+	PrintUsage()
+
+	return ExitUsage
+}
+
+// PrintUsage prints the commands and the flags every command accepts.
+func PrintUsage() {
+	output := os.Stderr
+
+	fmt.Fprintln(output, "Usage: "+ShortName+" [flags] <command> [flags] [args]")
+	fmt.Fprintln(output, "")
+	fmt.Fprintln(output, "Commands:")
+	for _, command := range Commands {
+		fmt.Fprintf(output, "  %-14s %s\n", command.Name, command.Summary)
+	}
+	fmt.Fprintln(output, "")
+	fmt.Fprintln(output, "Flags:")
+	NewFlagSet("", "").PrintDefaults()
+	fmt.Fprintln(output, "")
+	fmt.Fprintln(output, "Flags override env vars, env vars override .env.<profile>, which overrides .env.")
+	fmt.Fprintln(output, "Run '"+ShortName+" <command> -h' for the usage of a command.")
+}
+
+// PrintVersion prints the version header of action commands.
+func PrintVersion() {
+	fmt.Println(DisplayName + " version v" + Version + ", build " + Commit)
+	if len(loadedEnvFiles) > 0 {
+		fmt.Println("Environment variables from " + strings.Join(loadedEnvFiles, ", ") + " loaded")
+	}
+	profile := os.Getenv("WGG_PROFILE")
+	if len(profile) > 0 {
+		fmt.Println("Profile: " + profile)
+	}
+}
+
+// ConfirmKeyRepair asks the user whether the mismatching public key of the
+// given target should be re-derived from its private key.
+func ConfirmKeyRepair(targetID string) bool {
+	fmt.Println(
+		"The public key of '" + targetID + "' does not match its private key.\n" +
+			"Re-derive it from the private key? [y/N]",
 	)
+
+	answer, err := userin.ReadLine()
 	if err != nil {
-		log.Fatalln(err.Error())
+		return false
 	}
 
-	fmt.Println("Everything is ready in " + outDir)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
 }
 
 // func Test() error {